@@ -0,0 +1,407 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vixa/cdn/internal/accesslog"
+	"github.com/vixa/cdn/internal/bot"
+	"github.com/vixa/cdn/internal/cdn"
+	"github.com/vixa/cdn/internal/config"
+	"github.com/vixa/cdn/internal/storage"
+)
+
+type Config struct {
+	BotToken      string
+	DiscordStatus string
+
+	StorageBackend    string
+	StoragePath       string
+	DomainsConfig     string
+	CategoriesConfig  string
+	SettingsPath      string
+	APIKeysPath       string
+	MetadataPath      string
+	SigningSecretPath string
+
+	ListenAddr      string
+	AdminListenAddr string
+	HTTPTimeouts    cdn.Timeouts
+	DownloadTimeout time.Duration
+
+	ExpiryCheckInterval time.Duration
+	TrashRetention      time.Duration
+	MaxUploadSize       int64
+	ConfigPollInterval  time.Duration
+
+	SigningSecret string
+
+	AccessLogFormat         string
+	AccessLogFile           string
+	AccessLogMaxSizeMB      int
+	AccessLogRotateInterval time.Duration
+	AccessLogMaxBackups     int
+	TrustProxyHeaders       bool
+
+	ImageMaxDimension    int
+	ImageMaxVariants     int
+	ImageMaxSourcePixels int
+
+	S3 storage.S3Config
+
+	// settings is every setting as it was resolved, in the order it was
+	// read, for printing
+	settings []setting
+}
+
+// setting is one resolved configuration value and where it came from.
+type setting struct {
+	key    string
+	value  string
+	source string
+	secret bool
+}
+
+// loadConfig resolves the configuration from, in increasing order of
+// precedence, the defaults, the JSON file named by CONFIG_FILE and the
+// environment. Every value that is missing, malformed or out of range is
+// reported in the returned error.
+func loadConfig() (*Config, error) {
+	src, err := newConfigSource(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		return nil, err
+	}
+
+	port := src.int("PORT", 8080)
+	adminPort := src.int("ADMIN_PORT", 0)
+	adminAddr := ""
+	if adminPort != 0 {
+		adminAddr = fmt.Sprintf(":%d", adminPort)
+	}
+	configDir := src.string("CONFIG_DIR", "/app/configs")
+
+	cfg := &Config{
+		BotToken:      src.secret("BOT_TOKEN", ""),
+		DiscordStatus: src.string("DISCORD_STATUS", bot.DefaultStatus),
+
+		StorageBackend:    src.string("STORAGE_BACKEND", "filesystem"),
+		StoragePath:       src.string("STORAGE_PATH", "/app/storage"),
+		DomainsConfig:     src.string("DOMAINS_CONFIG", filepath.Join(configDir, "domains.json")),
+		CategoriesConfig:  src.string("CATEGORIES_CONFIG", filepath.Join(configDir, "categories.json")),
+		SettingsPath:      src.string("SETTINGS_CONFIG", filepath.Join(configDir, "settings.json")),
+		APIKeysPath:       src.string("API_KEYS_CONFIG", filepath.Join(configDir, "api-keys.json")),
+		MetadataPath:      src.string("METADATA_PATH", filepath.Join(configDir, "metadata.jsonl")),
+		SigningSecretPath: src.string("URL_SIGNING_SECRET_FILE", filepath.Join(configDir, "signing.key")),
+
+		ListenAddr:      src.string("LISTEN_ADDR", fmt.Sprintf(":%d", port)),
+		AdminListenAddr: src.string("ADMIN_LISTEN_ADDR", adminAddr),
+		HTTPTimeouts: cdn.Timeouts{
+			ReadHeader: src.duration("HTTP_READ_HEADER_TIMEOUT", cdn.DefaultTimeouts.ReadHeader),
+			Read:       src.duration("HTTP_READ_TIMEOUT", cdn.DefaultTimeouts.Read),
+			Write:      src.duration("HTTP_WRITE_TIMEOUT", cdn.DefaultTimeouts.Write),
+			Idle:       src.duration("HTTP_IDLE_TIMEOUT", cdn.DefaultTimeouts.Idle),
+		},
+		DownloadTimeout: src.duration("DOWNLOAD_TIMEOUT", storage.DefaultDownloadTimeout),
+
+		ExpiryCheckInterval: src.duration("EXPIRY_CHECK_INTERVAL", time.Minute),
+		TrashRetention:      src.duration("TRASH_RETENTION", 30*24*time.Hour),
+		MaxUploadSize:       src.size("MAX_UPLOAD_SIZE", cdn.DefaultMaxUploadSize),
+		ConfigPollInterval:  src.duration("CONFIG_POLL_INTERVAL", 10*time.Second),
+
+		SigningSecret: src.secret("URL_SIGNING_SECRET", ""),
+
+		AccessLogFormat:         src.string("ACCESS_LOG_FORMAT", ""),
+		AccessLogFile:           src.string("ACCESS_LOG_FILE", ""),
+		AccessLogMaxSizeMB:      src.int("ACCESS_LOG_MAX_SIZE_MB", 100),
+		AccessLogRotateInterval: src.duration("ACCESS_LOG_ROTATE_INTERVAL", 24*time.Hour),
+		AccessLogMaxBackups:     src.int("ACCESS_LOG_MAX_BACKUPS", 7),
+		TrustProxyHeaders:       src.bool("TRUST_PROXY_HEADERS", false),
+
+		ImageMaxDimension:    src.int("IMAGE_MAX_DIMENSION", cdn.DefaultImageLimits.MaxDimension),
+		ImageMaxVariants:     src.int("IMAGE_MAX_VARIANTS", cdn.DefaultImageLimits.MaxVariants),
+		ImageMaxSourcePixels: src.int("IMAGE_MAX_SOURCE_PIXELS", cdn.DefaultImageLimits.MaxSourcePixels),
+
+		S3: storage.S3Config{
+			Endpoint:        src.string("S3_ENDPOINT", ""),
+			Region:          src.string("S3_REGION", "us-east-1"),
+			Bucket:          src.string("S3_BUCKET", ""),
+			AccessKeyID:     src.string("S3_ACCESS_KEY_ID", ""),
+			SecretAccessKey: src.secret("S3_SECRET_ACCESS_KEY", ""),
+			Prefix:          src.string("S3_PREFIX", ""),
+			PathStyle:       src.bool("S3_PATH_STYLE", true),
+			Timeout:         src.duration("S3_TIMEOUT", 5*time.Minute),
+		},
+	}
+	cfg.settings = src.settings
+
+	errs := append(src.errs, src.unknownKeys()...)
+	errs = append(errs, cfg.validate()...)
+	return cfg, errors.Join(errs...)
+}
+
+// runConfig implements the config subcommand, which prints the effective
+// configuration and exits with 1 when it is invalid.
+func runConfig() {
+	cfg, err := loadConfig()
+	if cfg != nil {
+		cfg.print()
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	log.Printf("[Main] Configuration is valid")
+}
+
+// validate checks the values that parsed but can't work.
+func (cfg *Config) validate() []error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	switch cfg.StorageBackend {
+	case "filesystem", "fs":
+		check(cfg.StoragePath != "", "STORAGE_PATH must not be empty")
+	case "s3":
+		check(cfg.S3.Bucket != "", "S3_BUCKET is required for the s3 storage backend")
+		check(cfg.S3.AccessKeyID != "" && cfg.S3.SecretAccessKey != "", "S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required for the s3 storage backend")
+		check(cfg.S3.Timeout > 0, "S3_TIMEOUT must be positive")
+	default:
+		errs = append(errs, fmt.Errorf("STORAGE_BACKEND must be filesystem or s3, not '%s'", cfg.StorageBackend))
+	}
+
+	for key, path := range map[string]string{
+		"DOMAINS_CONFIG":          cfg.DomainsConfig,
+		"CATEGORIES_CONFIG":       cfg.CategoriesConfig,
+		"SETTINGS_CONFIG":         cfg.SettingsPath,
+		"API_KEYS_CONFIG":         cfg.APIKeysPath,
+		"METADATA_PATH":           cfg.MetadataPath,
+		"URL_SIGNING_SECRET_FILE": cfg.SigningSecretPath,
+	} {
+		check(path != "", "%s must not be empty", key)
+	}
+
+	check(validListenAddr(cfg.ListenAddr), "LISTEN_ADDR must be host:port or :port, not '%s'", cfg.ListenAddr)
+	if cfg.AdminListenAddr != "" {
+		check(validListenAddr(cfg.AdminListenAddr), "ADMIN_LISTEN_ADDR must be host:port or :port, not '%s'", cfg.AdminListenAddr)
+		check(cfg.AdminListenAddr != cfg.ListenAddr, "ADMIN_LISTEN_ADDR must differ from LISTEN_ADDR")
+	}
+
+	for key, d := range map[string]time.Duration{
+		"HTTP_READ_HEADER_TIMEOUT":   cfg.HTTPTimeouts.ReadHeader,
+		"HTTP_READ_TIMEOUT":          cfg.HTTPTimeouts.Read,
+		"HTTP_WRITE_TIMEOUT":         cfg.HTTPTimeouts.Write,
+		"HTTP_IDLE_TIMEOUT":          cfg.HTTPTimeouts.Idle,
+		"DOWNLOAD_TIMEOUT":           cfg.DownloadTimeout,
+		"CONFIG_POLL_INTERVAL":       cfg.ConfigPollInterval,
+		"ACCESS_LOG_ROTATE_INTERVAL": cfg.AccessLogRotateInterval,
+	} {
+		check(d >= 0, "%s must not be negative", key)
+	}
+	check(cfg.ExpiryCheckInterval > 0, "EXPIRY_CHECK_INTERVAL must be positive")
+	check(cfg.TrashRetention > 0, "TRASH_RETENTION must be positive")
+	check(cfg.MaxUploadSize >= 0, "MAX_UPLOAD_SIZE must not be negative")
+
+	if cfg.AccessLogFormat != "" && cfg.AccessLogFormat != "off" {
+		if _, err := accesslog.ParseFormat(cfg.AccessLogFormat); err != nil {
+			errs = append(errs, fmt.Errorf("ACCESS_LOG_FORMAT: %w", err))
+		}
+	}
+	check(cfg.AccessLogMaxSizeMB >= 0, "ACCESS_LOG_MAX_SIZE_MB must not be negative")
+	check(cfg.AccessLogMaxBackups >= 0, "ACCESS_LOG_MAX_BACKUPS must not be negative")
+
+	check(cfg.ImageMaxDimension > 0, "IMAGE_MAX_DIMENSION must be positive")
+	check(cfg.ImageMaxVariants > 0, "IMAGE_MAX_VARIANTS must be positive")
+	check(cfg.ImageMaxSourcePixels > 0, "IMAGE_MAX_SOURCE_PIXELS must be positive")
+
+	// Map iteration order is random; keep the report stable
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errs
+}
+
+func validListenAddr(addr string) bool {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
+
+// print logs the effective configuration, with secrets masked.
+func (cfg *Config) print() {
+	log.Printf("[Main] Effective configuration:")
+	for _, s := range cfg.settings {
+		value := s.value
+		if s.secret && value != "" {
+			value = "(set)"
+		} else if value == "" {
+			value = "(empty)"
+		}
+		log.Printf("[Main]   %-26s %s [%s]", s.key, value, s.source)
+	}
+}
+
+// configSource looks settings up in the environment, then in the config
+// file, and records parse errors instead of silently using the default.
+type configSource struct {
+	path     string
+	file     map[string]string
+	used     map[string]bool
+	settings []setting
+	errs     []error
+}
+
+// newConfigSource reads the config file at path, if any. The file is a JSON
+// object whose keys are the environment variable names, e.g.
+// {"STORAGE_PATH": "/srv/vixa", "MAX_UPLOAD_SIZE": "100MB"}.
+func newConfigSource(path string) (*configSource, error) {
+	src := &configSource{path: path, file: make(map[string]string), used: make(map[string]bool)}
+	if path == "" {
+		return src, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var values map[string]any
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	for key, value := range values {
+		switch v := value.(type) {
+		case string:
+			src.file[key] = v
+		case float64:
+			src.file[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			src.file[key] = strconv.FormatBool(v)
+		default:
+			return nil, fmt.Errorf("config file %s: %s must be a string, number or boolean", path, key)
+		}
+	}
+	return src, nil
+}
+
+// lookup returns the raw value of key, or ok false if neither the
+// environment nor the config file sets it.
+func (src *configSource) lookup(key string, secret bool) (value string, ok bool) {
+	src.used[key] = true
+	source := "default"
+	if v := os.Getenv(key); v != "" {
+		value, source, ok = v, "env", true
+	} else if v, found := src.file[key]; found {
+		value, source, ok = v, "file", true
+	}
+	if ok {
+		src.settings = append(src.settings, setting{key: key, value: value, source: source, secret: secret})
+	}
+	return value, ok
+}
+
+// resolved records a setting that was left at its default.
+func (src *configSource) resolved(key, value string, secret bool) {
+	src.settings = append(src.settings, setting{key: key, value: value, source: "default", secret: secret})
+}
+
+func (src *configSource) invalid(key, value, expected string) {
+	src.errs = append(src.errs, fmt.Errorf("%s: '%s' is not %s", key, value, expected))
+}
+
+func (src *configSource) string(key, defaultValue string) string {
+	if value, ok := src.lookup(key, false); ok {
+		return value
+	}
+	src.resolved(key, defaultValue, false)
+	return defaultValue
+}
+
+func (src *configSource) secret(key, defaultValue string) string {
+	if value, ok := src.lookup(key, true); ok {
+		return value
+	}
+	src.resolved(key, defaultValue, true)
+	return defaultValue
+}
+
+func (src *configSource) int(key string, defaultValue int) int {
+	if value, ok := src.lookup(key, false); ok {
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			src.invalid(key, value, "a whole number")
+			return defaultValue
+		}
+		return n
+	}
+	src.resolved(key, strconv.Itoa(defaultValue), false)
+	return defaultValue
+}
+
+func (src *configSource) bool(key string, defaultValue bool) bool {
+	if value, ok := src.lookup(key, false); ok {
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			src.invalid(key, value, "true or false")
+			return defaultValue
+		}
+		return b
+	}
+	src.resolved(key, strconv.FormatBool(defaultValue), false)
+	return defaultValue
+}
+
+func (src *configSource) duration(key string, defaultValue time.Duration) time.Duration {
+	if value, ok := src.lookup(key, false); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			src.invalid(key, value, "a duration such as 30s or 10m")
+			return defaultValue
+		}
+		return d
+	}
+	src.resolved(key, defaultValue.String(), false)
+	return defaultValue
+}
+
+func (src *configSource) size(key string, defaultValue int64) int64 {
+	if value, ok := src.lookup(key, false); ok {
+		n, err := config.ParseSize(value)
+		if err != nil {
+			src.invalid(key, value, "a size such as 100MB")
+			return defaultValue
+		}
+		return n
+	}
+	src.resolved(key, config.FormatSize(defaultValue), false)
+	return defaultValue
+}
+
+// unknownKeys reports config file keys that no setting reads, which are
+// most likely typos.
+func (src *configSource) unknownKeys() []error {
+	var keys []string
+	for key := range src.file {
+		if !src.used[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		errs = append(errs, fmt.Errorf("config file %s: unknown setting %s", src.path, key))
+	}
+	return errs
+}
@@ -7,22 +7,51 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 
+	"github.com/vixa/cdn/internal/accesslog"
+	"github.com/vixa/cdn/internal/atomicfile"
 	"github.com/vixa/cdn/internal/bot"
 	"github.com/vixa/cdn/internal/cdn"
 	"github.com/vixa/cdn/internal/config"
+	"github.com/vixa/cdn/internal/metadata"
+	"github.com/vixa/cdn/internal/signing"
 	"github.com/vixa/cdn/internal/storage"
 )
 
 func main() {
-	cfg := loadConfig()
+	if len(os.Args) > 1 && os.Args[1] == "scrub" {
+		runScrub(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		runConfig()
+		return
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	cfg.print()
 
 	if cfg.BotToken == "" {
-		log.Fatal("BOT_TOKEN environment variable is required")
+		log.Fatal("BOT_TOKEN is required")
 	}
 
+	storage.SetDownloadTimeout(cfg.DownloadTimeout)
+
+	stor, err := openStorage(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	// Nothing has written yet, so any temporary file is left from a crash
+	removeTempFiles(cfg, stor)
+
 	cm := config.NewConfigManager()
+	var domainsErr, categoriesErr error
 
 	// Try to load domains config, create empty file if it doesn't exist
 	if err := cm.LoadDomains(cfg.DomainsConfig); err != nil {
@@ -33,6 +62,7 @@ func main() {
 			}
 		} else {
 			log.Printf("Warning: Failed to load domains config: %v", err)
+			domainsErr = err
 		}
 	}
 
@@ -45,25 +75,136 @@ func main() {
 			}
 		} else {
 			log.Printf("Warning: Failed to load categories config: %v", err)
+			categoriesErr = err
+		}
+	} else if cm.CategoriesMigrated() {
+		// Keep the global list around in case the migration has to be undone
+		backup := cfg.CategoriesConfig + ".bak"
+		if data, err := os.ReadFile(cfg.CategoriesConfig); err != nil {
+			log.Printf("Warning: Failed to back up categories config: %v", err)
+		} else if err := atomicfile.WriteFile(backup, data, 0644); err != nil {
+			log.Printf("Warning: Failed to back up categories config: %v", err)
+		} else if err := cm.SaveCategories(cfg.CategoriesConfig); err != nil {
+			log.Printf("Warning: Failed to save migrated categories config: %v", err)
+		} else {
+			log.Printf("Categories are now per domain, the previous config was saved to %s", backup)
 		}
 	}
 
-	stor, err := storage.NewStorage(cfg.StoragePath)
-	if err != nil {
-		log.Fatalf("Failed to initialize storage: %v", err)
-	}
+	stor.RegisterMetrics()
 
 	settingsManager, err := config.NewSettingsManager(cfg.SettingsPath)
 	if err != nil {
 		log.Fatalf("Failed to initialize settings manager: %v", err)
 	}
 
+	reloader := config.NewReloader(cm, settingsManager, cfg.DomainsConfig, cfg.CategoriesConfig)
+	if cfg.ConfigPollInterval > 0 {
+		stopWatching := reloader.Watch(cfg.ConfigPollInterval)
+		defer stopWatching()
+	}
+
+	apiKeys, err := config.NewAPIKeyManager(cfg.APIKeysPath)
+	if err != nil {
+		log.Fatalf("Failed to initialize API keys: %v", err)
+	}
+	if !apiKeys.HasKeys() {
+		log.Printf("[Main] No API keys configured, HTTP API is disabled (%s)", cfg.APIKeysPath)
+	}
+
+	// Category quotas and API key scopes used to name a folder across all
+	// domains. Moving them needs the categories, so wait for a config that
+	// loads.
+	if domainsErr == nil && categoriesErr == nil {
+		if migrated, err := settingsManager.MigrateCategoryQuotas(cm); err != nil {
+			log.Printf("[Main] Warning: Failed to save migrated category quotas: %v", err)
+		} else if migrated {
+			log.Printf("[Main] Category quotas now apply to the category of one domain")
+		}
+		if migrated, err := apiKeys.MigrateCategoryScopes(cm); err != nil {
+			log.Printf("[Main] Warning: Failed to save migrated API key scopes: %v", err)
+		} else if migrated {
+			log.Printf("[Main] API key category scopes now name the category of one domain")
+		}
+	}
+
+	signingSecret := []byte(cfg.SigningSecret)
+	if len(signingSecret) == 0 {
+		signingSecret, err = signing.LoadOrCreateSecret(cfg.SigningSecretPath)
+		if err != nil {
+			log.Fatalf("Failed to load URL signing secret: %v", err)
+		}
+	}
+	signer := signing.NewSigner(signingSecret)
+
+	index, err := metadata.Open(cfg.MetadataPath)
+	if err != nil {
+		log.Fatalf("Failed to open metadata index: %v", err)
+	}
+	defer index.Close()
+
+	// Index files that were stored before the index existed, or while it
+	// was unavailable, without holding up startup
+	go func() {
+		result, err := index.Backfill(stor, cm.CategoryFolders())
+		if err != nil {
+			log.Printf("[Main] Metadata backfill failed: %v", err)
+			return
+		}
+		if result.Added > 0 || result.Removed > 0 || result.Failed > 0 {
+			log.Printf("[Main] Metadata backfill: %d added, %d removed, %d unreadable", result.Added, result.Removed, result.Failed)
+		}
+	}()
+
+	stopJanitor := index.StartJanitor(stor, cfg.ExpiryCheckInterval, cfg.TrashRetention)
+	defer stopJanitor()
+
 	defaultDomain := getDefaultDomain(cm)
 
-	cdnServer := cdn.NewServer(stor, cm)
+	cdnServer := cdn.NewServer(stor, cm, apiKeys)
+	cdnServer.SetTrustProxyHeaders(cfg.TrustProxyHeaders)
+	cdnServer.SetTimeouts(cfg.HTTPTimeouts)
+	cdnServer.SetSigner(signer)
+	cdnServer.SetMetadataIndex(index)
+	cdnServer.SetQuotas(settingsManager)
+	cdnServer.SetMaxUploadSize(cfg.MaxUploadSize)
+	cdnServer.SetImageLimits(cdn.ImageLimits{
+		MaxDimension:    cfg.ImageMaxDimension,
+		MaxVariants:     cfg.ImageMaxVariants,
+		MaxSourcePixels: cfg.ImageMaxSourcePixels,
+	})
+
+	accessLog, closeAccessLog, err := openAccessLog(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open access log: %v", err)
+	}
+	defer closeAccessLog()
+	if accessLog != nil {
+		cdnServer.SetAccessLog(accessLog)
+	}
+	cdnServer.AddReadinessCheck("config", func() error {
+		// A successful reload replaces whatever failed to load at startup
+		if !reloader.LastReload().IsZero() {
+			return nil
+		}
+		return errors.Join(domainsErr, categoriesErr)
+	})
+	if cfg.AdminListenAddr != "" {
+		go func() {
+			addr := cfg.AdminListenAddr
+			log.Printf("[Main] Starting admin server (metrics, health) on %s", addr)
+			if err := cdnServer.ListenAndServeAdmin(addr); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("[Main] Admin server error: %v", err)
+			}
+		}()
+	} else {
+		// Without an admin listener, metrics are served on the public port
+		cdnServer.SetPublicMetrics(true)
+	}
+
 	go func() {
-		addr := fmt.Sprintf(":%d", cfg.Port)
-		log.Printf("[Main] Starting server on port %s", addr)
+		addr := cfg.ListenAddr
+		log.Printf("[Main] Starting server on %s", addr)
 		if err := cdnServer.ListenAndServe(addr); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("[Main] Server error: %v", err)
 		}
@@ -74,6 +215,15 @@ func main() {
 		log.Fatalf("Failed to initialize Discord bot: %v", err)
 	}
 
+	discordBot.SetSigner(signer)
+	discordBot.SetMetadataIndex(index)
+	discordBot.SetTrashRetention(cfg.TrashRetention)
+	discordBot.SetMaxUploadSize(cfg.MaxUploadSize)
+	discordBot.SetStatus(cfg.DiscordStatus)
+	discordBot.SetReloader(reloader)
+	discordBot.SetAPIKeys(apiKeys)
+	cdnServer.AddReadinessCheck("discord", discordBot.CheckConnection)
+
 	if err := discordBot.Start(); err != nil {
 		log.Fatalf("Failed to start Discord bot: %v", err)
 	}
@@ -81,58 +231,91 @@ func main() {
 	fmt.Println("[Main] Server started successfully!")
 
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	<-sigChan
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		log.Printf("[Main] Received SIGHUP, reloading config")
+		if err := reloader.Reload(); err != nil {
+			log.Printf("[Main] Reload failed, keeping the running config: %v", err)
+		}
+	}
 	fmt.Println("\n[Main] Shutting down...")
 
 	discordBot.Stop()
 	fmt.Println("[Main] Shutdown complete.")
 }
 
-type Config struct {
-	BotToken         string
-	StoragePath      string
-	Port             int
-	DomainsConfig    string
-	CategoriesConfig string
-	SettingsPath     string
-}
-
-func loadConfig() *Config {
-	return &Config{
-		BotToken:         getEnv("BOT_TOKEN", ""),
-		StoragePath:      "/app/storage",
-		Port:             getEnvInt("PORT", 8080),
-		DomainsConfig:    "/app/configs/domains.json",
-		CategoriesConfig: "/app/configs/categories.json",
-		SettingsPath:     "/app/configs/settings.json",
+// removeTempFiles deletes the temporary files that writes interrupted by a
+// crash left in the storage and config directories.
+func removeTempFiles(cfg *Config, stor *storage.Storage) {
+	removed, err := stor.RemoveTempFiles()
+	if err != nil {
+		log.Printf("[Main] Warning: Failed to clean up storage: %v", err)
+	}
+	for _, key := range removed {
+		log.Printf("[Main] Removed incomplete write from storage: %s", key)
 	}
-}
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+	dirs := make(map[string]bool)
+	for _, path := range []string{cfg.DomainsConfig, cfg.CategoriesConfig, cfg.SettingsPath, cfg.APIKeysPath, cfg.MetadataPath, cfg.SigningSecretPath} {
+		dirs[filepath.Dir(path)] = true
+	}
+	for dir := range dirs {
+		removed, err := atomicfile.Cleanup(dir, false)
+		if err != nil {
+			log.Printf("[Main] Warning: Failed to clean up %s: %v", dir, err)
+		}
+		for _, path := range removed {
+			log.Printf("[Main] Removed incomplete write: %s", path)
+		}
 	}
-	return defaultValue
 }
 
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intVal := parseEnvInt(value); intVal != 0 {
-			return intVal
+// openStorage creates the storage with the backend selected by
+// STORAGE_BACKEND.
+func openStorage(cfg *Config) (*storage.Storage, error) {
+	switch cfg.StorageBackend {
+	case "filesystem", "fs":
+		log.Printf("[Main] Storing files on the filesystem at %s", cfg.StoragePath)
+		return storage.NewStorage(cfg.StoragePath)
+	case "s3":
+		backend, err := storage.NewS3Backend(cfg.S3)
+		if err != nil {
+			return nil, err
 		}
+		log.Printf("[Main] Storing files in S3 bucket %s", cfg.S3.Bucket)
+		return storage.NewStorageWithBackend(backend), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend '%s' (expected filesystem or s3)", cfg.StorageBackend)
 	}
-	return defaultValue
 }
 
-func parseEnvInt(s string) int {
-	var i int
-	_, err := fmt.Sscanf(s, "%d", &i)
+// openAccessLog returns a nil logger when access logging is disabled. The
+// returned close function is always safe to call.
+func openAccessLog(cfg *Config) (*accesslog.Logger, func(), error) {
+	if cfg.AccessLogFormat == "" || cfg.AccessLogFormat == "off" {
+		return nil, func() {}, nil
+	}
+
+	format, err := accesslog.ParseFormat(cfg.AccessLogFormat)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cfg.AccessLogFile == "" {
+		log.Printf("[Main] Access log enabled (%s) on stdout", format)
+		return accesslog.New(os.Stdout, format), func() {}, nil
+	}
+
+	rf, err := accesslog.OpenRotatingFile(cfg.AccessLogFile, int64(cfg.AccessLogMaxSizeMB)<<20, cfg.AccessLogRotateInterval, cfg.AccessLogMaxBackups)
 	if err != nil {
-		return 0
+		return nil, nil, err
 	}
-	return i
+	log.Printf("[Main] Access log enabled (%s) at %s", format, cfg.AccessLogFile)
+	return accesslog.New(rf, format), func() { rf.Close() }, nil
 }
 
 func getDefaultDomain(cm *config.ConfigManager) string {
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/vixa/cdn/internal/config"
+	"github.com/vixa/cdn/internal/metadata"
+)
+
+// runScrub implements the scrub subcommand, which checks every stored file
+// against its checksum and prints what is inconsistent. It exits with 1 when
+// problems are found.
+func runScrub(args []string) {
+	flags := flag.NewFlagSet("scrub", flag.ExitOnError)
+	quarantine := flags.Bool("quarantine", false, "move damaged files to the quarantine folder (stop the server first)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s scrub [-quarantine]\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+
+	stor, err := openStorage(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	cm := config.NewConfigManager()
+	if err := cm.LoadDomains(cfg.DomainsConfig); err != nil {
+		log.Fatalf("Failed to load domains config: %v", err)
+	}
+	if err := cm.LoadCategories(cfg.CategoriesConfig); err != nil {
+		log.Fatalf("Failed to load categories config: %v", err)
+	}
+
+	// A report-only scrub never writes to the index, so it can run next to
+	// the server
+	var index *metadata.Index
+	if *quarantine {
+		index, err = metadata.Open(cfg.MetadataPath)
+	} else {
+		index, err = metadata.OpenReadOnly(cfg.MetadataPath)
+	}
+	if err != nil {
+		log.Fatalf("Failed to open metadata index: %v", err)
+	}
+	defer index.Close()
+
+	report, err := index.Scrub(stor, cm.CategoryFolders(), *quarantine)
+	if err != nil {
+		log.Fatalf("Scrub failed: %v", err)
+	}
+
+	for _, issue := range report.Issues {
+		fmt.Println(issue)
+	}
+	fmt.Printf("Checked %d file(s), found %d problem(s)", report.Checked, len(report.Issues))
+	if *quarantine {
+		fmt.Printf(", quarantined %d file(s)", report.Quarantined)
+	}
+	fmt.Println()
+
+	if len(report.Issues) > 0 {
+		index.Close()
+		os.Exit(1)
+	}
+}
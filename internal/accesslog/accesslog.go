@@ -0,0 +1,123 @@
+// Package accesslog writes one line per HTTP request in Common Log Format,
+// Combined Log Format or JSON.
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Format string
+
+const (
+	FormatCommon   Format = "common"
+	FormatCombined Format = "combined"
+	FormatJSON     Format = "json"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(strings.TrimSpace(s))); f {
+	case FormatCommon, FormatCombined, FormatJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown access log format '%s' (expected common, combined or json)", s)
+}
+
+// Entry describes one served request.
+type Entry struct {
+	Time      time.Time     `json:"time"`
+	ClientIP  string        `json:"client_ip"`
+	Method    string        `json:"method"`
+	URI       string        `json:"uri"`
+	Proto     string        `json:"proto"`
+	Host      string        `json:"host"`
+	Domain    string        `json:"domain,omitempty"`
+	Category  string        `json:"category,omitempty"`
+	Filename  string        `json:"filename,omitempty"`
+	Status    int           `json:"status"`
+	Bytes     int64         `json:"bytes"`
+	Duration  time.Duration `json:"-"`
+	Referer   string        `json:"referer,omitempty"`
+	UserAgent string        `json:"user_agent,omitempty"`
+}
+
+type Logger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format Format
+}
+
+func New(w io.Writer, format Format) *Logger {
+	return &Logger{
+		w:      w,
+		format: format,
+	}
+}
+
+func (l *Logger) Log(e Entry) {
+	var line []byte
+	switch l.format {
+	case FormatJSON:
+		line = formatJSON(e)
+	case FormatCommon:
+		line = []byte(formatCommon(e) + "\n")
+	default:
+		line = []byte(fmt.Sprintf("%s \"%s\" \"%s\"\n", formatCommon(e), escape(e.Referer), escape(e.UserAgent)))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(line)
+}
+
+func formatCommon(e Entry) string {
+	return fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %d`,
+		dash(e.ClientIP),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		escape(e.Method), escape(e.URI), escape(e.Proto),
+		e.Status,
+		e.Bytes,
+	)
+}
+
+func formatJSON(e Entry) []byte {
+	data, err := json.Marshal(struct {
+		Entry
+		DurationMS float64 `json:"duration_ms"`
+	}{
+		Entry:      e,
+		DurationMS: float64(e.Duration.Microseconds()) / 1000,
+	})
+	if err != nil {
+		return nil
+	}
+	return append(data, '\n')
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// escape makes a request-controlled value safe to embed in a quoted field.
+func escape(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&sb, "\\x%02x", r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,153 @@
+package accesslog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RotatingFile is an append-only log file that is rotated once it grows past
+// maxSize bytes or has been open for longer than interval. Rotated files are
+// renamed with a timestamp suffix and only the newest maxBackups are kept.
+// A zero maxSize, interval or maxBackups disables that limit.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func OpenRotatingFile(path string, maxSize int64, interval time.Duration, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		interval:   interval,
+		maxBackups: maxBackups,
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+
+	rf.file = f
+	rf.size = info.Size()
+	rf.opened = time.Now()
+	return nil
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.shouldRotate(int64(len(p))) {
+		if err := rf.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "[AccessLog] Failed to rotate %s: %v\n", rf.path, err)
+		}
+	}
+
+	if rf.file == nil {
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *RotatingFile) shouldRotate(next int64) bool {
+	if rf.size == 0 {
+		return false
+	}
+	if rf.maxSize > 0 && rf.size+next > rf.maxSize {
+		return true
+	}
+	return rf.interval > 0 && time.Since(rf.opened) >= rf.interval
+}
+
+func (rf *RotatingFile) rotate() error {
+	if rf.file != nil {
+		rf.file.Close()
+		rf.file = nil
+	}
+
+	backup := fmt.Sprintf("%s.%s", rf.path, time.Now().Format("20060102-150405.000"))
+	for i := 1; fileExists(backup); i++ {
+		backup = fmt.Sprintf("%s.%s-%d", rf.path, time.Now().Format("20060102-150405.000"), i)
+	}
+	if err := os.Rename(rf.path, backup); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to rename log file: %w", err)
+	}
+
+	if err := rf.open(); err != nil {
+		return err
+	}
+
+	return rf.prune()
+}
+
+func (rf *RotatingFile) prune() error {
+	if rf.maxBackups <= 0 {
+		return nil
+	}
+
+	backups, err := filepath.Glob(rf.path + ".*")
+	if err != nil {
+		return err
+	}
+	if len(backups) <= rf.maxBackups {
+		return nil
+	}
+
+	// Timestamp suffixes sort chronologically
+	sort.Strings(backups)
+	for _, old := range backups[:len(backups)-rf.maxBackups] {
+		if err := os.Remove(old); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove old log file: %w", err)
+		}
+	}
+	return nil
+}
+
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,107 @@
+// Package atomicfile replaces files so that a crash or a full disk never
+// leaves a truncated file behind: readers see either the old or the new
+// contents, and the new contents are on disk once a write returns.
+package atomicfile
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TempPrefix starts the name of every temporary file created while writing.
+// Files with this prefix that outlive a write were left by a crash.
+const TempPrefix = ".tmp-"
+
+// WriteFile atomically replaces path with data.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	return Write(path, bytes.NewReader(data), perm)
+}
+
+// Write atomically replaces path with the contents of r. The data is written
+// to a temporary file in the same directory, flushed to disk and renamed over
+// path, and the directory is flushed so the rename itself survives a crash.
+func Write(path string, r io.Reader, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, TempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	// Removing after a successful rename fails harmlessly
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return SyncDir(dir)
+}
+
+// SyncDir flushes a directory's entries, making renames and removals in it
+// durable.
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+// Cleanup removes temporary files left in dir by interrupted writes and
+// returns their paths. Subdirectories are searched too when recursive is set.
+// It must only run while nothing is writing under dir.
+func Cleanup(dir string, recursive bool) ([]string, error) {
+	var removed []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(d.Name(), TempPrefix) {
+			return nil
+		}
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		removed = append(removed, path)
+		return nil
+	})
+	if err != nil {
+		return removed, fmt.Errorf("failed to clean up temporary files: %w", err)
+	}
+	return removed, nil
+}
@@ -0,0 +1,175 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func aliasCommands() []*discordgo.ApplicationCommand {
+	addAliasCmd := &discordgo.ApplicationCommand{
+		Name:        "add-alias",
+		Description: "Serve a domain on another hostname as well",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "domain",
+				Description:  "Domain to add the hostname to",
+				Required:     true,
+				Autocomplete: true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "hostname",
+				Description: "Hostname, e.g. www.cdn.example.com, or *.example.com for every subdomain",
+				Required:    true,
+			},
+		},
+	}
+
+	removeAliasCmd := &discordgo.ApplicationCommand{
+		Name:        "remove-alias",
+		Description: "Stop serving a domain on one of its aliases",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "domain",
+				Description:  "Domain to remove the hostname from",
+				Required:     true,
+				Autocomplete: true,
+			},
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "hostname",
+				Description:  "Alias to remove",
+				Required:     true,
+				Autocomplete: true,
+			},
+		},
+	}
+
+	viewAliasesCmd := &discordgo.ApplicationCommand{
+		Name:        "view-aliases",
+		Description: "Show the hostnames a domain is served on",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "domain",
+				Description:  "Domain to show",
+				Required:     true,
+				Autocomplete: true,
+			},
+		},
+	}
+
+	return []*discordgo.ApplicationCommand{addAliasCmd, removeAliasCmd, viewAliasesCmd}
+}
+
+func (b *Bot) handleAddAlias(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	opts := optionsByName(i.ApplicationCommandData())
+	domainFolder := opts["domain"].StringValue()
+
+	displayName, ok := b.configManager.GetDomainName(domainFolder)
+	if !ok {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "Invalid domain",
+		})
+		return
+	}
+
+	alias, err := b.configManager.AddDomainAlias(domainFolder, opts["hostname"].StringValue())
+	if err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Failed to add alias: %v", err),
+		})
+		return
+	}
+
+	if err := b.configManager.SaveDomains(b.domainsConfig); err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Alias added in memory but failed to save to file: %v", err),
+		})
+		return
+	}
+
+	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: fmt.Sprintf("`%s` now serves %s. Point its DNS at this server for it to work; links keep using the domain's own FQDN.", alias, displayName),
+	})
+}
+
+func (b *Bot) handleRemoveAlias(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	opts := optionsByName(i.ApplicationCommandData())
+	domainFolder := opts["domain"].StringValue()
+	alias := opts["hostname"].StringValue()
+
+	displayName, ok := b.configManager.GetDomainName(domainFolder)
+	if !ok {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "Invalid domain",
+		})
+		return
+	}
+
+	if err := b.configManager.RemoveDomainAlias(domainFolder, alias); err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Failed to remove alias: %v", err),
+		})
+		return
+	}
+
+	if err := b.configManager.SaveDomains(b.domainsConfig); err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Alias removed from memory but failed to save to file: %v", err),
+		})
+		return
+	}
+
+	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: fmt.Sprintf("`%s` no longer serves %s.", alias, displayName),
+	})
+}
+
+func (b *Bot) handleViewAliases(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	domainFolder := optionsByName(i.ApplicationCommandData())["domain"].StringValue()
+
+	displayName, ok := b.configManager.GetDomainName(domainFolder)
+	if !ok {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "Invalid domain",
+		})
+		return
+	}
+	fqdn, _ := b.configManager.GetDomainFQDN(domainFolder)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("- `%s` (FQDN, used in links)\n", fqdn))
+	aliases := b.configManager.ListDomainAliases(domainFolder)
+	for _, alias := range aliases {
+		sb.WriteString(fmt.Sprintf("- `%s`\n", alias))
+	}
+	if len(aliases) == 0 {
+		sb.WriteString("\nNo aliases. Add one with `/add-alias`.")
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("Hostnames of %s", displayName),
+		Description: sb.String(),
+		Color:       0x808080,
+	}
+	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Embeds: []*discordgo.MessageEmbed{embed},
+	})
+}
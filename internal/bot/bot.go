@@ -1,15 +1,21 @@
 package bot
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
-	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/vixa/cdn/internal/config"
+	"github.com/vixa/cdn/internal/metadata"
+	"github.com/vixa/cdn/internal/metrics"
+	"github.com/vixa/cdn/internal/signing"
 	"github.com/vixa/cdn/internal/storage"
 )
 
@@ -23,8 +29,24 @@ type Bot struct {
 	categoriesConfig string
 	mu               sync.Mutex
 	commands         map[string]bool
+	connected        atomic.Bool
+	signer           *signing.Signer
+	metadata         *metadata.Index
+	trashRetention   time.Duration
+	maxUploadSize    int64
+	reloader         *config.Reloader
+	apiKeys          *config.APIKeyManager
+	status           string
 }
 
+// DefaultStatus is the custom status the bot shows unless SetStatus is
+// called.
+const DefaultStatus = "Online quietly"
+
+// signAdminPermission is required to sign links, as a signed link grants
+// access to files in private categories.
+var signAdminPermission int64 = discordgo.PermissionManageServer
+
 func NewBot(token string, stor *storage.Storage, cm *config.ConfigManager, settingsManager *config.SettingsManager, defaultDomain, domainsConfig, categoriesConfig string) (*Bot, error) {
 	session, err := discordgo.New("Bot " + token)
 	if err != nil {
@@ -40,11 +62,14 @@ func NewBot(token string, stor *storage.Storage, cm *config.ConfigManager, setti
 		domainsConfig:    domainsConfig,
 		categoriesConfig: categoriesConfig,
 		commands:         make(map[string]bool),
+		status:           DefaultStatus,
 	}, nil
 }
 
 func (b *Bot) Start() error {
 	b.session.AddHandler(b.onReady)
+	b.session.AddHandler(b.onConnect)
+	b.session.AddHandler(b.onDisconnect)
 	b.session.AddHandler(b.onInteractionCreate)
 	b.session.AddHandler(b.onMessageCreate)
 
@@ -55,6 +80,52 @@ func (b *Bot) Stop() error {
 	return b.session.Close()
 }
 
+// SetSigner sets the key used by /sign to mint links for private categories.
+func (b *Bot) SetSigner(signer *signing.Signer) {
+	b.signer = signer
+}
+
+// SetStatus sets the custom status shown on the bot's profile. An empty
+// status shows none.
+func (b *Bot) SetStatus(status string) {
+	b.status = status
+}
+
+// SetMaxUploadSize sets the largest attachment that is stored. Zero means no
+// limit beyond Discord's own.
+func (b *Bot) SetMaxUploadSize(size int64) {
+	b.maxUploadSize = size
+}
+
+// tooLargeMessage explains that a file is over the upload size limit.
+func (b *Bot) tooLargeMessage(size int64) string {
+	return fmt.Sprintf("This file is %s, the upload limit is %s.", config.FormatSize(size), config.FormatSize(b.maxUploadSize))
+}
+
+// CheckConnection reports an error while the Discord gateway is disconnected.
+func (b *Bot) CheckConnection() error {
+	if !b.connected.Load() {
+		return errors.New("discord gateway is disconnected")
+	}
+
+	b.session.RLock()
+	ready := b.session.DataReady
+	b.session.RUnlock()
+	if !ready {
+		return errors.New("discord session is not ready")
+	}
+	return nil
+}
+
+func (b *Bot) onConnect(s *discordgo.Session, event *discordgo.Connect) {
+	b.connected.Store(true)
+}
+
+func (b *Bot) onDisconnect(s *discordgo.Session, event *discordgo.Disconnect) {
+	fmt.Println("[Discord] Gateway disconnected")
+	b.connected.Store(false)
+}
+
 func (b *Bot) registerCommands(s *discordgo.Session) {
 	uploadCmd := &discordgo.ApplicationCommand{
 		Name:        "upload",
@@ -68,24 +139,36 @@ func (b *Bot) registerCommands(s *discordgo.Session) {
 			},
 			{
 				Type:         discordgo.ApplicationCommandOptionString,
-				Name:         "category",
-				Description:  "Category for the file (optional if defaults set)",
+				Name:         "domain",
+				Description:  "CDN domain (optional if defaults set)",
 				Required:     false,
 				Autocomplete: true,
 			},
 			{
 				Type:         discordgo.ApplicationCommandOptionString,
-				Name:         "domain",
-				Description:  "CDN domain (optional if defaults set)",
+				Name:         "category",
+				Description:  "Category of the domain for the file (optional if defaults set)",
 				Required:     false,
 				Autocomplete: true,
 			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "expires",
+				Description: "Delete the file after this long, e.g. 12h or 7d (default: the category's TTL)",
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "name",
+				Description: "File name in the URL (default: generated by the category's naming strategy)",
+				Required:    false,
+			},
 		},
 	}
 
 	deleteCmd := &discordgo.ApplicationCommand{
 		Name:        "delete",
-		Description: "Delete a file from the CDN",
+		Description: "Delete a file from the CDN (it can be restored from the trash)",
 		Options: []*discordgo.ApplicationCommandOption{
 			{
 				Type:        discordgo.ApplicationCommandOptionString,
@@ -210,8 +293,15 @@ func (b *Bot) registerCommands(s *discordgo.Session) {
 
 	addCategoryCmd := &discordgo.ApplicationCommand{
 		Name:        "add-category",
-		Description: "Add a new category",
+		Description: "Add a new category to a domain",
 		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "domain",
+				Description:  "Domain the category belongs to",
+				Required:     true,
+				Autocomplete: true,
+			},
 			{
 				Type:        discordgo.ApplicationCommandOptionString,
 				Name:        "category-name",
@@ -224,13 +314,32 @@ func (b *Bot) registerCommands(s *discordgo.Session) {
 				Description: "Folder name for the category (no spaces)",
 				Required:    true,
 			},
+			{
+				Type:        discordgo.ApplicationCommandOptionBoolean,
+				Name:        "private",
+				Description: "Only serve files through signed, expiring links (default: false)",
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "ttl",
+				Description: "Delete uploaded files after this long, e.g. 7d (default: keep forever)",
+				Required:    false,
+			},
 		},
 	}
 
 	removeCategoryCmd := &discordgo.ApplicationCommand{
 		Name:        "remove-category",
-		Description: "Remove a category",
+		Description: "Remove a category from a domain",
 		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "domain",
+				Description:  "Domain of the category",
+				Required:     true,
+				Autocomplete: true,
+			},
 			{
 				Type:         discordgo.ApplicationCommandOptionString,
 				Name:         "category-name",
@@ -241,7 +350,65 @@ func (b *Bot) registerCommands(s *discordgo.Session) {
 		},
 	}
 
-	commands := []*discordgo.ApplicationCommand{uploadCmd, deleteCmd, listCmd, defaultCmd, setChannelCmd, viewChannelDefaultCmd, resetChannelCmd, addDomainCmd, removeDomainCmd, addCategoryCmd, removeCategoryCmd}
+	setCategoryPrivateCmd := &discordgo.ApplicationCommand{
+		Name:        "set-category-private",
+		Description: "Make a category private (signed links only) or public",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "domain",
+				Description:  "Domain of the category",
+				Required:     true,
+				Autocomplete: true,
+			},
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "category-name",
+				Description:  "Category to change",
+				Required:     true,
+				Autocomplete: true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionBoolean,
+				Name:        "private",
+				Description: "Whether files in this category require a signed link",
+				Required:    true,
+			},
+		},
+	}
+
+	signCmd := &discordgo.ApplicationCommand{
+		Name:                     "sign",
+		Description:              "Create a signed, expiring link to a file",
+		DefaultMemberPermissions: &signAdminPermission,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "url",
+				Description: "Full URL of the file",
+				Required:    true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "lifetime",
+				Description: "How long the link stays valid, e.g. 30m, 12h or 7d (default: 24h)",
+				Required:    false,
+			},
+		},
+	}
+
+	commands := []*discordgo.ApplicationCommand{uploadCmd, deleteCmd, listCmd, defaultCmd, setChannelCmd, viewChannelDefaultCmd, resetChannelCmd, addDomainCmd, removeDomainCmd, addCategoryCmd, removeCategoryCmd, setCategoryPrivateCmd, signCmd}
+	commands = append(commands, aliasCommands()...)
+	commands = append(commands, hotlinkCommands()...)
+	commands = append(commands, pageCommands()...)
+	commands = append(commands, metadataCommands()...)
+	commands = append(commands, quotaCommands()...)
+	commands = append(commands, expiryCommands()...)
+	commands = append(commands, namingCommands()...)
+	commands = append(commands, trashCommands()...)
+	commands = append(commands, scrubCommands()...)
+	commands = append(commands, reloadCommands()...)
+	commands = append(commands, editCommands()...)
 
 	for _, cmd := range commands {
 		_, err := s.ApplicationCommandCreate(s.State.User.ID, "", cmd)
@@ -256,7 +423,7 @@ func (b *Bot) registerCommands(s *discordgo.Session) {
 func (b *Bot) onReady(s *discordgo.Session, event *discordgo.Ready) {
 	fmt.Printf("[Discord] Logged in as %s#%s\n", event.User.Username, event.User.Discriminator)
 
-	s.UpdateCustomStatus("Online quietly")
+	s.UpdateCustomStatus(b.status)
 
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -296,6 +463,52 @@ func (b *Bot) onInteractionCreate(s *discordgo.Session, i *discordgo.Interaction
 			b.handleAddCategory(s, i)
 		case "remove-category":
 			b.handleRemoveCategory(s, i)
+		case "set-category-private":
+			b.handleSetCategoryPrivate(s, i)
+		case "sign":
+			b.handleSign(s, i)
+		case "set-hotlink":
+			b.handleSetHotlink(s, i)
+		case "view-hotlink":
+			b.handleViewHotlink(s, i)
+		case "reset-hotlink":
+			b.handleResetHotlink(s, i)
+		case "set-page":
+			b.handleSetPage(s, i)
+		case "remove-page":
+			b.handleRemovePage(s, i)
+		case "view-pages":
+			b.handleViewPages(s, i)
+		case "info":
+			b.handleInfo(s, i)
+		case "uploads":
+			b.handleUploads(s, i)
+		case "set-quota":
+			b.handleSetQuota(s, i)
+		case "usage":
+			b.handleUsage(s, i)
+		case "set-category-ttl":
+			b.handleSetCategoryTTL(s, i)
+		case "set-category-naming":
+			b.handleSetCategoryNaming(s, i)
+		case "restore":
+			b.handleRestore(s, i)
+		case "trash":
+			b.handleTrash(s, i)
+		case "scrub":
+			b.handleScrub(s, i)
+		case "add-alias":
+			b.handleAddAlias(s, i)
+		case "remove-alias":
+			b.handleRemoveAlias(s, i)
+		case "view-aliases":
+			b.handleViewAliases(s, i)
+		case "reload":
+			b.handleReload(s, i)
+		case "edit-domain":
+			b.handleEditDomain(s, i)
+		case "edit-category":
+			b.handleEditCategory(s, i)
 		}
 	case discordgo.InteractionMessageComponent:
 		b.handleComponentInteraction(s, i)
@@ -307,8 +520,9 @@ func (b *Bot) onInteractionCreate(s *discordgo.Session, i *discordgo.Interaction
 func (b *Bot) handleAutocomplete(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	data := i.ApplicationCommandData()
 
-	// Get the focused option
+	// Get the focused option and the options next to it
 	var focusedOption *discordgo.ApplicationCommandInteractionDataOption
+	siblings := data.Options
 	for _, opt := range data.Options {
 		if opt.Focused {
 			focusedOption = opt
@@ -319,6 +533,7 @@ func (b *Bot) handleAutocomplete(s *discordgo.Session, i *discordgo.InteractionC
 			for _, subOpt := range opt.Options {
 				if subOpt.Focused {
 					focusedOption = subOpt
+					siblings = opt.Options
 					break
 				}
 			}
@@ -347,10 +562,43 @@ func (b *Bot) handleAutocomplete(s *discordgo.Session, i *discordgo.InteractionC
 				})
 			}
 		}
+	case "hostname":
+		// Only /remove-alias autocompletes hostnames, from the chosen domain
+		for _, opt := range siblings {
+			if opt.Name != "domain" {
+				continue
+			}
+			for _, alias := range b.configManager.ListDomainAliases(opt.StringValue()) {
+				if userInput == "" || strings.Contains(alias, userInput) {
+					choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+						Name:  alias,
+						Value: alias,
+					})
+				}
+			}
+		}
 	case "category", "category-name":
-		categories := b.configManager.ListCategories()
+		// Offer the categories of the chosen domain; /upload falls back to
+		// the default domain. Without a domain, offer every folder name.
+		var domain string
+		for _, opt := range siblings {
+			if opt.Name == "domain" || opt.Name == "domain-name" {
+				domain = opt.StringValue()
+			}
+		}
+		if domain == "" && data.Name == "upload" {
+			domain = b.defaultUploadDomain()
+		}
+
+		categories := b.configManager.ListAllCategories()
+		if b.configManager.DomainExists(domain) {
+			categories = b.configManager.ListCategories(domain)
+		}
 		for _, category := range categories {
-			displayName, _ := b.configManager.GetCategoryDisplayName(category)
+			displayName, ok := b.configManager.GetCategoryDisplayName(domain, category)
+			if !ok {
+				displayName = category
+			}
 			// Filter based on user input
 			if userInput == "" ||
 				strings.Contains(strings.ToLower(category), userInput) ||
@@ -376,6 +624,15 @@ func (b *Bot) handleAutocomplete(s *discordgo.Session, i *discordgo.InteractionC
 	})
 }
 
+// defaultUploadDomain returns the domain /upload uses when none is given:
+// the global default, or else the domain set at startup.
+func (b *Bot) defaultUploadDomain() string {
+	if defaultDomain, _ := b.settingsManager.GetGlobalDefaults(); defaultDomain != "" {
+		return defaultDomain
+	}
+	return b.defaultDomain
+}
+
 func (b *Bot) handleUpload(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
@@ -397,28 +654,22 @@ func (b *Bot) handleUpload(s *discordgo.Session, i *discordgo.InteractionCreate)
 		return
 	}
 
-	data := i.ApplicationCommandData()
+	opts := optionsByName(i.ApplicationCommandData())
 
-	attachmentID := data.Options[0].Value.(string)
+	attachmentID := opts["file"].Value.(string)
 
 	// Get domain from options or use global default
 	var domain string
-	if len(data.Options) > 2 && data.Options[2] != nil {
-		domain = data.Options[2].Value.(string)
+	if opt, ok := opts["domain"]; ok {
+		domain = opt.StringValue()
 	} else {
-		// Try to get from global defaults
-		defaultDomain, _ := b.settingsManager.GetGlobalDefaults()
-		if defaultDomain != "" {
-			domain = defaultDomain
-		} else {
-			domain = b.defaultDomain
-		}
+		domain = b.defaultUploadDomain()
 	}
 
 	// Get category from options or use global default
 	var categoryName string
-	if len(data.Options) > 1 && data.Options[1] != nil {
-		categoryName = data.Options[1].Value.(string)
+	if opt, ok := opts["category"]; ok {
+		categoryName = opt.StringValue()
 	} else {
 		// Try to get from global defaults
 		_, defaultCategory := b.settingsManager.GetGlobalDefaults()
@@ -451,38 +702,117 @@ func (b *Bot) handleUpload(s *discordgo.Session, i *discordgo.InteractionCreate)
 		return
 	}
 
-	_, ok = b.configManager.GetCategoryID(categoryName)
+	_, ok = b.configManager.GetCategoryID(domain, categoryName)
 	if !ok {
 		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
-			Content: "Invalid category",
+			Content: fmt.Sprintf("Invalid category: `%s` is not a category of `%s`", categoryName, domain),
+		})
+		return
+	}
+
+	var expires string
+	if opt, ok := opts["expires"]; ok {
+		expires = opt.StringValue()
+	}
+	expiresAt, err := b.configManager.FileExpiry(domain, categoryName, expires, time.Now())
+	if err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Invalid expiry: %v", err),
+		})
+		return
+	}
+
+	if b.maxUploadSize > 0 && int64(attachment.Size) > b.maxUploadSize {
+		metrics.UploadFailuresTotal.Inc(metrics.SourceCommand, "too_large")
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: b.tooLargeMessage(int64(attachment.Size)),
+		})
+		return
+	}
+
+	quota := b.checkQuotas(domain, categoryName, interactionUserID(i), int64(attachment.Size))
+	if quota.Rejection != "" {
+		metrics.UploadFailuresTotal.Inc(metrics.SourceCommand, "quota")
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: quota.Rejection,
 		})
 		return
 	}
+	defer quota.Release()
 
-	fileData, contentType, err := storage.DownloadFile(attachment.URL)
+	upload, err := b.storage.DownloadFile(attachment.URL, b.maxUploadSize)
 	if err != nil {
+		metrics.UploadFailuresTotal.Inc(metrics.SourceCommand, "download")
 		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
 			Content: fmt.Sprintf("Failed to download file: %v", err),
 		})
 		return
 	}
+	defer upload.Close()
 
-	ext := filepath.Ext(attachment.Filename)
-	filename, _, err := b.storage.StoreFile(domain, categoryName, fileData, contentType, ext)
+	req := storage.NameRequest{
+		Naming:       b.configManager.GetCategoryNaming(domain, categoryName),
+		OriginalName: attachment.Filename,
+	}
+	if opt, ok := opts["name"]; ok {
+		req.Name = opt.StringValue()
+	}
+	filename, err := b.storage.StoreFile(domain, categoryName, upload, req)
+	if errors.Is(err, storage.ErrFileExists) || errors.Is(err, storage.ErrInvalidName) {
+		metrics.UploadFailuresTotal.Inc(metrics.SourceCommand, "name")
+		content := fmt.Sprintf("A file named `%s` already exists in this category. Choose another name.", req.Name)
+		if errors.Is(err, storage.ErrInvalidName) {
+			content = "Invalid file name. Use letters, digits, dashes, underscores and dots only, and don't start with a dot."
+		}
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: content,
+		})
+		return
+	}
 	if err != nil {
+		metrics.UploadFailuresTotal.Inc(metrics.SourceCommand, "store")
 		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
 			Content: fmt.Sprintf("Failed to store file: %v", err),
 		})
 		return
 	}
+	metrics.UploadsTotal.Inc(domain, categoryName, metrics.SourceCommand)
+	metrics.UploadBytes.Observe(float64(upload.Size), metrics.SourceCommand)
 
-	domainURL, _ := b.configManager.GetDomainFQDN(domain)
-	encodedCategory := url.PathEscape(categoryName)
-	fileURL := fmt.Sprintf("https://%s/%s/%s", domainURL, encodedCategory, filename)
+	fileURL, _ := b.configManager.BuildFileURL(domain, categoryName, filename)
 
-	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
-		Content: fmt.Sprintf("<%s>", fileURL),
+	content := fmt.Sprintf("<%s>", fileURL)
+	if b.configManager.IsCategoryPrivate(domain, categoryName) {
+		content += "\nThis category is private, use `/sign` to create a shareable link."
+	}
+	if expiresAt != nil {
+		content += fmt.Sprintf("\nExpires <t:%d:R>.", expiresAt.Unix())
+	}
+	for _, warning := range quota.Warnings {
+		content += "\n" + warning
+	}
+
+	rec := metadata.Record{
+		Domain:       domain,
+		Category:     categoryName,
+		Filename:     filename,
+		OriginalName: attachment.Filename,
+		UploaderID:   interactionUserID(i),
+		ChannelID:    i.ChannelID,
+		Source:       metadata.SourceCommand,
+		Size:         upload.Size,
+		SHA256:       upload.SHA256,
+		ContentType:  upload.ContentType,
+		ExpiresAt:    expiresAt,
+	}
+
+	msg, err := s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: content,
 	})
+	if err == nil {
+		rec.MessageID = msg.ID
+	}
+	b.recordUpload(rec)
 }
 
 func (b *Bot) handleDelete(s *discordgo.Session, i *discordgo.InteractionCreate) {
@@ -509,16 +839,22 @@ func (b *Bot) handleDelete(s *discordgo.Session, i *discordgo.InteractionCreate)
 		return
 	}
 
-	err = b.storage.DeleteFile(domainFolder, category, filename)
+	err = b.storage.TrashFile(domainFolder, category, filename)
 	if err != nil {
 		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
 			Content: fmt.Sprintf("Failed to delete file: %v", err),
 		})
 		return
 	}
+	metrics.DeletesTotal.Inc(domainFolder, category, metrics.SourceCommand)
+	b.trashRecord(domainFolder, category, filename)
 
+	content := fmt.Sprintf("<%s> has been moved to the trash. Use `/restore` to bring it back", url)
+	if b.trashRetention > 0 {
+		content += fmt.Sprintf(" before it is purged <t:%d:R>", time.Now().Add(b.trashRetention).Unix())
+	}
 	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
-		Content: fmt.Sprintf("<%s> has been deleted.", url),
+		Content: content + ".",
 	})
 }
 
@@ -541,10 +877,10 @@ func (b *Bot) handleList(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		return
 	}
 
-	_, ok = b.configManager.GetCategoryID(category)
+	_, ok = b.configManager.GetCategoryID(domain, category)
 	if !ok {
 		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
-			Content: "Invalid category",
+			Content: fmt.Sprintf("Invalid category: `%s` is not a category of `%s`", category, domain),
 		})
 		return
 	}
@@ -558,7 +894,7 @@ func (b *Bot) handleList(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	}
 
 	domainDisplayName, _ := b.configManager.GetDomainName(domain)
-	categoryDisplayName, _ := b.configManager.GetCategoryDisplayName(category)
+	categoryDisplayName, _ := b.configManager.GetCategoryDisplayName(domain, category)
 
 	if len(files) == 0 {
 		_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
@@ -782,10 +1118,10 @@ func (b *Bot) handleDefault(s *discordgo.Session, i *discordgo.InteractionCreate
 		return
 	}
 
-	_, ok = b.configManager.GetCategoryID(category)
+	_, ok = b.configManager.GetCategoryID(domain, category)
 	if !ok {
 		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
-			Content: "Invalid category",
+			Content: fmt.Sprintf("Invalid category: `%s` is not a category of `%s`", category, domain),
 		})
 		return
 	}
@@ -797,7 +1133,7 @@ func (b *Bot) handleDefault(s *discordgo.Session, i *discordgo.InteractionCreate
 		return
 	}
 
-	categoryDisplayName, _ := b.configManager.GetCategoryDisplayName(category)
+	categoryDisplayName, _ := b.configManager.GetCategoryDisplayName(domain, category)
 	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
 		Content: fmt.Sprintf("Default settings updated: Domain: `%s`, Category: `%s`", domainName, categoryDisplayName),
 	})
@@ -821,10 +1157,10 @@ func (b *Bot) handleSetChannel(s *discordgo.Session, i *discordgo.InteractionCre
 		return
 	}
 
-	_, ok = b.configManager.GetCategoryID(category)
+	_, ok = b.configManager.GetCategoryID(domain, category)
 	if !ok {
 		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
-			Content: "Invalid category",
+			Content: fmt.Sprintf("Invalid category: `%s` is not a category of `%s`", category, domain),
 		})
 		return
 	}
@@ -836,7 +1172,7 @@ func (b *Bot) handleSetChannel(s *discordgo.Session, i *discordgo.InteractionCre
 		return
 	}
 
-	categoryDisplayName, _ := b.configManager.GetCategoryDisplayName(category)
+	categoryDisplayName, _ := b.configManager.GetCategoryDisplayName(domain, category)
 	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
 		Content: fmt.Sprintf("Channel auto-upload configured: Domain: `%s`, Category: `%s`. Files uploaded to this channel will be automatically uploaded to the CDN.", domainName, categoryDisplayName),
 	})
@@ -857,7 +1193,7 @@ func (b *Bot) handleViewChannelDefault(s *discordgo.Session, i *discordgo.Intera
 	}
 
 	domainName, _ := b.configManager.GetDomainName(config.Domain)
-	categoryDisplayName, _ := b.configManager.GetCategoryDisplayName(config.Category)
+	categoryDisplayName, _ := b.configManager.GetCategoryDisplayName(config.Domain, config.Category)
 
 	embed := &discordgo.MessageEmbed{
 		Fields: []*discordgo.MessageEmbedField{
@@ -1048,10 +1384,10 @@ func (b *Bot) onMessageCreate(s *discordgo.Session, m *discordgo.MessageCreate)
 		return
 	}
 
-	_, ok := b.configManager.GetCategoryID(category)
+	_, ok := b.configManager.GetCategoryID(domain, category)
 	if !ok {
 		msg := &discordgo.MessageSend{
-			Content: fmt.Sprintf("Category '%s' does not exist. Use `/list` to see available categories or `/add-category` to add it.", category),
+			Content: fmt.Sprintf("Category '%s' does not exist in domain '%s'. Use `/add-category` to add it.", category, domain),
 			Reference: &discordgo.MessageReference{
 				MessageID: m.ID,
 				ChannelID: m.ChannelID,
@@ -1065,8 +1401,8 @@ func (b *Bot) onMessageCreate(s *discordgo.Session, m *discordgo.MessageCreate)
 		return
 	}
 
-	// Get domain URL for file URLs
-	domainURL, ok := b.configManager.GetDomainFQDN(domain)
+	// Make sure the domain has a URL before uploading anything
+	_, ok = b.configManager.GetDomainFQDN(domain)
 	if !ok {
 		msg := &discordgo.MessageSend{
 			Content: "Failed to get domain URL. Please check your domain configuration.",
@@ -1083,31 +1419,76 @@ func (b *Bot) onMessageCreate(s *discordgo.Session, m *discordgo.MessageCreate)
 		return
 	}
 
+	// Auto-uploads always get the category's TTL
+	expiresAt, _ := b.configManager.FileExpiry(domain, category, "", time.Now())
+
 	// Process each attachment
-	var uploadedURLs []string
+	var uploadedURLs, notes []string
 	for _, attachment := range m.Attachments {
-		fileData, ct, err := storage.DownloadFile(attachment.URL)
+		if b.maxUploadSize > 0 && int64(attachment.Size) > b.maxUploadSize {
+			metrics.UploadFailuresTotal.Inc(metrics.SourceAuto, "too_large")
+			notes = append(notes, fmt.Sprintf("`%s` was not uploaded. %s", attachment.Filename, b.tooLargeMessage(int64(attachment.Size))))
+			continue
+		}
+
+		quota := b.checkQuotas(domain, category, m.Author.ID, int64(attachment.Size))
+		if quota.Rejection != "" {
+			metrics.UploadFailuresTotal.Inc(metrics.SourceAuto, "quota")
+			notes = append(notes, fmt.Sprintf("`%s` was not uploaded. %s", attachment.Filename, quota.Rejection))
+			continue
+		}
+
+		upload, err := b.storage.DownloadFile(attachment.URL, b.maxUploadSize)
 		if err != nil {
+			quota.Release()
+			metrics.UploadFailuresTotal.Inc(metrics.SourceAuto, "download")
 			continue
 		}
 
-		ext := filepath.Ext(attachment.Filename)
-		filename, _, err := b.storage.StoreFile(domain, category, fileData, ct, ext)
+		filename, err := b.storage.StoreFile(domain, category, upload, storage.NameRequest{
+			Naming:       b.configManager.GetCategoryNaming(domain, category),
+			OriginalName: attachment.Filename,
+		})
+		upload.Close()
 		if err != nil {
+			quota.Release()
+			metrics.UploadFailuresTotal.Inc(metrics.SourceAuto, "store")
 			continue
 		}
+		metrics.UploadsTotal.Inc(domain, category, metrics.SourceAuto)
+		metrics.UploadBytes.Observe(float64(upload.Size), metrics.SourceAuto)
+		b.recordUpload(metadata.Record{
+			Domain:       domain,
+			Category:     category,
+			Filename:     filename,
+			OriginalName: attachment.Filename,
+			UploaderID:   m.Author.ID,
+			ChannelID:    m.ChannelID,
+			MessageID:    m.ID,
+			Source:       metadata.SourceAuto,
+			Size:         upload.Size,
+			SHA256:       upload.SHA256,
+			ContentType:  upload.ContentType,
+			ExpiresAt:    expiresAt,
+		})
+		quota.Release()
 
-		encodedCategory := url.PathEscape(category)
-		fileURL := fmt.Sprintf("https://%s/%s/%s", domainURL, encodedCategory, filename)
+		fileURL, _ := b.configManager.BuildFileURL(domain, category, filename)
 		uploadedURLs = append(uploadedURLs, fileURL)
+
+		for _, warning := range quota.Warnings {
+			if !slices.Contains(notes, warning) {
+				notes = append(notes, warning)
+			}
+		}
 	}
 
 	// Send response with uploaded URLs
-	if len(uploadedURLs) > 0 {
+	if len(uploadedURLs) > 0 || len(notes) > 0 {
 		var content string
 		if len(uploadedURLs) == 1 {
 			content = fmt.Sprintf("<%s>", uploadedURLs[0])
-		} else {
+		} else if len(uploadedURLs) > 1 {
 			var sb strings.Builder
 			sb.WriteString(fmt.Sprintf("Auto-uploaded %d file(s):\n", len(uploadedURLs)))
 			for _, url := range uploadedURLs {
@@ -1115,6 +1496,12 @@ func (b *Bot) onMessageCreate(s *discordgo.Session, m *discordgo.MessageCreate)
 			}
 			content = sb.String()
 		}
+		if len(uploadedURLs) > 0 && expiresAt != nil {
+			content = strings.TrimSpace(content + fmt.Sprintf("\nExpires <t:%d:R>.", expiresAt.Unix()))
+		}
+		if len(notes) > 0 {
+			content = strings.TrimSpace(content + "\n" + strings.Join(notes, "\n"))
+		}
 
 		msg := &discordgo.MessageSend{
 			Content: content,
@@ -1232,8 +1619,8 @@ func (b *Bot) handleRemoveDomain(s *discordgo.Session, i *discordgo.InteractionC
 		return
 	}
 
-	// Save changes to file
-	if err := b.configManager.SaveDomains(b.domainsConfig); err != nil {
+	// Save changes to file; the domain's categories went with it
+	if err := b.configManager.Save(b.domainsConfig, b.categoriesConfig); err != nil {
 		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
 			Content: fmt.Sprintf("Domain removed from memory but failed to save to file: %v", err),
 		})
@@ -1250,9 +1637,21 @@ func (b *Bot) handleAddCategory(s *discordgo.Session, i *discordgo.InteractionCr
 		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
 	})
 
-	data := i.ApplicationCommandData()
-	displayName := data.Options[0].Value.(string)
-	folderName := data.Options[1].Value.(string)
+	opts := optionsByName(i.ApplicationCommandData())
+	domain := opts["domain"].StringValue()
+	displayName := opts["category-name"].StringValue()
+	folderName := opts["folder-name"].StringValue()
+
+	var ttl time.Duration
+	if opt, ok := opts["ttl"]; ok {
+		var err error
+		if ttl, err = config.ParseTTL(opt.StringValue()); err != nil {
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: fmt.Sprintf("Invalid TTL: %v", err),
+			})
+			return
+		}
+	}
 
 	// Validate folder name (no spaces)
 	if strings.Contains(folderName, " ") {
@@ -1262,22 +1661,60 @@ func (b *Bot) handleAddCategory(s *discordgo.Session, i *discordgo.InteractionCr
 		return
 	}
 
+	// Dot folders are reserved for the domain's custom pages
+	if strings.HasPrefix(folderName, ".") {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "Folder name cannot start with a dot.",
+		})
+		return
+	}
+
+	domainDisplayName, ok := b.configManager.GetDomainName(domain)
+	if !ok {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Domain '%s' not found.", domain),
+		})
+		return
+	}
+
 	// Check if category already exists
-	if _, ok := b.configManager.GetCategoryID(folderName); ok {
+	if _, ok := b.configManager.GetCategoryID(domain, folderName); ok {
 		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
-			Content: fmt.Sprintf("Category with folder-name '%s' already exists.", folderName),
+			Content: fmt.Sprintf("Category with folder-name '%s' already exists in %s.", folderName, domainDisplayName),
 		})
 		return
 	}
 
 	// Add the category
-	if err := b.configManager.AddCategory(folderName, displayName); err != nil {
+	if err := b.configManager.AddCategory(domain, folderName, displayName); err != nil {
 		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
 			Content: fmt.Sprintf("Failed to add category: %v", err),
 		})
 		return
 	}
 
+	private := false
+	if opt, ok := opts["private"]; ok {
+		private = opt.BoolValue()
+	}
+	if private {
+		if err := b.configManager.SetCategoryPrivate(domain, folderName, true); err != nil {
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: fmt.Sprintf("Failed to mark category as private: %v", err),
+			})
+			return
+		}
+	}
+
+	if ttl > 0 {
+		if err := b.configManager.SetCategoryTTL(domain, folderName, ttl); err != nil {
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: fmt.Sprintf("Failed to set category TTL: %v", err),
+			})
+			return
+		}
+	}
+
 	// Save categories to file
 	if err := b.configManager.SaveCategories(b.categoriesConfig); err != nil {
 		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
@@ -1286,8 +1723,16 @@ func (b *Bot) handleAddCategory(s *discordgo.Session, i *discordgo.InteractionCr
 		return
 	}
 
+	visibility := ""
+	if private {
+		visibility = " as a private category. Use `/sign` to share its files"
+	}
+	content := fmt.Sprintf("Category `%s` (%s) added to %s successfully%s!", folderName, displayName, domainDisplayName, visibility)
+	if ttl > 0 {
+		content += fmt.Sprintf("\nFiles uploaded to it are deleted after %s.", config.FormatTTL(ttl))
+	}
 	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
-		Content: fmt.Sprintf("Category `%s` (%s) added successfully!", folderName, displayName),
+		Content: content,
 	})
 }
 
@@ -1304,21 +1749,22 @@ func (b *Bot) handleRemoveCategory(s *discordgo.Session, i *discordgo.Interactio
 		return
 	}
 
-	data := i.ApplicationCommandData()
-	categoryName := data.Options[0].Value.(string)
+	opts := optionsByName(i.ApplicationCommandData())
+	domain := opts["domain"].StringValue()
+	categoryName := opts["category-name"].StringValue()
 
 	// Check if category exists
-	displayName, ok := b.configManager.GetCategoryDisplayName(categoryName)
+	displayName, ok := b.configManager.GetCategoryDisplayName(domain, categoryName)
 	if !ok {
 		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
-			Content: fmt.Sprintf("Category '%s' not found.", categoryName),
+			Content: fmt.Sprintf("Category '%s' not found in domain '%s'.", categoryName, domain),
 		})
 		return
 	}
 
 	// Check if category is in use (global defaults or channel configs)
-	_, globalCategory := b.settingsManager.GetGlobalDefaults()
-	if globalCategory == categoryName {
+	globalDomain, globalCategory := b.settingsManager.GetGlobalDefaults()
+	if globalDomain == domain && globalCategory == categoryName {
 		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
 			Content: fmt.Sprintf("Cannot remove category '%s' - it is currently set as the global default. Use `/default` to change the default.", displayName),
 		})
@@ -1328,7 +1774,7 @@ func (b *Bot) handleRemoveCategory(s *discordgo.Session, i *discordgo.Interactio
 	// Check channel configs
 	channelConfigs := b.settingsManager.ListChannelConfigs()
 	for channelID, cfg := range channelConfigs {
-		if cfg.Category == categoryName {
+		if cfg.Domain == domain && cfg.Category == categoryName {
 			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
 				Content: fmt.Sprintf("Cannot remove category '%s' - it is currently configured for channel <#%s>. Use `/set-channel` to change the channel config.", displayName, channelID),
 			})
@@ -1337,7 +1783,7 @@ func (b *Bot) handleRemoveCategory(s *discordgo.Session, i *discordgo.Interactio
 	}
 
 	// Remove the category
-	if err := b.configManager.RemoveCategory(categoryName); err != nil {
+	if err := b.configManager.RemoveCategory(domain, categoryName); err != nil {
 		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
 			Content: fmt.Sprintf("Failed to remove category: %v", err),
 		})
@@ -1356,3 +1802,102 @@ func (b *Bot) handleRemoveCategory(s *discordgo.Session, i *discordgo.Interactio
 		Content: fmt.Sprintf("Category `%s` (%s) has been removed successfully.", categoryName, displayName),
 	})
 }
+
+func (b *Bot) handleSetCategoryPrivate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	opts := optionsByName(i.ApplicationCommandData())
+	domain := opts["domain"].StringValue()
+	categoryName := opts["category-name"].StringValue()
+	private := opts["private"].BoolValue()
+
+	displayName, ok := b.configManager.GetCategoryDisplayName(domain, categoryName)
+	if !ok {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Category '%s' not found in domain '%s'.", categoryName, domain),
+		})
+		return
+	}
+
+	if err := b.configManager.SetCategoryPrivate(domain, categoryName, private); err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Failed to update category: %v", err),
+		})
+		return
+	}
+
+	if err := b.configManager.SaveCategories(b.categoriesConfig); err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Category updated in memory but failed to save to file: %v", err),
+		})
+		return
+	}
+
+	var content string
+	if private {
+		content = fmt.Sprintf("Category `%s` (%s) is now private. Its files are only served through links created with `/sign`.", categoryName, displayName)
+	} else {
+		content = fmt.Sprintf("Category `%s` (%s) is now public.", categoryName, displayName)
+	}
+	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: content,
+	})
+}
+
+func (b *Bot) handleSign(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: discordgo.MessageFlagsEphemeral,
+		},
+	})
+
+	if b.signer == nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "URL signing is not configured.",
+		})
+		return
+	}
+
+	opts := optionsByName(i.ApplicationCommandData())
+	fileURL := opts["url"].StringValue()
+	lifetimeStr := "24h"
+	if opt, ok := opts["lifetime"]; ok {
+		lifetimeStr = opt.StringValue()
+	}
+
+	lifetime, err := signing.ParseLifetime(lifetimeStr)
+	if err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Invalid lifetime: %v", err),
+		})
+		return
+	}
+
+	domainFolder, category, filename, err := b.configManager.ResolveFileURL(fileURL)
+	if err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Invalid URL: %v", err),
+		})
+		return
+	}
+
+	f, _, _, err := b.storage.OpenFile(domainFolder, category, filename)
+	if err != nil || f == nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "File not found.",
+		})
+		return
+	}
+	f.Close()
+
+	expires := time.Now().Add(lifetime)
+	publicURL, _ := b.configManager.BuildFileURL(domainFolder, category, filename)
+	signedURL := b.signer.SignURL(publicURL, domainFolder, category, filename, expires)
+
+	_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+		Content: fmt.Sprintf("<%s>\nExpires <t:%d:R>.", signedURL, expires.Unix()),
+	})
+}
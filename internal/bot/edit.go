@@ -0,0 +1,410 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/vixa/cdn/internal/config"
+	"github.com/vixa/cdn/internal/storage"
+)
+
+// editAdminPermission is required to edit domains and categories, as a
+// folder rename moves every file stored under it.
+var editAdminPermission int64 = discordgo.PermissionManageServer
+
+// SetAPIKeys sets the API keys whose scopes follow renamed folders.
+func (b *Bot) SetAPIKeys(apiKeys *config.APIKeyManager) {
+	b.apiKeys = apiKeys
+}
+
+func editCommands() []*discordgo.ApplicationCommand {
+	editDomainCmd := &discordgo.ApplicationCommand{
+		Name:                     "edit-domain",
+		Description:              "Change the display name, FQDN or folder name of a domain",
+		DefaultMemberPermissions: &editAdminPermission,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "domain",
+				Description:  "Domain to edit",
+				Required:     true,
+				Autocomplete: true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "display-name",
+				Description: "New display name",
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "domain-fqdn",
+				Description: "New FQDN (e.g., cdn.example.com)",
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "folder-name",
+				Description: "New folder name (no spaces). Moves every file of the domain",
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionBoolean,
+				Name:        "redirect",
+				Description: "Redirect the old FQDN to the new one (default: false)",
+				Required:    false,
+			},
+		},
+	}
+
+	editCategoryCmd := &discordgo.ApplicationCommand{
+		Name:                     "edit-category",
+		Description:              "Change the display name or folder name of a category",
+		DefaultMemberPermissions: &editAdminPermission,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "domain",
+				Description:  "Domain of the category",
+				Required:     true,
+				Autocomplete: true,
+			},
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "category-name",
+				Description:  "Category to edit",
+				Required:     true,
+				Autocomplete: true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "display-name",
+				Description: "New display name",
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "folder-name",
+				Description: "New folder name (no spaces). Moves every file of the category",
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionBoolean,
+				Name:        "redirect",
+				Description: "Redirect URLs with the old folder name to the new one (default: false)",
+				Required:    false,
+			},
+		},
+	}
+
+	return []*discordgo.ApplicationCommand{editDomainCmd, editCategoryCmd}
+}
+
+// checkFolderName returns why name cannot be used as a folder name, or ""
+// if it can.
+func checkFolderName(name string) string {
+	switch {
+	case strings.Contains(name, " "):
+		return "Folder name cannot contain spaces. Use dashes instead (e.g., 'my-folder' instead of 'my folder')."
+	case strings.HasPrefix(name, "."):
+		return "Folder name cannot start with a dot."
+	case name == "" || strings.ContainsAny(name, `/\`):
+		return fmt.Sprintf("Invalid folder name '%s'.", name)
+	}
+	return ""
+}
+
+func (b *Bot) handleEditDomain(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	opts := optionsByName(i.ApplicationCommandData())
+	domain := opts["domain"].StringValue()
+
+	if !b.configManager.DomainExists(domain) {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Domain '%s' not found.", domain),
+		})
+		return
+	}
+
+	var displayName, fqdn, folderName string
+	if opt, ok := opts["display-name"]; ok {
+		displayName = strings.TrimSpace(opt.StringValue())
+	}
+	if opt, ok := opts["domain-fqdn"]; ok {
+		fqdn = strings.TrimSpace(opt.StringValue())
+	}
+	if opt, ok := opts["folder-name"]; ok && opt.StringValue() != domain {
+		folderName = opt.StringValue()
+	}
+	redirect := false
+	if opt, ok := opts["redirect"]; ok {
+		redirect = opt.BoolValue()
+	}
+
+	if displayName == "" && fqdn == "" && folderName == "" {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "Nothing to change. Give a new display-name, domain-fqdn or folder-name.",
+		})
+		return
+	}
+
+	if folderName != "" {
+		if problem := checkFolderName(folderName); problem != "" {
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: problem,
+			})
+			return
+		}
+		if b.configManager.DomainExists(folderName) {
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: fmt.Sprintf("Domain with folder-name '%s' already exists.", folderName),
+			})
+			return
+		}
+	}
+
+	var changes []string
+
+	if fqdn != "" {
+		if err := b.configManager.SetDomainFQDN(domain, fqdn, redirect); err != nil {
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: fmt.Sprintf("Failed to change FQDN: %v", err),
+			})
+			return
+		}
+		newFQDN, _ := b.configManager.GetDomainFQDN(domain)
+		change := fmt.Sprintf("FQDN is now `%s`", newFQDN)
+		if redirect {
+			change += ", the old one redirects to it"
+		}
+		changes = append(changes, change)
+	}
+
+	if displayName != "" {
+		if err := b.configManager.SetDomainDisplayName(domain, displayName); err != nil {
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: fmt.Sprintf("Failed to change display name: %v", err),
+			})
+			return
+		}
+		changes = append(changes, fmt.Sprintf("display name is now %s", displayName))
+	}
+
+	var warnings []string
+	if folderName != "" {
+		err := b.storage.RenameFolder(domain, "", folderName, func() error {
+			if err := b.configManager.RenameDomain(domain, folderName); err != nil {
+				return err
+			}
+			// Uploads through channel configs resume as soon as the lock is
+			// released, so settings follow before that
+			if err := b.settingsManager.RenameDomain(domain, folderName); err != nil {
+				warnings = append(warnings, fmt.Sprintf("failed to save settings: %v", err))
+			}
+			return nil
+		})
+		if err != nil {
+			content := fmt.Sprintf("Failed to rename folder: %v", err)
+			if errors.Is(err, storage.ErrFolderExists) {
+				content = fmt.Sprintf("Storage already has files under '%s'. Choose another folder name.", folderName)
+			}
+			if len(changes) > 0 {
+				content += fmt.Sprintf("\nOther changes were applied: %s.", strings.Join(changes, "; "))
+				b.saveEditedDomains(&warnings)
+				content += formatWarnings(warnings)
+			}
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: content,
+			})
+			return
+		}
+		fmt.Printf("[Discord] Renamed domain folder %s to %s\n", domain, folderName)
+
+		if b.metadata != nil {
+			if _, err := b.metadata.Rename(domain, "", folderName); err != nil {
+				warnings = append(warnings, fmt.Sprintf("failed to update the metadata index: %v", err))
+			}
+		}
+		if b.apiKeys != nil {
+			if err := b.apiKeys.RenameDomain(domain, folderName); err != nil {
+				warnings = append(warnings, fmt.Sprintf("failed to update API keys: %v", err))
+			}
+		}
+
+		changes = append(changes, fmt.Sprintf("folder is now `%s`", folderName))
+		domain = folderName
+	}
+
+	if folderName != "" {
+		// Categories are keyed by domain folder
+		b.saveEditedConfig(&warnings)
+	} else {
+		b.saveEditedDomains(&warnings)
+	}
+
+	displayName, _ = b.configManager.GetDomainName(domain)
+	content := fmt.Sprintf("Domain %s updated: %s.", displayName, strings.Join(changes, "; "))
+	if folderName != "" {
+		content += "\nSigned links made before the rename no longer work."
+	}
+	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: content + formatWarnings(warnings),
+	})
+}
+
+func (b *Bot) saveEditedDomains(warnings *[]string) {
+	if err := b.configManager.SaveDomains(b.domainsConfig); err != nil {
+		*warnings = append(*warnings, fmt.Sprintf("domain updated in memory but failed to save to file: %v", err))
+	}
+}
+
+func (b *Bot) handleEditCategory(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	opts := optionsByName(i.ApplicationCommandData())
+	domain := opts["domain"].StringValue()
+	category := opts["category-name"].StringValue()
+
+	if _, ok := b.configManager.GetCategoryDisplayName(domain, category); !ok {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Category '%s' not found in domain '%s'.", category, domain),
+		})
+		return
+	}
+
+	var displayName, folderName string
+	if opt, ok := opts["display-name"]; ok {
+		displayName = strings.TrimSpace(opt.StringValue())
+	}
+	if opt, ok := opts["folder-name"]; ok && opt.StringValue() != category {
+		folderName = opt.StringValue()
+	}
+	redirect := false
+	if opt, ok := opts["redirect"]; ok {
+		redirect = opt.BoolValue()
+	}
+
+	if displayName == "" && folderName == "" {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "Nothing to change. Give a new display-name or folder-name.",
+		})
+		return
+	}
+
+	if folderName != "" {
+		if problem := checkFolderName(folderName); problem != "" {
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: problem,
+			})
+			return
+		}
+		if _, ok := b.configManager.GetCategoryID(domain, folderName); ok {
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: fmt.Sprintf("Category with folder-name '%s' already exists in domain '%s'.", folderName, domain),
+			})
+			return
+		}
+	}
+
+	var changes, warnings []string
+
+	if displayName != "" {
+		if err := b.configManager.SetCategoryDisplayName(domain, category, displayName); err != nil {
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: fmt.Sprintf("Failed to change display name: %v", err),
+			})
+			return
+		}
+		changes = append(changes, fmt.Sprintf("display name is now %s", displayName))
+	}
+
+	if folderName != "" {
+		err := b.storage.RenameFolder(domain, category, folderName, func() error {
+			if err := b.configManager.RenameCategory(domain, category, folderName, redirect); err != nil {
+				return err
+			}
+			if err := b.settingsManager.RenameCategory(domain, category, folderName); err != nil {
+				warnings = append(warnings, fmt.Sprintf("failed to save settings: %v", err))
+			}
+			return nil
+		})
+		if err != nil {
+			content := fmt.Sprintf("Failed to rename folder: %v", err)
+			if errors.Is(err, storage.ErrFolderExists) {
+				content = fmt.Sprintf("Storage already has files under '%s'. Choose another folder name.", folderName)
+			}
+			if len(changes) > 0 {
+				content += fmt.Sprintf("\nOther changes were applied: %s.", strings.Join(changes, "; "))
+				b.saveEditedCategories(&warnings)
+				content += formatWarnings(warnings)
+			}
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: content,
+			})
+			return
+		}
+		fmt.Printf("[Discord] Renamed category folder %s/%s to %s\n", domain, category, folderName)
+
+		if b.metadata != nil {
+			if _, err := b.metadata.Rename(domain, category, folderName); err != nil {
+				warnings = append(warnings, fmt.Sprintf("failed to update the metadata index: %v", err))
+			}
+		}
+		if b.apiKeys != nil {
+			if err := b.apiKeys.RenameCategory(domain, category, folderName); err != nil {
+				warnings = append(warnings, fmt.Sprintf("failed to update API keys: %v", err))
+			}
+		}
+
+		change := fmt.Sprintf("folder is now `%s`", folderName)
+		if redirect {
+			change += fmt.Sprintf(", URLs with `%s` redirect to it", category)
+		}
+		changes = append(changes, change)
+		category = folderName
+	}
+
+	if folderName != "" {
+		// Redirects and hotlink rules live in the domain config
+		b.saveEditedConfig(&warnings)
+	} else {
+		b.saveEditedCategories(&warnings)
+	}
+
+	displayName, _ = b.configManager.GetCategoryDisplayName(domain, category)
+	content := fmt.Sprintf("Category %s updated: %s.", displayName, strings.Join(changes, "; "))
+	if folderName != "" {
+		content += "\nSigned links made before the rename no longer work."
+	}
+	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: content + formatWarnings(warnings),
+	})
+}
+
+func (b *Bot) saveEditedCategories(warnings *[]string) {
+	if err := b.configManager.SaveCategories(b.categoriesConfig); err != nil {
+		*warnings = append(*warnings, fmt.Sprintf("category updated in memory but failed to save to file: %v", err))
+	}
+}
+
+// saveEditedConfig saves domains and categories in one go, so a reload never
+// sees a rename in one file and not the other.
+func (b *Bot) saveEditedConfig(warnings *[]string) {
+	if err := b.configManager.Save(b.domainsConfig, b.categoriesConfig); err != nil {
+		*warnings = append(*warnings, fmt.Sprintf("changes made in memory but failed to save to file: %v", err))
+	}
+}
+
+func formatWarnings(warnings []string) string {
+	if len(warnings) == 0 {
+		return ""
+	}
+	return "\nWarning: " + strings.Join(warnings, "\nWarning: ")
+}
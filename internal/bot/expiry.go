@@ -0,0 +1,97 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/vixa/cdn/internal/config"
+)
+
+func expiryCommands() []*discordgo.ApplicationCommand {
+	setCategoryTTLCmd := &discordgo.ApplicationCommand{
+		Name:        "set-category-ttl",
+		Description: "Set how long files uploaded to a category are kept",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "domain",
+				Description:  "Domain of the category",
+				Required:     true,
+				Autocomplete: true,
+			},
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "category-name",
+				Description:  "Category to change",
+				Required:     true,
+				Autocomplete: true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "ttl",
+				Description: "How long new uploads are kept, e.g. 12h, 30d or 4w, or 'off' to keep them forever",
+				Required:    true,
+			},
+		},
+	}
+
+	return []*discordgo.ApplicationCommand{setCategoryTTLCmd}
+}
+
+func (b *Bot) handleSetCategoryTTL(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	opts := optionsByName(i.ApplicationCommandData())
+	domain := opts["domain"].StringValue()
+	categoryName := opts["category-name"].StringValue()
+	ttlStr := strings.TrimSpace(opts["ttl"].StringValue())
+
+	displayName, ok := b.configManager.GetCategoryDisplayName(domain, categoryName)
+	if !ok {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Category '%s' not found in domain '%s'.", categoryName, domain),
+		})
+		return
+	}
+
+	var ttl time.Duration
+	switch strings.ToLower(ttlStr) {
+	case "off", "never", "0":
+	default:
+		var err error
+		if ttl, err = config.ParseTTL(ttlStr); err != nil {
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: fmt.Sprintf("Invalid TTL: %v", err),
+			})
+			return
+		}
+	}
+
+	if err := b.configManager.SetCategoryTTL(domain, categoryName, ttl); err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Failed to update category: %v", err),
+		})
+		return
+	}
+
+	if err := b.configManager.SaveCategories(b.categoriesConfig); err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Category updated in memory but failed to save to file: %v", err),
+		})
+		return
+	}
+
+	var content string
+	if ttl > 0 {
+		content = fmt.Sprintf("Files uploaded to `%s/%s` (%s) are now deleted after %s. Files already stored keep their expiry.", domain, categoryName, displayName, config.FormatTTL(ttl))
+	} else {
+		content = fmt.Sprintf("Files uploaded to `%s/%s` (%s) are now kept forever. Files already stored keep their expiry.", domain, categoryName, displayName)
+	}
+	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: content,
+	})
+}
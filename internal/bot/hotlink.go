@@ -0,0 +1,317 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/vixa/cdn/internal/config"
+)
+
+func hotlinkCommands() []*discordgo.ApplicationCommand {
+	setHotlinkCmd := &discordgo.ApplicationCommand{
+		Name:        "set-hotlink",
+		Description: "Restrict which sites may embed files from a domain",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "domain",
+				Description:  "Domain to protect",
+				Required:     true,
+				Autocomplete: true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "referers",
+				Description: "Comma-separated hosts allowed as Referer, e.g. example.com, *.example.com",
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "origins",
+				Description: "Comma-separated hosts allowed as CORS Origin",
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionBoolean,
+				Name:        "allow-empty-referer",
+				Description: "Allow requests without a Referer, e.g. direct visits (default: true)",
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "placeholder",
+				Description: "URL of a file on this domain served to blocked requests instead of a 403",
+				Required:    false,
+			},
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "category",
+				Description:  "Only apply to this category (default: whole domain)",
+				Required:     false,
+				Autocomplete: true,
+			},
+		},
+	}
+
+	viewHotlinkCmd := &discordgo.ApplicationCommand{
+		Name:        "view-hotlink",
+		Description: "Show the hotlink protection of a domain",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "domain",
+				Description:  "Domain to show",
+				Required:     true,
+				Autocomplete: true,
+			},
+		},
+	}
+
+	resetHotlinkCmd := &discordgo.ApplicationCommand{
+		Name:        "reset-hotlink",
+		Description: "Remove the hotlink protection of a domain or category",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "domain",
+				Description:  "Domain to reset",
+				Required:     true,
+				Autocomplete: true,
+			},
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "category",
+				Description:  "Only reset this category's override (default: domain rule)",
+				Required:     false,
+				Autocomplete: true,
+			},
+		},
+	}
+
+	return []*discordgo.ApplicationCommand{setHotlinkCmd, viewHotlinkCmd, resetHotlinkCmd}
+}
+
+// optionsByName indexes command options so optional ones can be looked up
+// regardless of which were supplied.
+func optionsByName(data discordgo.ApplicationCommandInteractionData) map[string]*discordgo.ApplicationCommandInteractionDataOption {
+	opts := make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(data.Options))
+	for _, opt := range data.Options {
+		opts[opt.Name] = opt
+	}
+	return opts
+}
+
+func (b *Bot) handleSetHotlink(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	opts := optionsByName(i.ApplicationCommandData())
+	domainFolder := opts["domain"].StringValue()
+
+	domainDisplayName, ok := b.configManager.GetDomainName(domainFolder)
+	if !ok {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "Invalid domain",
+		})
+		return
+	}
+
+	var category string
+	if opt, ok := opts["category"]; ok {
+		category = opt.StringValue()
+		if _, ok := b.configManager.GetCategoryID(domainFolder, category); !ok {
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: "Invalid category",
+			})
+			return
+		}
+	}
+
+	rule := config.HotlinkRule{AllowEmptyReferer: true}
+	if opt, ok := opts["referers"]; ok {
+		rule.Referers = config.NormalizeHostPatterns(opt.StringValue())
+	}
+	if opt, ok := opts["origins"]; ok {
+		rule.Origins = config.NormalizeHostPatterns(opt.StringValue())
+	}
+	if opt, ok := opts["allow-empty-referer"]; ok {
+		rule.AllowEmptyReferer = opt.BoolValue()
+	}
+
+	if !rule.Active() {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "Provide at least one allowed referer or origin. Use `/reset-hotlink` to remove protection.",
+		})
+		return
+	}
+
+	if opt, ok := opts["placeholder"]; ok {
+		placeholder, err := b.resolvePlaceholder(domainFolder, opt.StringValue())
+		if err != nil {
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: fmt.Sprintf("Invalid placeholder: %v", err),
+			})
+			return
+		}
+		rule.Placeholder = placeholder
+	}
+
+	if err := b.configManager.SetHotlinkRule(domainFolder, category, rule); err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Failed to update hotlink protection: %v", err),
+		})
+		return
+	}
+
+	if err := b.configManager.SaveDomains(b.domainsConfig); err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Hotlink protection updated in memory but failed to save to file: %v", err),
+		})
+		return
+	}
+
+	scope := fmt.Sprintf("`%s`", domainDisplayName)
+	if category != "" {
+		scope = fmt.Sprintf("`%s/%s`", domainDisplayName, category)
+	}
+	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: fmt.Sprintf("Hotlink protection for %s updated.\n%s", scope, formatHotlinkRule(rule)),
+	})
+}
+
+// resolvePlaceholder turns a file URL into the "category/filename" form
+// stored in the hotlink rule.
+func (b *Bot) resolvePlaceholder(domainFolder, fileURL string) (string, error) {
+	urlDomain, category, filename, err := b.configManager.ResolveFileURL(fileURL)
+	if err != nil {
+		return "", err
+	}
+	if urlDomain != domainFolder {
+		return "", fmt.Errorf("the file must be on the same domain")
+	}
+	if b.configManager.IsCategoryPrivate(domainFolder, category) {
+		return "", fmt.Errorf("the file must not be in a private category")
+	}
+
+	f, _, _, err := b.storage.OpenFile(domainFolder, category, filename)
+	if err != nil || f == nil {
+		return "", fmt.Errorf("file not found")
+	}
+	f.Close()
+
+	return category + "/" + filename, nil
+}
+
+func (b *Bot) handleViewHotlink(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	domainFolder := i.ApplicationCommandData().Options[0].StringValue()
+
+	domainDisplayName, ok := b.configManager.GetDomainName(domainFolder)
+	if !ok {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "Invalid domain",
+		})
+		return
+	}
+
+	policy, ok := b.configManager.GetHotlinkPolicy(domainFolder)
+	if !ok {
+		_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("`%s` has no hotlink protection. Files can be embedded anywhere.", domainDisplayName),
+		})
+		return
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "**Hotlink protection for `%s`**\n", domainDisplayName)
+	if policy.Active() {
+		sb.WriteString(formatHotlinkRule(policy.HotlinkRule))
+	} else {
+		sb.WriteString("No domain-wide rule.")
+	}
+
+	categories := make([]string, 0, len(policy.Categories))
+	for category := range policy.Categories {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		fmt.Fprintf(&sb, "\n\n**Category `%s`**\n%s", category, formatHotlinkRule(policy.Categories[category]))
+	}
+
+	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: sb.String(),
+	})
+}
+
+func (b *Bot) handleResetHotlink(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	opts := optionsByName(i.ApplicationCommandData())
+	domainFolder := opts["domain"].StringValue()
+	var category string
+	if opt, ok := opts["category"]; ok {
+		category = opt.StringValue()
+	}
+
+	domainDisplayName, ok := b.configManager.GetDomainName(domainFolder)
+	if !ok {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "Invalid domain",
+		})
+		return
+	}
+
+	if err := b.configManager.ClearHotlinkRule(domainFolder, category); err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Failed to reset hotlink protection: %v", err),
+		})
+		return
+	}
+
+	if err := b.configManager.SaveDomains(b.domainsConfig); err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Hotlink protection reset in memory but failed to save to file: %v", err),
+		})
+		return
+	}
+
+	scope := fmt.Sprintf("`%s`", domainDisplayName)
+	if category != "" {
+		scope = fmt.Sprintf("`%s/%s`", domainDisplayName, category)
+	}
+	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: fmt.Sprintf("Hotlink protection for %s removed.", scope),
+	})
+}
+
+func formatHotlinkRule(rule config.HotlinkRule) string {
+	list := func(hosts []string) string {
+		if len(hosts) == 0 {
+			return "any"
+		}
+		return "`" + strings.Join(hosts, "`, `") + "`"
+	}
+
+	lines := []string{
+		"Referers: " + list(rule.Referers),
+		"Origins: " + list(rule.Origins),
+	}
+	if len(rule.Referers) > 0 {
+		lines = append(lines, fmt.Sprintf("Empty referer allowed: %t", rule.AllowEmptyReferer))
+	}
+	if rule.Placeholder != "" {
+		lines = append(lines, fmt.Sprintf("Placeholder: `%s`", rule.Placeholder))
+	} else {
+		lines = append(lines, "Blocked requests get: 403 Forbidden")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,216 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/vixa/cdn/internal/config"
+	"github.com/vixa/cdn/internal/metadata"
+)
+
+// maxUploadsListed bounds the /uploads reply so it fits in one message.
+const maxUploadsListed = 15
+
+// SetMetadataIndex sets the index that records who uploaded each file.
+func (b *Bot) SetMetadataIndex(index *metadata.Index) {
+	b.metadata = index
+}
+
+func metadataCommands() []*discordgo.ApplicationCommand {
+	infoCmd := &discordgo.ApplicationCommand{
+		Name:        "info",
+		Description: "Show who uploaded a file, when, and its size and checksum",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "url",
+				Description: "Full URL of the file",
+				Required:    true,
+			},
+		},
+	}
+
+	uploadsCmd := &discordgo.ApplicationCommand{
+		Name:        "uploads",
+		Description: "List the most recent uploads, optionally by one user or domain",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionUser,
+				Name:        "user",
+				Description: "Only show files uploaded by this user",
+				Required:    false,
+			},
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "domain",
+				Description:  "Only show files on this domain",
+				Required:     false,
+				Autocomplete: true,
+			},
+		},
+	}
+
+	return []*discordgo.ApplicationCommand{infoCmd, uploadsCmd}
+}
+
+// recordUpload adds a freshly stored file to the metadata index. Failures
+// are logged only; the file itself is already stored.
+func (b *Bot) recordUpload(rec metadata.Record) {
+	if b.metadata == nil {
+		return
+	}
+	if err := b.metadata.Put(rec); err != nil {
+		fmt.Printf("[Discord] Failed to record metadata for %s/%s/%s: %v\n", rec.Domain, rec.Category, rec.Filename, err)
+	}
+}
+
+// interactionUserID returns the ID of the user who invoked an interaction,
+// which is in Member for guild interactions and in User for DMs.
+func interactionUserID(i *discordgo.InteractionCreate) string {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User.ID
+	}
+	if i.User != nil {
+		return i.User.ID
+	}
+	return ""
+}
+
+func (b *Bot) handleInfo(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	if b.metadata == nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "The metadata index is not enabled.",
+		})
+		return
+	}
+
+	fileURL := i.ApplicationCommandData().Options[0].StringValue()
+	domainFolder, category, filename, err := b.configManager.ResolveFileURL(fileURL)
+	if err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Invalid URL: %v", err),
+		})
+		return
+	}
+
+	rec, ok := b.metadata.Get(domainFolder, category, filename)
+	if !ok {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "No metadata recorded for this file.",
+		})
+		return
+	}
+
+	publicURL, _ := b.configManager.BuildFileURL(domainFolder, category, filename)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("<%s>\n", publicURL))
+	if rec.OriginalName != "" {
+		sb.WriteString(fmt.Sprintf("Original name: `%s`\n", rec.OriginalName))
+	}
+	sb.WriteString(fmt.Sprintf("Uploaded: <t:%d:f>", rec.UploadedAt.Unix()))
+	if rec.UploaderID != "" {
+		sb.WriteString(fmt.Sprintf(" by <@%s>", rec.UploaderID))
+	}
+	if rec.ChannelID != "" {
+		sb.WriteString(fmt.Sprintf(" in <#%s>", rec.ChannelID))
+	}
+	sb.WriteString(fmt.Sprintf(" (%s)\n", sourceLabel(rec.Source)))
+	if rec.ChannelID != "" && rec.MessageID != "" && i.GuildID != "" {
+		sb.WriteString(fmt.Sprintf("Message: https://discord.com/channels/%s/%s/%s\n", i.GuildID, rec.ChannelID, rec.MessageID))
+	}
+	sb.WriteString(fmt.Sprintf("Size: %s\n", config.FormatSize(rec.Size)))
+	if rec.ContentType != "" {
+		sb.WriteString(fmt.Sprintf("Type: `%s`\n", rec.ContentType))
+	}
+	if rec.SHA256 != "" {
+		sb.WriteString(fmt.Sprintf("SHA-256: `%s`\n", rec.SHA256))
+	}
+
+	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: sb.String(),
+		AllowedMentions: &discordgo.MessageAllowedMentions{
+			Parse: []discordgo.AllowedMentionType{},
+		},
+	})
+}
+
+func (b *Bot) handleUploads(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	if b.metadata == nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "The metadata index is not enabled.",
+		})
+		return
+	}
+
+	opts := optionsByName(i.ApplicationCommandData())
+	var userID, domainFolder string
+	if opt, ok := opts["user"]; ok {
+		userID = opt.UserValue(nil).ID
+	}
+	if opt, ok := opts["domain"]; ok {
+		domainFolder = opt.StringValue()
+		if !b.configManager.DomainExists(domainFolder) {
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: "Invalid domain",
+			})
+			return
+		}
+	}
+
+	records := b.metadata.Find(func(r metadata.Record) bool {
+		return (userID == "" || r.UploaderID == userID) && (domainFolder == "" || r.Domain == domainFolder)
+	})
+	if len(records) == 0 {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "No uploads found.",
+		})
+		return
+	}
+
+	var sb strings.Builder
+	for _, rec := range records[:min(len(records), maxUploadsListed)] {
+		fileURL, ok := b.configManager.BuildFileURL(rec.Domain, rec.Category, rec.Filename)
+		if !ok {
+			continue
+		}
+		name := rec.OriginalName
+		if name == "" {
+			name = rec.Filename
+		}
+		sb.WriteString(fmt.Sprintf("- <t:%d:R> `%s` (%s) <%s>\n", rec.UploadedAt.Unix(), name, config.FormatSize(rec.Size), fileURL))
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("Recent uploads (%d total)", len(records)),
+		Description: sb.String(),
+		Color:       0x808080,
+	}
+
+	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Embeds: []*discordgo.MessageEmbed{embed},
+	})
+}
+
+func sourceLabel(source string) string {
+	switch source {
+	case metadata.SourceCommand:
+		return "via /upload"
+	case metadata.SourceAuto:
+		return "auto-upload"
+	case metadata.SourceAPI:
+		return "via API"
+	case metadata.SourceBackfill:
+		return "found on disk"
+	}
+	return "unknown source"
+}
@@ -0,0 +1,101 @@
+package bot
+
+import (
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/vixa/cdn/internal/storage"
+)
+
+func namingCommands() []*discordgo.ApplicationCommand {
+	strategyChoices := []*discordgo.ApplicationCommandOptionChoice{
+		{Name: "UUID (default)", Value: storage.NamingUUID},
+		{Name: "Short base62 ID", Value: storage.NamingBase62},
+		{Name: "Original name slug with a random suffix", Value: storage.NamingSlug},
+		{Name: "Content hash prefix", Value: storage.NamingHash},
+	}
+
+	setCategoryNamingCmd := &discordgo.ApplicationCommand{
+		Name:        "set-category-naming",
+		Description: "Set how files uploaded to a category are named",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "domain",
+				Description:  "Domain of the category",
+				Required:     true,
+				Autocomplete: true,
+			},
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "category-name",
+				Description:  "Category to change",
+				Required:     true,
+				Autocomplete: true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "strategy",
+				Description: "Naming strategy",
+				Required:    true,
+				Choices:     strategyChoices,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "length",
+				Description: "Length of the ID, random suffix or hash prefix (default: 8, 6 or 12)",
+				Required:    false,
+			},
+		},
+	}
+
+	return []*discordgo.ApplicationCommand{setCategoryNamingCmd}
+}
+
+func (b *Bot) handleSetCategoryNaming(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	opts := optionsByName(i.ApplicationCommandData())
+	domain := opts["domain"].StringValue()
+	categoryName := opts["category-name"].StringValue()
+
+	naming := storage.Naming{Strategy: opts["strategy"].StringValue()}
+	if opt, ok := opts["length"]; ok {
+		naming.Length = int(opt.IntValue())
+	}
+
+	displayName, ok := b.configManager.GetCategoryDisplayName(domain, categoryName)
+	if !ok {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Category '%s' not found in domain '%s'.", categoryName, domain),
+		})
+		return
+	}
+
+	if err := naming.Validate(); err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Invalid naming: %v", err),
+		})
+		return
+	}
+
+	if err := b.configManager.SetCategoryNaming(domain, categoryName, naming); err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Failed to update category: %v", err),
+		})
+		return
+	}
+
+	if err := b.configManager.SaveCategories(b.categoriesConfig); err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Category updated in memory but failed to save to file: %v", err),
+		})
+		return
+	}
+
+	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: fmt.Sprintf("Files uploaded to `%s/%s` (%s) are now named with `%s`. Files already stored keep their names.", domain, categoryName, displayName, naming),
+	})
+}
@@ -0,0 +1,273 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/vixa/cdn/internal/config"
+	"github.com/vixa/cdn/internal/storage"
+)
+
+// maxPageSize limits custom pages, which are read into memory on upload.
+const maxPageSize = 1 << 20
+
+func pageCommands() []*discordgo.ApplicationCommand {
+	pageChoices := []*discordgo.ApplicationCommandOptionChoice{
+		{Name: "Landing page (/)", Value: config.PageLanding},
+		{Name: "404 Not Found", Value: config.PageNotFound},
+		{Name: "403 Forbidden", Value: config.PageForbidden},
+		{Name: "410 Gone", Value: config.PageGone},
+	}
+
+	setPageCmd := &discordgo.ApplicationCommand{
+		Name:        "set-page",
+		Description: "Upload a custom error page or landing page for a domain",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "domain",
+				Description:  "Domain to configure",
+				Required:     true,
+				Autocomplete: true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "page",
+				Description: "Which page to set",
+				Required:    true,
+				Choices:     pageChoices,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionAttachment,
+				Name:        "file",
+				Description: "HTML file (max 1MB)",
+				Required:    true,
+			},
+		},
+	}
+
+	removePageCmd := &discordgo.ApplicationCommand{
+		Name:        "remove-page",
+		Description: "Remove a custom error page or landing page from a domain",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "domain",
+				Description:  "Domain to configure",
+				Required:     true,
+				Autocomplete: true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "page",
+				Description: "Which page to remove",
+				Required:    true,
+				Choices:     pageChoices,
+			},
+		},
+	}
+
+	viewPagesCmd := &discordgo.ApplicationCommand{
+		Name:        "view-pages",
+		Description: "Show the custom pages of a domain",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "domain",
+				Description:  "Domain to show",
+				Required:     true,
+				Autocomplete: true,
+			},
+		},
+	}
+
+	return []*discordgo.ApplicationCommand{setPageCmd, removePageCmd, viewPagesCmd}
+}
+
+func (b *Bot) handleSetPage(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	opts := optionsByName(i.ApplicationCommandData())
+	domainFolder := opts["domain"].StringValue()
+	kind := opts["page"].StringValue()
+
+	domainDisplayName, ok := b.configManager.GetDomainName(domainFolder)
+	if !ok {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "Invalid domain",
+		})
+		return
+	}
+
+	attachment := b.findAttachment(i, opts["file"].Value.(string))
+	if attachment == nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "Attachment not found",
+		})
+		return
+	}
+
+	ext := strings.ToLower(filepath.Ext(attachment.Filename))
+	if ext != ".html" && ext != ".htm" {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "Pages must be `.html` files.",
+		})
+		return
+	}
+	if attachment.Size > maxPageSize {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Pages must be at most %d KB.", maxPageSize/1024),
+		})
+		return
+	}
+
+	upload, err := b.storage.DownloadFile(attachment.URL, maxPageSize)
+	if err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Failed to download file: %v", err),
+		})
+		return
+	}
+	data, err := io.ReadAll(upload)
+	upload.Close()
+	if err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Failed to read file: %v", err),
+		})
+		return
+	}
+
+	name := kind + ".html"
+	if err := b.storage.StorePage(domainFolder, name, data); err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Failed to store page: %v", err),
+		})
+		return
+	}
+
+	if err := b.configManager.SetDomainPage(domainFolder, kind, name); err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Failed to update domain: %v", err),
+		})
+		return
+	}
+
+	if err := b.configManager.SaveDomains(b.domainsConfig); err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Page stored but failed to save domains to file: %v", err),
+		})
+		return
+	}
+
+	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: fmt.Sprintf("%s page for `%s` updated.", pageLabel(kind), domainDisplayName),
+	})
+}
+
+func (b *Bot) handleRemovePage(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	opts := optionsByName(i.ApplicationCommandData())
+	domainFolder := opts["domain"].StringValue()
+	kind := opts["page"].StringValue()
+
+	domainDisplayName, ok := b.configManager.GetDomainName(domainFolder)
+	if !ok {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "Invalid domain",
+		})
+		return
+	}
+
+	name, ok := b.configManager.GetDomainPage(domainFolder, kind)
+	if !ok {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("`%s` has no custom %s page.", domainDisplayName, pageLabel(kind)),
+		})
+		return
+	}
+
+	if err := b.configManager.RemoveDomainPage(domainFolder, kind); err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Failed to update domain: %v", err),
+		})
+		return
+	}
+
+	if err := b.configManager.SaveDomains(b.domainsConfig); err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Page removed in memory but failed to save to file: %v", err),
+		})
+		return
+	}
+
+	if err := b.storage.DeletePage(domainFolder, name); err != nil && !errors.Is(err, storage.ErrFileNotFound) {
+		fmt.Printf("[Discord] Failed to delete page %s of %s: %v\n", name, domainFolder, err)
+	}
+
+	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: fmt.Sprintf("%s page for `%s` removed.", pageLabel(kind), domainDisplayName),
+	})
+}
+
+func (b *Bot) handleViewPages(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	domainFolder := i.ApplicationCommandData().Options[0].StringValue()
+
+	domainDisplayName, ok := b.configManager.GetDomainName(domainFolder)
+	if !ok {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "Invalid domain",
+		})
+		return
+	}
+
+	pages := b.configManager.ListDomainPages(domainFolder)
+	if len(pages) == 0 {
+		_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("`%s` has no custom pages.", domainDisplayName),
+		})
+		return
+	}
+
+	kinds := make([]string, 0, len(pages))
+	for kind := range pages {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "**Custom pages for `%s`**", domainDisplayName)
+	for _, kind := range kinds {
+		status := "ok"
+		if f, _, err := b.storage.OpenPage(domainFolder, pages[kind]); err != nil || f == nil {
+			status = "file missing"
+		} else {
+			f.Close()
+		}
+		fmt.Fprintf(&sb, "\n%s: `%s/%s` (%s)", pageLabel(kind), storage.PagesDir, pages[kind], status)
+	}
+
+	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: sb.String(),
+	})
+}
+
+func pageLabel(kind string) string {
+	if kind == config.PageLanding {
+		return "Landing"
+	}
+	return kind
+}
@@ -0,0 +1,298 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/vixa/cdn/internal/config"
+	"github.com/vixa/cdn/internal/metadata"
+)
+
+// quotaAdminPermission is required to change quotas, so users can't raise
+// their own.
+var quotaAdminPermission int64 = discordgo.PermissionManageServer
+
+func quotaCommands() []*discordgo.ApplicationCommand {
+	setQuotaCmd := &discordgo.ApplicationCommand{
+		Name:                     "set-quota",
+		Description:              "Limit the space a domain, category or user may use (omit all limits to remove)",
+		DefaultMemberPermissions: &quotaAdminPermission,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "scope",
+				Description: "What the quota applies to",
+				Required:    true,
+				Choices: []*discordgo.ApplicationCommandOptionChoice{
+					{Name: "Domain", Value: config.QuotaDomain},
+					{Name: "Category", Value: config.QuotaCategory},
+					{Name: "User", Value: config.QuotaUser},
+					{Name: "Every user (default per-user quota)", Value: config.QuotaDefaultUser},
+				},
+			},
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "domain",
+				Description:  "Domain, for the domain and category scopes",
+				Required:     false,
+				Autocomplete: true,
+			},
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "category",
+				Description:  "Category, for the category scope",
+				Required:     false,
+				Autocomplete: true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionUser,
+				Name:        "user",
+				Description: "User, for the user scope",
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "hard",
+				Description: "Uploads past this size are rejected, e.g. 10GB",
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "soft",
+				Description: "Uploads past this size get a warning, e.g. 8GB",
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "max-files",
+				Description: "Maximum number of files",
+				Required:    false,
+				MinValue:    &[]float64{1}[0],
+			},
+		},
+	}
+
+	usageCmd := &discordgo.ApplicationCommand{
+		Name:        "usage",
+		Description: "Show storage used per domain, category and user against their quotas",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "domain",
+				Description:  "Only show this domain",
+				Required:     false,
+				Autocomplete: true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionUser,
+				Name:        "user",
+				Description: "User to show (default: you)",
+				Required:    false,
+			},
+		},
+	}
+
+	return []*discordgo.ApplicationCommand{setQuotaCmd, usageCmd}
+}
+
+// checkQuotas checks an upload against the configured quotas. Quotas are
+// only enforced when the metadata index, which tracks usage, is enabled.
+func (b *Bot) checkQuotas(domainFolder, category, userID string, size int64) metadata.QuotaCheck {
+	if b.metadata == nil {
+		return metadata.QuotaCheck{}
+	}
+	return b.metadata.CheckQuotas(b.settingsManager, domainFolder, category, userID, size)
+}
+
+func (b *Bot) handleSetQuota(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	opts := optionsByName(i.ApplicationCommandData())
+	scope := opts["scope"].StringValue()
+
+	var key, label string
+	switch scope {
+	case config.QuotaDomain:
+		opt, ok := opts["domain"]
+		if !ok || !b.configManager.DomainExists(opt.StringValue()) {
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: "Pick a valid domain for a domain quota.",
+			})
+			return
+		}
+		key = opt.StringValue()
+		label = fmt.Sprintf("domain `%s`", key)
+	case config.QuotaCategory:
+		domainOpt, hasDomain := opts["domain"]
+		categoryOpt, ok := opts["category"]
+		if ok && hasDomain {
+			_, ok = b.configManager.GetCategoryID(domainOpt.StringValue(), categoryOpt.StringValue())
+		}
+		if !ok {
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: "Pick a domain and one of its categories for a category quota.",
+			})
+			return
+		}
+		key = config.CategoryScope(domainOpt.StringValue(), categoryOpt.StringValue())
+		label = fmt.Sprintf("category `%s`", key)
+	case config.QuotaUser:
+		opt, ok := opts["user"]
+		if !ok {
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: "Pick a user for a user quota.",
+			})
+			return
+		}
+		key = opt.UserValue(nil).ID
+		label = fmt.Sprintf("<@%s>", key)
+	default:
+		label = "every user"
+	}
+
+	var quota config.Quota
+	limits := []struct {
+		name  string
+		value *int64
+	}{{"hard", &quota.HardBytes}, {"soft", &quota.SoftBytes}}
+	for _, limit := range limits {
+		opt, ok := opts[limit.name]
+		if !ok {
+			continue
+		}
+		size, err := config.ParseSize(opt.StringValue())
+		if err != nil {
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: fmt.Sprintf("Invalid %s limit: %v", limit.name, err),
+			})
+			return
+		}
+		*limit.value = size
+	}
+	if opt, ok := opts["max-files"]; ok {
+		quota.MaxFiles = opt.IntValue()
+	}
+
+	if quota.HardBytes > 0 && quota.SoftBytes > quota.HardBytes {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "The soft limit must not be larger than the hard limit.",
+		})
+		return
+	}
+
+	if err := b.settingsManager.SetQuota(scope, key, quota); err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Failed to save quota: %v", err),
+		})
+		return
+	}
+
+	content := fmt.Sprintf("Removed the quota of %s.", label)
+	if !quota.IsZero() {
+		content = fmt.Sprintf("Quota of %s: %s.", label, formatQuota(quota))
+	}
+	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: content,
+		AllowedMentions: &discordgo.MessageAllowedMentions{
+			Parse: []discordgo.AllowedMentionType{},
+		},
+	})
+}
+
+func (b *Bot) handleUsage(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	if b.metadata == nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "The metadata index is not enabled, so usage is not tracked.",
+		})
+		return
+	}
+
+	opts := optionsByName(i.ApplicationCommandData())
+	domains := b.configManager.ListDomains()
+	if opt, ok := opts["domain"]; ok {
+		if !b.configManager.DomainExists(opt.StringValue()) {
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: "Invalid domain",
+			})
+			return
+		}
+		domains = []string{opt.StringValue()}
+	}
+	userID := interactionUserID(i)
+	if opt, ok := opts["user"]; ok {
+		userID = opt.UserValue(nil).ID
+	}
+
+	sort.Strings(domains)
+
+	var sb strings.Builder
+	sb.WriteString("**Domains**\n")
+	for _, domain := range domains {
+		name, _ := b.configManager.GetDomainName(domain)
+		quota, ok := b.settingsManager.GetQuota(config.QuotaDomain, domain)
+		sb.WriteString(fmt.Sprintf("- %s: %s\n", name, formatUsage(b.metadata.DomainUsage(domain), quota, ok)))
+	}
+	sb.WriteString("**Categories**\n")
+	for _, domain := range domains {
+		domainName, _ := b.configManager.GetDomainName(domain)
+		for _, category := range b.configManager.ListCategories(domain) {
+			name, _ := b.configManager.GetCategoryDisplayName(domain, category)
+			if len(domains) > 1 {
+				name = domainName + " / " + name
+			}
+			quota, ok := b.settingsManager.GetQuota(config.QuotaCategory, config.CategoryScope(domain, category))
+			sb.WriteString(fmt.Sprintf("- %s: %s\n", name, formatUsage(b.metadata.CategoryUsage(domain, category), quota, ok)))
+		}
+	}
+	if userID != "" {
+		quota, ok := b.settingsManager.GetQuota(config.QuotaUser, userID)
+		sb.WriteString(fmt.Sprintf("**User**\n- <@%s>: %s\n", userID, formatUsage(b.metadata.UploaderUsage(userID), quota, ok)))
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       "Storage usage",
+		Description: sb.String(),
+		Color:       0x808080,
+	}
+
+	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Embeds: []*discordgo.MessageEmbed{embed},
+	})
+}
+
+func formatUsage(u metadata.Usage, quota config.Quota, hasQuota bool) string {
+	usage := fmt.Sprintf("%s in %d files", config.FormatSize(u.Bytes), u.Files)
+	if !hasQuota {
+		return usage
+	}
+
+	if quota.HardBytes > 0 {
+		usage += fmt.Sprintf(" (%d%% of %s)", u.Bytes*100/quota.HardBytes, config.FormatSize(quota.HardBytes))
+	}
+	if quota.SoftBytes > 0 && u.Bytes > quota.SoftBytes {
+		usage += " - over the soft quota"
+	}
+	return usage + "\n  " + formatQuota(quota)
+}
+
+func formatQuota(q config.Quota) string {
+	var parts []string
+	if q.HardBytes > 0 {
+		parts = append(parts, "hard limit "+config.FormatSize(q.HardBytes))
+	}
+	if q.SoftBytes > 0 {
+		parts = append(parts, "soft limit "+config.FormatSize(q.SoftBytes))
+	}
+	if q.MaxFiles > 0 {
+		parts = append(parts, fmt.Sprintf("at most %d files", q.MaxFiles))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,56 @@
+package bot
+
+import (
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/vixa/cdn/internal/config"
+)
+
+// reloadAdminPermission is required to reload the config, which replaces
+// every domain, category and channel setting with what is on disk.
+var reloadAdminPermission int64 = discordgo.PermissionManageServer
+
+// SetReloader sets what /reload uses to reload the config files.
+func (b *Bot) SetReloader(reloader *config.Reloader) {
+	b.reloader = reloader
+}
+
+func reloadCommands() []*discordgo.ApplicationCommand {
+	reloadCmd := &discordgo.ApplicationCommand{
+		Name:                     "reload",
+		Description:              "Reload domains, categories and settings from the config files",
+		DefaultMemberPermissions: &reloadAdminPermission,
+	}
+
+	return []*discordgo.ApplicationCommand{reloadCmd}
+}
+
+func (b *Bot) handleReload(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	if b.reloader == nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "Reloading is not enabled.",
+		})
+		return
+	}
+
+	if err := b.reloader.Reload(); err != nil {
+		fmt.Printf("[Discord] Reload failed: %v\n", err)
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Reload failed, the previous config is still in use: %v", err),
+		})
+		return
+	}
+
+	categories := 0
+	for _, folders := range b.configManager.CategoryFolders() {
+		categories += len(folders)
+	}
+	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: fmt.Sprintf("Config reloaded: %d domain(s) and %d category(s).", len(b.configManager.ListDomains()), categories),
+	})
+}
@@ -0,0 +1,106 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/vixa/cdn/internal/metadata"
+)
+
+// maxScrubIssuesListed bounds the issues shown in the /scrub reply; the full
+// report is attached as a file when there are more.
+const maxScrubIssuesListed = 15
+
+// scrubAdminPermission is required to run a scrub, which reads every stored
+// file and may take damaged ones out of service.
+var scrubAdminPermission int64 = discordgo.PermissionManageServer
+
+func scrubCommands() []*discordgo.ApplicationCommand {
+	scrubCmd := &discordgo.ApplicationCommand{
+		Name:                     "scrub",
+		Description:              "Check every stored file against its checksum and report problems",
+		DefaultMemberPermissions: &scrubAdminPermission,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionBoolean,
+				Name:        "quarantine",
+				Description: "Move damaged files out of service (default: false, only report)",
+				Required:    false,
+			},
+		},
+	}
+
+	return []*discordgo.ApplicationCommand{scrubCmd}
+}
+
+func (b *Bot) handleScrub(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	if b.metadata == nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "The metadata index is not enabled, so there are no checksums to check against.",
+		})
+		return
+	}
+
+	quarantine := false
+	if opt, ok := optionsByName(i.ApplicationCommandData())["quarantine"]; ok {
+		quarantine = opt.BoolValue()
+	}
+
+	report, err := b.metadata.Scrub(b.storage, b.configManager.CategoryFolders(), quarantine)
+	if err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Scrub failed: %v", err),
+		})
+		return
+	}
+
+	fmt.Printf("[Discord] Scrub checked %d file(s), found %d problem(s), quarantined %d\n", report.Checked, len(report.Issues), report.Quarantined)
+
+	if len(report.Issues) == 0 {
+		_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Checked %d file(s), no problems found.", report.Checked),
+		})
+		return
+	}
+
+	var sb strings.Builder
+	for _, issue := range report.Issues[:min(len(report.Issues), maxScrubIssuesListed)] {
+		sb.WriteString(fmt.Sprintf("- `%s`\n", issue))
+	}
+
+	summary := fmt.Sprintf("Checked %d file(s), found %d problem(s)", report.Checked, len(report.Issues))
+	if quarantine {
+		summary += fmt.Sprintf(", quarantined %d file(s)", report.Quarantined)
+	}
+	embed := &discordgo.MessageEmbed{
+		Title:       "Scrub report",
+		Description: summary + ".\n\n" + sb.String(),
+		Color:       0x808080,
+	}
+
+	params := &discordgo.WebhookParams{
+		Embeds: []*discordgo.MessageEmbed{embed},
+	}
+	if len(report.Issues) > maxScrubIssuesListed {
+		params.Files = []*discordgo.File{{
+			Name:        "scrub-report.txt",
+			ContentType: "text/plain",
+			Reader:      strings.NewReader(formatScrubReport(report)),
+		}}
+	}
+	_, _ = s.FollowupMessageCreate(i.Interaction, false, params)
+}
+
+func formatScrubReport(report metadata.ScrubReport) string {
+	var sb strings.Builder
+	for _, issue := range report.Issues {
+		sb.WriteString(issue.String())
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,193 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/vixa/cdn/internal/config"
+	"github.com/vixa/cdn/internal/storage"
+)
+
+// maxTrashListed bounds the /trash list reply so it fits in one message.
+const maxTrashListed = 15
+
+// SetTrashRetention sets how long deleted files stay in the trash, which is
+// shown in replies. The trash itself is purged by the metadata janitor.
+func (b *Bot) SetTrashRetention(retention time.Duration) {
+	b.trashRetention = retention
+}
+
+func trashCommands() []*discordgo.ApplicationCommand {
+	restoreCmd := &discordgo.ApplicationCommand{
+		Name:        "restore",
+		Description: "Restore a deleted file from the trash",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "url",
+				Description: "Full URL the file had",
+				Required:    true,
+			},
+		},
+	}
+
+	trashCmd := &discordgo.ApplicationCommand{
+		Name:        "trash",
+		Description: "Manage deleted files",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "List the files in the trash",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:         discordgo.ApplicationCommandOptionString,
+						Name:         "domain",
+						Description:  "Only show the trash of this domain",
+						Required:     false,
+						Autocomplete: true,
+					},
+				},
+			},
+		},
+	}
+
+	return []*discordgo.ApplicationCommand{restoreCmd, trashCmd}
+}
+
+// trashRecord sets aside the metadata of a file moved to the trash.
+func (b *Bot) trashRecord(domainFolder, category, filename string) {
+	if b.metadata == nil {
+		return
+	}
+	if err := b.metadata.Trash(domainFolder, category, filename); err != nil {
+		fmt.Printf("[Discord] Failed to update metadata for %s/%s/%s: %v\n", domainFolder, category, filename, err)
+	}
+}
+
+func (b *Bot) handleRestore(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	fileURL := i.ApplicationCommandData().Options[0].StringValue()
+	domainFolder, category, filename, err := b.configManager.ResolveFileURL(fileURL)
+	if err != nil {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Invalid URL: %v", err),
+		})
+		return
+	}
+
+	if err := b.storage.RestoreFile(domainFolder, category, filename); err != nil {
+		var content string
+		switch {
+		case errors.Is(err, storage.ErrFileNotFound):
+			content = "This file is not in the trash."
+		case errors.Is(err, storage.ErrFileExists):
+			content = "Another file already uses this URL."
+		default:
+			content = fmt.Sprintf("Failed to restore file: %v", err)
+		}
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: content,
+		})
+		return
+	}
+
+	content := fmt.Sprintf("<%s> has been restored.", fileURL)
+	if b.metadata != nil {
+		rec, ok, err := b.metadata.Restore(domainFolder, category, filename)
+		if err != nil {
+			fmt.Printf("[Discord] Failed to restore metadata for %s/%s/%s: %v\n", domainFolder, category, filename, err)
+		}
+		if ok && rec.ExpiresAt != nil {
+			content += fmt.Sprintf("\nExpires <t:%d:R>.", rec.ExpiresAt.Unix())
+		}
+	}
+
+	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: content,
+	})
+}
+
+func (b *Bot) handleTrash(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	// list is the only subcommand so far
+	sub := i.ApplicationCommandData().Options[0]
+	opts := make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(sub.Options))
+	for _, opt := range sub.Options {
+		opts[opt.Name] = opt
+	}
+
+	domains := b.configManager.ListDomains()
+	if opt, ok := opts["domain"]; ok {
+		if !b.configManager.DomainExists(opt.StringValue()) {
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: "Invalid domain",
+			})
+			return
+		}
+		domains = []string{opt.StringValue()}
+	}
+
+	var trashed []storage.TrashedFile
+	for _, domain := range domains {
+		files, err := b.storage.ListTrash(domain)
+		if err != nil {
+			_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+				Content: fmt.Sprintf("Failed to list the trash: %v", err),
+			})
+			return
+		}
+		trashed = append(trashed, files...)
+	}
+	if len(trashed) == 0 {
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: "The trash is empty.",
+		})
+		return
+	}
+	sort.Slice(trashed, func(i, j int) bool {
+		return trashed[i].TrashedAt.After(trashed[j].TrashedAt)
+	})
+
+	var sb strings.Builder
+	for _, f := range trashed[:min(len(trashed), maxTrashListed)] {
+		fileURL, ok := b.configManager.BuildFileURL(f.Domain, f.Category, f.Filename)
+		if !ok {
+			continue
+		}
+		name := f.Filename
+		if b.metadata != nil {
+			if rec, ok := b.metadata.Trashed(f.Domain, f.Category, f.Filename); ok && rec.OriginalName != "" {
+				name = rec.OriginalName
+			}
+		}
+		sb.WriteString(fmt.Sprintf("- `%s` (%s) <%s>, deleted <t:%d:R>", name, config.FormatSize(f.Size), fileURL, f.TrashedAt.Unix()))
+		if b.trashRetention > 0 {
+			sb.WriteString(fmt.Sprintf(", purged <t:%d:R>", f.TrashedAt.Add(b.trashRetention).Unix()))
+		}
+		sb.WriteString("\n")
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("Trash (%d files)", len(trashed)),
+		Description: sb.String(),
+		Color:       0x808080,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: "Use /restore with a file's URL to bring it back",
+		},
+	}
+
+	_, _ = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Embeds: []*discordgo.MessageEmbed{embed},
+	})
+}
@@ -0,0 +1,479 @@
+package cdn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vixa/cdn/internal/config"
+	"github.com/vixa/cdn/internal/metadata"
+	"github.com/vixa/cdn/internal/metrics"
+	"github.com/vixa/cdn/internal/signing"
+	"github.com/vixa/cdn/internal/storage"
+)
+
+const (
+	apiPrefix = "/api/v1/"
+
+	// DefaultMaxUploadSize matches the largest attachment Discord accepts.
+	DefaultMaxUploadSize = 500 << 20
+)
+
+type apiFile struct {
+	Domain       string     `json:"domain"`
+	Category     string     `json:"category"`
+	Filename     string     `json:"filename"`
+	URL          string     `json:"url"`
+	Size         int64      `json:"size,omitempty"`
+	ContentType  string     `json:"content_type,omitempty"`
+	ETag         string     `json:"etag,omitempty"`
+	LastModified *time.Time `json:"last_modified,omitempty"`
+	OriginalName string     `json:"original_name,omitempty"`
+	UploaderID   string     `json:"uploader_id,omitempty"`
+	Source       string     `json:"source,omitempty"`
+	UploadedAt   *time.Time `json:"uploaded_at,omitempty"`
+	SHA256       string     `json:"sha256,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	// Warnings lists the soft quotas an upload went past.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// SetMaxUploadSize sets the largest file accepted by the upload API. Zero
+// means no limit.
+func (s *Server) SetMaxUploadSize(size int64) {
+	s.maxUploadSize = size
+}
+
+// SetQuotas sets the quotas API uploads are checked against. Like the bot,
+// the server only enforces them when it also has a metadata index, which
+// tracks usage.
+func (s *Server) SetQuotas(sm *config.SettingsManager) {
+	s.settingsManager = sm
+}
+
+// checkQuotas checks an upload against the configured quotas. API uploads
+// have no Discord user, so only domain and category quotas apply.
+func (s *Server) checkQuotas(domainFolder, category string, size int64) metadata.QuotaCheck {
+	if s.metadata == nil || s.settingsManager == nil {
+		return metadata.QuotaCheck{}
+	}
+	return s.metadata.CheckQuotas(s.settingsManager, domainFolder, category, "", size)
+}
+
+// addMetadata fills in what the metadata index knows about the file.
+func (s *Server) addMetadata(f *apiFile) {
+	if s.metadata == nil {
+		return
+	}
+	rec, ok := s.metadata.Get(f.Domain, f.Category, f.Filename)
+	if !ok {
+		return
+	}
+	f.OriginalName = rec.OriginalName
+	f.UploaderID = rec.UploaderID
+	f.Source = rec.Source
+	f.UploadedAt = &rec.UploadedAt
+	f.SHA256 = rec.SHA256
+	f.ExpiresAt = rec.ExpiresAt
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func (s *Server) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/files/{domain}/{category}", s.apiList)
+	mux.HandleFunc("POST /api/v1/files/{domain}/{category}", s.apiUpload)
+	mux.HandleFunc("GET /api/v1/files/{domain}/{category}/{filename}", s.apiMetadata)
+	mux.HandleFunc("DELETE /api/v1/files/{domain}/{category}/{filename}", s.apiDelete)
+	mux.HandleFunc("POST /api/v1/sign", s.apiSign)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "unknown endpoint"})
+	})
+	return mux
+}
+
+// apiAuthorize checks the bearer token and its scope for the domain and
+// category in the request path, writing an error response and returning
+// false when the request must not proceed.
+func (s *Server) apiAuthorize(w http.ResponseWriter, r *http.Request) (domainFolder, category string, ok bool) {
+	key, ok := s.apiAuthenticate(w, r)
+	if !ok {
+		return "", "", false
+	}
+
+	domainFolder = r.PathValue("domain")
+	category = r.PathValue("category")
+
+	if !s.apiCheckScope(w, key, domainFolder, category) {
+		return "", "", false
+	}
+	return domainFolder, category, true
+}
+
+func (s *Server) apiAuthenticate(w http.ResponseWriter, r *http.Request) (config.APIKey, bool) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || s.apiKeys == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="vixa"`)
+		writeJSON(w, http.StatusUnauthorized, apiError{Error: "missing bearer token"})
+		return config.APIKey{}, false
+	}
+
+	key, found := s.apiKeys.Authenticate(strings.TrimSpace(token))
+	if !found {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="vixa", error="invalid_token"`)
+		writeJSON(w, http.StatusUnauthorized, apiError{Error: "invalid token"})
+		return config.APIKey{}, false
+	}
+	return key, true
+}
+
+func (s *Server) apiCheckScope(w http.ResponseWriter, key config.APIKey, domainFolder, category string) bool {
+	if !key.Allows(domainFolder, category) {
+		writeJSON(w, http.StatusForbidden, apiError{Error: "token is not allowed to access this domain or category"})
+		return false
+	}
+
+	if !s.configManager.DomainExists(domainFolder) {
+		writeJSON(w, http.StatusNotFound, apiError{Error: fmt.Sprintf("domain '%s' not found", domainFolder)})
+		return false
+	}
+
+	if _, exists := s.configManager.GetCategoryID(domainFolder, category); !exists {
+		writeJSON(w, http.StatusNotFound, apiError{Error: fmt.Sprintf("category '%s' not found in domain '%s'", category, domainFolder)})
+		return false
+	}
+
+	return true
+}
+
+func (s *Server) apiList(w http.ResponseWriter, r *http.Request) {
+	domainFolder, category, ok := s.apiAuthorize(w, r)
+	if !ok {
+		return
+	}
+
+	files, err := s.storage.ListFiles(domainFolder, category)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: fmt.Sprintf("failed to list files: %v", err)})
+		return
+	}
+
+	result := make([]apiFile, 0, len(files))
+	for _, filename := range files {
+		fileURL, _ := s.configManager.BuildFileURL(domainFolder, category, filename)
+		file := apiFile{
+			Domain:   domainFolder,
+			Category: category,
+			Filename: filename,
+			URL:      fileURL,
+		}
+		s.addMetadata(&file)
+		result = append(result, file)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"files": result})
+}
+
+func (s *Server) apiMetadata(w http.ResponseWriter, r *http.Request) {
+	domainFolder, category, ok := s.apiAuthorize(w, r)
+	if !ok {
+		return
+	}
+	filename := r.PathValue("filename")
+
+	f, info, contentType, err := s.storage.OpenFile(domainFolder, category, filename)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: fmt.Sprintf("failed to open file: %v", err)})
+		return
+	}
+	if f == nil {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "file not found"})
+		return
+	}
+	defer f.Close()
+
+	etag, err := s.storage.FileETag(f, info)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: err.Error()})
+		return
+	}
+
+	modTime := info.ModTime.UTC()
+	fileURL, _ := s.configManager.BuildFileURL(domainFolder, category, filename)
+	file := apiFile{
+		Domain:       domainFolder,
+		Category:     category,
+		Filename:     filename,
+		URL:          fileURL,
+		Size:         info.Size,
+		ContentType:  contentType,
+		ETag:         etag,
+		LastModified: &modTime,
+	}
+	s.addMetadata(&file)
+	writeJSON(w, http.StatusOK, file)
+}
+
+// apiUpload accepts either a multipart form with a "file" field or a raw
+// request body. For raw uploads the extension is taken from the "filename"
+// query parameter or, failing that, from the Content-Type. The optional
+// "expires_in" query parameter overrides the category's TTL, and "name"
+// stores the file under that name instead of one generated by the
+// category's naming strategy.
+func (s *Server) apiUpload(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.apiAuthenticate(w, r)
+	if !ok {
+		return
+	}
+
+	domainFolder := r.PathValue("domain")
+	category := r.PathValue("category")
+	if !s.apiCheckScope(w, key, domainFolder, category) {
+		return
+	}
+
+	expiresAt, err := s.configManager.FileExpiry(domainFolder, category, r.URL.Query().Get("expires_in"), time.Now())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: fmt.Sprintf("invalid expires_in: %v", err)})
+		return
+	}
+
+	var (
+		upload       *storage.Upload
+		ext          string
+		originalName string
+	)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		part, perr := multipartFile(r)
+		if perr != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "missing multipart field 'file'"})
+			return
+		}
+		defer part.Close()
+
+		originalName = filepath.Base(part.FileName())
+		ext = filepath.Ext(part.FileName())
+		upload, err = s.storage.Spool(part, s.maxUploadSize, part.Header.Get("Content-Type"))
+	} else {
+		// Refuse raw uploads that announce their size before reading any of it
+		if s.maxUploadSize > 0 && r.ContentLength > s.maxUploadSize {
+			metrics.UploadFailuresTotal.Inc(metrics.SourceAPI, "too_large")
+			writeJSON(w, http.StatusRequestEntityTooLarge, apiError{Error: fmt.Sprintf("upload exceeds %d bytes", s.maxUploadSize)})
+			return
+		}
+
+		if name := r.URL.Query().Get("filename"); name != "" {
+			originalName = filepath.Base(name)
+		}
+		ext = filepath.Ext(originalName)
+		upload, err = s.storage.Spool(r.Body, s.maxUploadSize, mediaType)
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrTooLarge) {
+			metrics.UploadFailuresTotal.Inc(metrics.SourceAPI, "too_large")
+			writeJSON(w, http.StatusRequestEntityTooLarge, apiError{Error: fmt.Sprintf("upload exceeds %d bytes", s.maxUploadSize)})
+			return
+		}
+		metrics.UploadFailuresTotal.Inc(metrics.SourceAPI, "read")
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "failed to read upload"})
+		return
+	}
+	defer upload.Close()
+
+	if upload.Size == 0 {
+		metrics.UploadFailuresTotal.Inc(metrics.SourceAPI, "read")
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "empty upload"})
+		return
+	}
+
+	if ext == "" {
+		if exts, _ := mime.ExtensionsByType(upload.ContentType); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+
+	quota := s.checkQuotas(domainFolder, category, upload.Size)
+	if quota.Rejection != "" {
+		metrics.UploadFailuresTotal.Inc(metrics.SourceAPI, "quota")
+		writeJSON(w, http.StatusInsufficientStorage, apiError{Error: quota.Rejection})
+		return
+	}
+	defer quota.Release()
+
+	filename, err := s.storage.StoreFile(domainFolder, category, upload, storage.NameRequest{
+		Naming:       s.configManager.GetCategoryNaming(domainFolder, category),
+		OriginalName: strings.TrimSuffix(originalName, filepath.Ext(originalName)) + ext,
+		Name:         r.URL.Query().Get("name"),
+	})
+	switch {
+	case errors.Is(err, storage.ErrFileExists):
+		metrics.UploadFailuresTotal.Inc(metrics.SourceAPI, "name")
+		writeJSON(w, http.StatusConflict, apiError{Error: "a file with this name already exists"})
+		return
+	case errors.Is(err, storage.ErrInvalidName):
+		metrics.UploadFailuresTotal.Inc(metrics.SourceAPI, "name")
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid name"})
+		return
+	}
+	if err != nil {
+		metrics.UploadFailuresTotal.Inc(metrics.SourceAPI, "store")
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: fmt.Sprintf("failed to store file: %v", err)})
+		return
+	}
+	metrics.UploadsTotal.Inc(domainFolder, category, metrics.SourceAPI)
+	metrics.UploadBytes.Observe(float64(upload.Size), metrics.SourceAPI)
+
+	if s.metadata != nil {
+		err := s.metadata.Put(metadata.Record{
+			Domain:       domainFolder,
+			Category:     category,
+			Filename:     filename,
+			OriginalName: originalName,
+			APIKey:       key.Name,
+			Source:       metadata.SourceAPI,
+			Size:         upload.Size,
+			SHA256:       upload.SHA256,
+			ContentType:  upload.ContentType,
+			ExpiresAt:    expiresAt,
+		})
+		if err != nil {
+			fmt.Printf("[CDN] Failed to record metadata for %s/%s/%s: %v\n", domainFolder, category, filename, err)
+		}
+	}
+
+	fileURL, _ := s.configManager.BuildFileURL(domainFolder, category, filename)
+	file := apiFile{
+		Domain:      domainFolder,
+		Category:    category,
+		Filename:    filename,
+		URL:         fileURL,
+		Size:        upload.Size,
+		ContentType: upload.ContentType,
+		Warnings:    quota.Warnings,
+	}
+	s.addMetadata(&file)
+	writeJSON(w, http.StatusCreated, file)
+}
+
+// multipartFile returns the "file" part of a multipart request without
+// buffering the parts before it.
+func multipartFile(r *http.Request) (*multipart.Part, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" && part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+func (s *Server) apiDelete(w http.ResponseWriter, r *http.Request) {
+	domainFolder, category, ok := s.apiAuthorize(w, r)
+	if !ok {
+		return
+	}
+	filename := r.PathValue("filename")
+
+	if err := s.storage.TrashFile(domainFolder, category, filename); err != nil {
+		if errors.Is(err, storage.ErrFileNotFound) {
+			writeJSON(w, http.StatusNotFound, apiError{Error: "file not found"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: err.Error()})
+		return
+	}
+	metrics.DeletesTotal.Inc(domainFolder, category, metrics.SourceAPI)
+
+	if s.metadata != nil {
+		if err := s.metadata.Trash(domainFolder, category, filename); err != nil {
+			fmt.Printf("[CDN] Failed to update metadata for %s/%s/%s: %v\n", domainFolder, category, filename, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type signRequest struct {
+	URL string `json:"url"`
+	// ExpiresIn is the link lifetime, e.g. "1h" or "7d".
+	ExpiresIn string `json:"expires_in"`
+}
+
+type signResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (s *Server) apiSign(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.apiAuthenticate(w, r)
+	if !ok {
+		return
+	}
+
+	if s.signer == nil {
+		writeJSON(w, http.StatusServiceUnavailable, apiError{Error: "URL signing is not configured"})
+		return
+	}
+
+	var req signRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid JSON body"})
+		return
+	}
+
+	lifetime, err := signing.ParseLifetime(req.ExpiresIn)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+
+	domainFolder, category, filename, err := s.configManager.ResolveFileURL(req.URL)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+
+	if !s.apiCheckScope(w, key, domainFolder, category) {
+		return
+	}
+
+	f, _, _, err := s.storage.OpenFile(domainFolder, category, filename)
+	if err != nil || f == nil {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "file not found"})
+		return
+	}
+	f.Close()
+
+	expires := time.Now().Add(lifetime)
+	fileURL, _ := s.configManager.BuildFileURL(domainFolder, category, filename)
+	writeJSON(w, http.StatusOK, signResponse{
+		URL:       s.signer.SignURL(fileURL, domainFolder, category, filename, expires),
+		ExpiresAt: expires.UTC().Truncate(time.Second),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
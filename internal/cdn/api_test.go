@@ -0,0 +1,130 @@
+package cdn
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// apiRequest runs an API request. An empty token sends no Authorization
+// header.
+func apiRequest(s *Server, method, path, token string, body io.Reader, contentType string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "http://cdn.example.com"+path, body)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	return w
+}
+
+func TestAPIAuthorization(t *testing.T) {
+	s := newTestServer(t)
+	storeTestFile(t, s, "main", "docs", "a.txt", "content")
+
+	tests := []struct {
+		name, path, token string
+		want              int
+	}{
+		{"no token", "/api/v1/files/main/docs", "", http.StatusUnauthorized},
+		{"unknown token", "/api/v1/files/main/docs", "wrong", http.StatusUnauthorized},
+		{"in scope", "/api/v1/files/main/docs", "docs-token", http.StatusOK},
+		{"file in scope", "/api/v1/files/main/docs/a.txt", "docs-token", http.StatusOK},
+		{"other category", "/api/v1/files/main/images", "docs-token", http.StatusForbidden},
+		{"same category in another domain", "/api/v1/files/blog/docs", "docs-token", http.StatusForbidden},
+		{"wildcard key", "/api/v1/files/blog/docs", "all-token", http.StatusOK},
+		{"unknown category", "/api/v1/files/main/nope", "all-token", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := apiRequest(s, http.MethodGet, tt.path, tt.token, nil, "")
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d (%s)", tt.name, w.Code, tt.want, w.Body)
+		}
+		if w.Code == http.StatusUnauthorized && !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Bearer") {
+			t.Errorf("%s: WWW-Authenticate = %q", tt.name, w.Header().Get("WWW-Authenticate"))
+		}
+		if w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s: Content-Type = %q", tt.name, w.Header().Get("Content-Type"))
+		}
+	}
+
+	// Out-of-scope keys can't delete or upload either
+	if w := apiRequest(s, http.MethodDelete, "/api/v1/files/main/images/a.txt", "docs-token", nil, ""); w.Code != http.StatusForbidden {
+		t.Errorf("DELETE out of scope = %d", w.Code)
+	}
+	if w := apiRequest(s, http.MethodPost, "/api/v1/files/blog/docs", "docs-token", strings.NewReader("x"), "text/plain"); w.Code != http.StatusForbidden {
+		t.Errorf("upload out of scope = %d", w.Code)
+	}
+	if w := apiRequest(s, http.MethodPost, "/api/v1/files/main/docs", "", strings.NewReader("x"), "text/plain"); w.Code != http.StatusUnauthorized {
+		t.Errorf("upload without a token = %d", w.Code)
+	}
+}
+
+func multipartBody(t *testing.T, filename, content string) (*bytes.Buffer, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	part, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(part, content)
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf, mw.FormDataContentType()
+}
+
+func TestAPIUploadLimit(t *testing.T) {
+	s := newTestServer(t)
+	s.SetMaxUploadSize(8)
+
+	// Announced as too large
+	w := apiRequest(s, http.MethodPost, "/api/v1/files/main/docs?filename=a.txt", "docs-token", strings.NewReader("123456789"), "text/plain")
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("raw upload over the limit = %d, want 413", w.Code)
+	}
+
+	// Found too large while reading, without a Content-Length
+	r := httptest.NewRequest(http.MethodPost, "http://cdn.example.com/api/v1/files/main/docs", strings.NewReader("123456789"))
+	r.ContentLength = -1
+	r.Header.Set("Authorization", "Bearer docs-token")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, r)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("chunked upload over the limit = %d, want 413", rec.Code)
+	}
+
+	body, contentType := multipartBody(t, "big.txt", "123456789")
+	if w := apiRequest(s, http.MethodPost, "/api/v1/files/main/docs", "docs-token", body, contentType); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("multipart upload over the limit = %d, want 413", w.Code)
+	}
+	if files, _ := s.storage.ListFiles("main", "docs"); len(files) != 0 {
+		t.Errorf("files stored from refused uploads: %v", files)
+	}
+
+	// Exactly the limit is fine
+	body, contentType = multipartBody(t, "fits.txt", "12345678")
+	w = apiRequest(s, http.MethodPost, "/api/v1/files/main/docs?name=fits.txt", "docs-token", body, contentType)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("upload of exactly the limit = %d %s", w.Code, w.Body)
+	}
+	var file apiFile
+	if err := json.Unmarshal(w.Body.Bytes(), &file); err != nil {
+		t.Fatal(err)
+	}
+	if file.Filename != "fits.txt" || file.Size != 8 || file.URL != "https://cdn.example.com/docs/fits.txt" {
+		t.Errorf("uploaded file = %+v", file)
+	}
+	if got := serve(s, http.MethodGet, file.URL, nil); got.Body.String() != "12345678" {
+		t.Errorf("served upload = %q", got.Body)
+	}
+}
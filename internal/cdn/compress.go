@@ -0,0 +1,195 @@
+package cdn
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vixa/cdn/internal/storage"
+)
+
+const (
+	// Files outside this range are never compressed on the fly: small ones
+	// don't benefit and large ones would need too much memory.
+	minDynamicCompressSize = 1 << 10
+	maxDynamicCompressSize = 16 << 20
+
+	gzipVariantPrefix = "gzip-"
+)
+
+// encodings lists the content codings we serve, in order of preference, with
+// the suffix of their precompressed sidecar files.
+var encodings = []struct {
+	name   string
+	suffix string
+}{
+	{"br", ".br"},
+	{"zstd", ".zst"},
+	{"gzip", ".gz"},
+}
+
+var compressibleTypes = map[string]bool{
+	"application/javascript":        true,
+	"application/x-javascript":      true,
+	"application/json":              true,
+	"application/manifest+json":     true,
+	"application/xml":               true,
+	"application/wasm":              true,
+	"application/vnd.ms-fontobject": true,
+	"font/ttf":                      true,
+	"font/otf":                      true,
+	"image/svg+xml":                 true,
+	"image/x-icon":                  true,
+	"image/vnd.microsoft.icon":      true,
+}
+
+func isCompressible(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		compressibleTypes[mediaType]
+}
+
+// acceptedEncodings parses Accept-Encoding into coding -> accepted, honouring
+// q=0 exclusions and the "*" wildcard.
+func acceptedEncodings(header string) map[string]bool {
+	accepted := make(map[string]bool)
+	wildcard := false
+	wildcardSet := false
+
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		ok := true
+		for _, p := range strings.Split(params, ";") {
+			name, value, found := strings.Cut(strings.TrimSpace(p), "=")
+			if found && strings.EqualFold(strings.TrimSpace(name), "q") {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && q <= 0 {
+					ok = false
+				}
+			}
+		}
+
+		if coding == "*" {
+			wildcard, wildcardSet = ok, true
+			continue
+		}
+		accepted[coding] = ok
+	}
+
+	if wildcardSet {
+		for _, e := range encodings {
+			if _, explicit := accepted[e.name]; !explicit {
+				accepted[e.name] = wildcard
+			}
+		}
+	}
+	return accepted
+}
+
+// serveEncoded serves a compressed representation of the file when the client
+// accepts one, preferring precompressed sidecars and falling back to a cached
+// gzip of compressible types. It returns false when the caller should serve
+// the file as is.
+func (s *Server) serveEncoded(w http.ResponseWriter, r *http.Request, domainFolder, category, filename string, f storage.File, info storage.ObjectInfo, mediaType, etag string) bool {
+	compressible := isCompressible(mediaType)
+	accepted := acceptedEncodings(r.Header.Get("Accept-Encoding"))
+
+	varied := compressible
+	for _, e := range encodings {
+		sidecar, sidecarInfo, err := s.storage.OpenSidecar(domainFolder, category, filename, e.suffix)
+		if err != nil || sidecar == nil {
+			continue
+		}
+		varied = true
+		if !accepted[e.name] {
+			sidecar.Close()
+			continue
+		}
+		defer sidecar.Close()
+
+		setEncodingHeaders(w, e.name, etag)
+		http.ServeContent(w, r, filename, sidecarInfo.ModTime, sidecar)
+		return true
+	}
+
+	if varied {
+		addVary(w.Header(), "Accept-Encoding")
+	}
+
+	if !compressible || !accepted["gzip"] || info.Size < minDynamicCompressSize || info.Size > maxDynamicCompressSize {
+		return false
+	}
+
+	key := gzipVariantPrefix + strings.Trim(etag, `"`)
+	variant, variantInfo, err := s.storage.OpenVariant(domainFolder, category, filename, key)
+	if err != nil {
+		return false
+	}
+
+	if variant == nil {
+		data, err := gzipFile(f, info.Size)
+		if err != nil {
+			fmt.Printf("[CDN] Failed to gzip %s/%s/%s: %v\n", domainFolder, category, filename, err)
+			return false
+		}
+		if err := s.storage.StoreVariant(domainFolder, category, filename, key, data); err != nil {
+			fmt.Printf("[CDN] Failed to cache gzip variant: %v\n", err)
+			return false
+		}
+		variant, variantInfo, err = s.storage.OpenVariant(domainFolder, category, filename, key)
+		if err != nil || variant == nil {
+			return false
+		}
+	}
+	defer variant.Close()
+
+	setEncodingHeaders(w, "gzip", etag)
+	http.ServeContent(w, r, filename, variantInfo.ModTime, variant)
+	return true
+}
+
+// setEncodingHeaders marks the response as encoded and gives it an ETag that
+// differs from the identity representation's.
+func setEncodingHeaders(w http.ResponseWriter, encoding, etag string) {
+	addVary(w.Header(), "Accept-Encoding")
+	w.Header().Set("Content-Encoding", encoding)
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%s"`, strings.Trim(etag, `"`), encoding))
+}
+
+func addVary(h http.Header, field string) {
+	for _, v := range h.Values("Vary") {
+		for _, existing := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(existing), field) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", field)
+}
+
+func gzipFile(f storage.File, size int64) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(zw, f, size); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
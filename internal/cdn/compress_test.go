@@ -0,0 +1,61 @@
+package cdn
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAcceptedEncodings(t *testing.T) {
+	tests := []struct {
+		header string
+		want   map[string]bool
+	}{
+		{"", map[string]bool{}},
+		{"gzip, br", map[string]bool{"gzip": true, "br": true}},
+		{"GZIP;q=0.5, Br;q=1.0", map[string]bool{"gzip": true, "br": true}},
+		{"br;q=0, gzip", map[string]bool{"br": false, "gzip": true}},
+		{"br; q=0.000", map[string]bool{"br": false}},
+		{"gzip;q=0.001", map[string]bool{"gzip": true}},
+		{"gzip;level=1;q=0", map[string]bool{"gzip": false}},
+		{"br;q=oops", map[string]bool{"br": true}},
+		{"*", map[string]bool{"br": true, "zstd": true, "gzip": true}},
+		{"*;q=0", map[string]bool{"br": false, "zstd": false, "gzip": false}},
+		{"gzip, *;q=0", map[string]bool{"gzip": true, "br": false, "zstd": false}},
+		{"br;q=0, *", map[string]bool{"br": false, "zstd": true, "gzip": true}},
+		{"identity, , deflate", map[string]bool{"identity": true, "deflate": true}},
+	}
+	for _, tt := range tests {
+		if got := acceptedEncodings(tt.header); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("acceptedEncodings(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestServePrecompressedSidecar(t *testing.T) {
+	s := newTestServer(t)
+	storeTestFile(t, s, "main", "docs", "app.js", "console.log('plain')")
+	storeTestFile(t, s, "main", "docs", "app.js.br", "brotli bytes")
+
+	w := serve(s, http.MethodGet, "http://cdn.example.com/docs/app.js", http.Header{"Accept-Encoding": {"gzip, br"}})
+	if w.Code != http.StatusOK || w.Body.String() != "brotli bytes" {
+		t.Fatalf("GET with br = %d %q", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Encoding"); got != "br" {
+		t.Errorf("Content-Encoding = %q", got)
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/javascript") {
+		t.Errorf("Content-Type = %q, want the type of app.js", w.Header().Get("Content-Type"))
+	}
+
+	for _, accept := range []string{"", "br;q=0"} {
+		w := serve(s, http.MethodGet, "http://cdn.example.com/docs/app.js", http.Header{"Accept-Encoding": {accept}})
+		if w.Body.String() != "console.log('plain')" || w.Header().Get("Content-Encoding") != "" {
+			t.Errorf("Accept-Encoding %q: %q encoded as %q", accept, w.Body, w.Header().Get("Content-Encoding"))
+		}
+		if vary := strings.Join(w.Header().Values("Vary"), ","); !strings.Contains(vary, "Accept-Encoding") {
+			t.Errorf("Accept-Encoding %q: Vary = %q", accept, vary)
+		}
+	}
+}
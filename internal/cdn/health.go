@@ -0,0 +1,76 @@
+package cdn
+
+import (
+	"net/http"
+	"sync"
+)
+
+const (
+	healthPath = "/healthz"
+	readyPath  = "/readyz"
+)
+
+// HealthCheck returns an error when the component it checks is unhealthy.
+type HealthCheck func() error
+
+type namedCheck struct {
+	name  string
+	check HealthCheck
+}
+
+type checkResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+type healthChecks struct {
+	mu        sync.RWMutex
+	liveness  []namedCheck
+	readiness []namedCheck
+}
+
+// AddLivenessCheck registers a check reported by both /healthz and /readyz.
+func (s *Server) AddLivenessCheck(name string, check HealthCheck) {
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	s.health.liveness = append(s.health.liveness, namedCheck{name: name, check: check})
+}
+
+// AddReadinessCheck registers a check reported by /readyz only.
+func (s *Server) AddReadinessCheck(name string, check HealthCheck) {
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	s.health.readiness = append(s.health.readiness, namedCheck{name: name, check: check})
+}
+
+func (s *Server) serveHealth(w http.ResponseWriter, r *http.Request, includeReadiness bool) {
+	s.health.mu.RLock()
+	checks := append([]namedCheck{}, s.health.liveness...)
+	if includeReadiness {
+		checks = append(checks, s.health.readiness...)
+	}
+	s.health.mu.RUnlock()
+
+	resp := healthResponse{
+		Status: "ok",
+		Checks: make(map[string]checkResult, len(checks)),
+	}
+	status := http.StatusOK
+
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			resp.Checks[c.name] = checkResult{Status: "fail", Error: err.Error()}
+			resp.Status = "fail"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		resp.Checks[c.name] = checkResult{Status: "ok"}
+	}
+
+	writeJSON(w, status, resp)
+}
@@ -0,0 +1,58 @@
+package cdn
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func decodeHealth(t *testing.T, s *Server, path string) (int, healthResponse) {
+	t.Helper()
+	w := serve(s, http.MethodGet, "http://anything.example.com"+path, nil)
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("%s Content-Type = %q", path, got)
+	}
+	var resp healthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s body %q: %v", path, w.Body, err)
+	}
+	return w.Code, resp
+}
+
+func TestHealthz(t *testing.T) {
+	s := newTestServer(t)
+
+	// Not listening yet
+	status, resp := decodeHealth(t, s, healthPath)
+	if status != http.StatusServiceUnavailable || resp.Status != "fail" {
+		t.Errorf("before listening: %d %+v", status, resp)
+	}
+	if got := resp.Checks["listener"]; got.Status != "fail" || got.Error == "" {
+		t.Errorf("listener check = %+v", got)
+	}
+
+	s.listening.Store(true)
+	status, resp = decodeHealth(t, s, healthPath)
+	want := healthResponse{Status: "ok", Checks: map[string]checkResult{
+		"listener": {Status: "ok"},
+		"storage":  {Status: "ok"},
+	}}
+	if status != http.StatusOK || !reflect.DeepEqual(resp, want) {
+		t.Errorf("healthy: %d %+v", status, resp)
+	}
+
+	// Readiness checks only fail /readyz
+	s.AddReadinessCheck("discord", func() error { return errors.New("not connected") })
+	if status, resp := decodeHealth(t, s, healthPath); status != http.StatusOK || len(resp.Checks) != 2 {
+		t.Errorf("/healthz with a failing readiness check: %d %+v", status, resp)
+	}
+	status, resp = decodeHealth(t, s, readyPath)
+	if status != http.StatusServiceUnavailable || resp.Status != "fail" {
+		t.Errorf("/readyz: %d %+v", status, resp)
+	}
+	if got := resp.Checks["discord"]; got != (checkResult{Status: "fail", Error: "not connected"}) {
+		t.Errorf("discord check = %+v", got)
+	}
+}
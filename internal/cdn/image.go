@@ -0,0 +1,100 @@
+package cdn
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+
+	"github.com/vixa/cdn/internal/imaging"
+	"github.com/vixa/cdn/internal/storage"
+)
+
+// ImageLimits bound the work image transforms may cause.
+type ImageLimits struct {
+	// MaxDimension is the largest accepted w or h.
+	MaxDimension int
+	// MaxVariants is how many derived variants may be cached per source image.
+	MaxVariants int
+	// MaxSourcePixels rejects sources larger than this before decoding them.
+	MaxSourcePixels int
+}
+
+var DefaultImageLimits = ImageLimits{
+	MaxDimension:    4096,
+	MaxVariants:     25,
+	MaxSourcePixels: 40_000_000,
+}
+
+// transformSlots caps how many images are decoded and resized at once.
+var transformSlots = make(chan struct{}, runtime.NumCPU())
+
+// SetImageLimits replaces DefaultImageLimits for this server.
+func (s *Server) SetImageLimits(limits ImageLimits) {
+	s.imageLimits = limits
+}
+
+// serveImageVariant serves a resized or re-encoded copy of an image,
+// generating and caching it on first request.
+func (s *Server) serveImageVariant(w http.ResponseWriter, r *http.Request, domainFolder, category, filename string, src storage.File, sourceETag, sourceType string) {
+	opts, err := imaging.ParseOptions(r.URL.Query(), s.imageLimits.MaxDimension)
+	if err != nil {
+		transformError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Format == "" {
+		opts.Format = imaging.FormatFromContentType(sourceType)
+	}
+
+	key := opts.Key(sourceETag) + "." + opts.Format
+
+	variant, info, err := s.storage.OpenVariant(domainFolder, category, filename, key)
+	if err != nil {
+		transformError(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if variant == nil {
+		if s.storage.CountVariants(domainFolder, category, filename) >= s.imageLimits.MaxVariants {
+			transformError(w, "too many variants of this image", http.StatusForbidden)
+			return
+		}
+
+		transformSlots <- struct{}{}
+		data, _, err := imaging.Transform(src, opts, s.imageLimits.MaxSourcePixels)
+		<-transformSlots
+		if err != nil {
+			if errors.Is(err, imaging.ErrTooLarge) {
+				transformError(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			transformError(w, fmt.Sprintf("failed to transform image: %v", err), http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := s.storage.StoreVariant(domainFolder, category, filename, key, data); err != nil {
+			fmt.Printf("[CDN] Failed to cache image variant: %v\n", err)
+			transformError(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		variant, info, err = s.storage.OpenVariant(domainFolder, category, filename, key)
+		if err != nil || variant == nil {
+			transformError(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	defer variant.Close()
+
+	w.Header().Set("Content-Type", imaging.ContentType(opts.Format))
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%s"`, strings.Trim(sourceETag, `"`), key[:8]))
+	http.ServeContent(w, r, filename, info.ModTime, variant)
+}
+
+// transformError replaces the long-lived cache headers already set for the
+// source file so errors aren't cached as if they were the image.
+func transformError(w http.ResponseWriter, msg string, status int) {
+	w.Header().Set("Cache-Control", "no-store")
+	http.Error(w, msg, status)
+}
@@ -0,0 +1,184 @@
+package cdn
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vixa/cdn/internal/accesslog"
+	"github.com/vixa/cdn/internal/metrics"
+)
+
+const metricsPath = "/metrics"
+
+// Reasons used as the "reason" label of vixa_http_not_found_total.
+const (
+	notFoundUnknownHost = "unknown_host"
+	notFoundBadPath     = "bad_path"
+	notFoundBadMethod   = "bad_method"
+	notFoundMissingFile = "missing_file"
+)
+
+var (
+	requestsTotal = metrics.NewCounterVec(
+		"vixa_http_requests_total",
+		"HTTP requests served, by domain folder, category and status code.",
+		"domain", "category", "status",
+	)
+	responseBytesTotal = metrics.NewCounterVec(
+		"vixa_http_response_bytes_total",
+		"Response body bytes written, by domain folder, category and status code.",
+		"domain", "category", "status",
+	)
+	requestDuration = metrics.NewHistogramVec(
+		"vixa_http_request_duration_seconds",
+		"Time spent serving HTTP requests, by domain folder.",
+		metrics.DefaultBuckets,
+		"domain",
+	)
+	conditionalTotal = metrics.NewCounterVec(
+		"vixa_http_conditional_requests_total",
+		"Conditional GET/HEAD requests, by result (not_modified or modified).",
+		"domain", "result",
+	)
+	notFoundTotal = metrics.NewCounterVec(
+		"vixa_http_not_found_total",
+		"Requests answered with 404, by reason.",
+		"reason",
+	)
+)
+
+// requestInfo carries what the handler resolved about a request back out to
+// the instrumentation wrapper.
+type requestInfo struct {
+	domain   string
+	category string
+	filename string
+}
+
+type requestInfoKey struct{}
+
+func requestInfoFrom(r *http.Request) *requestInfo {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{}
+}
+
+// responseRecorder captures the status code and body size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(p []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	n, err := rr.ResponseWriter.Write(p)
+	rr.bytes += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps the sendfile fast path of the underlying writer available
+// to http.ServeContent.
+func (rr *responseRecorder) ReadFrom(src io.Reader) (int64, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	var n int64
+	var err error
+	if rf, ok := rr.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		n, err = io.Copy(rr.ResponseWriter, src)
+	}
+	rr.bytes += n
+	return n, err
+}
+
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+func (rr *responseRecorder) statusCode() int {
+	if rr.status == 0 {
+		return http.StatusOK
+	}
+	return rr.status
+}
+
+func (s *Server) clientIP(r *http.Request) string {
+	if s.trustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			first, _, _ := strings.Cut(fwd, ",")
+			return strings.TrimSpace(first)
+		}
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			return strings.TrimSpace(realIP)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (s *Server) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{}
+		rec := &responseRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+
+		status := rec.statusCode()
+		statusLabel := strconv.Itoa(status)
+		requestsTotal.Inc(info.domain, info.category, statusLabel)
+		responseBytesTotal.Add(float64(rec.bytes), info.domain, info.category, statusLabel)
+		duration := time.Since(start)
+		requestDuration.Observe(duration.Seconds(), info.domain)
+
+		if s.accessLog != nil {
+			s.accessLog.Log(accesslog.Entry{
+				Time:      start,
+				ClientIP:  s.clientIP(r),
+				Method:    r.Method,
+				URI:       r.RequestURI,
+				Proto:     r.Proto,
+				Host:      r.Host,
+				Domain:    info.domain,
+				Category:  info.category,
+				Filename:  info.filename,
+				Status:    status,
+				Bytes:     rec.bytes,
+				Duration:  duration,
+				Referer:   r.Referer(),
+				UserAgent: r.UserAgent(),
+			})
+		}
+
+		if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+			switch status {
+			case http.StatusNotModified:
+				conditionalTotal.Inc(info.domain, "not_modified")
+			case http.StatusOK, http.StatusPartialContent:
+				conditionalTotal.Inc(info.domain, "modified")
+			}
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package cdn
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+
+	"github.com/vixa/cdn/internal/config"
+)
+
+// servePage writes a domain's custom page with the given status. It returns
+// false, having written nothing, when the domain has no such page.
+func (s *Server) servePage(w http.ResponseWriter, r *http.Request, domainFolder, kind string, status int) bool {
+	if domainFolder == "" {
+		return false
+	}
+	name, ok := s.configManager.GetDomainPage(domainFolder, kind)
+	if !ok {
+		return false
+	}
+
+	f, info, err := s.storage.OpenPage(domainFolder, name)
+	if err != nil || f == nil {
+		return false
+	}
+	defer f.Close()
+
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "text/html; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if status == http.StatusOK {
+		http.ServeContent(w, r, name, info.ModTime, f)
+		return true
+	}
+
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		io.Copy(w, f)
+	}
+	return true
+}
+
+// serveLanding answers requests for a domain's root.
+func (s *Server) serveLanding(w http.ResponseWriter, r *http.Request, domainFolder string) {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		if s.servePage(w, r, domainFolder, config.PageLanding, http.StatusOK) {
+			return
+		}
+		w.Header().Del("Cache-Control")
+	}
+	s.serveNotFound(w, r, notFoundBadPath)
+}
+
+// serveGone answers requests for files that existed but were removed on
+// purpose.
+func (s *Server) serveGone(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	if s.servePage(w, r, requestInfoFrom(r).domain, config.PageGone, http.StatusGone) {
+		return
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.Error(w, http.StatusText(http.StatusGone), http.StatusGone)
+}
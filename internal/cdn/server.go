@@ -1,93 +1,371 @@
 package cdn
 
 import (
+	"errors"
+	"fmt"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/vixa/cdn/internal/accesslog"
 	"github.com/vixa/cdn/internal/config"
+	"github.com/vixa/cdn/internal/imaging"
+	"github.com/vixa/cdn/internal/metadata"
+	"github.com/vixa/cdn/internal/metrics"
+	"github.com/vixa/cdn/internal/signing"
 	"github.com/vixa/cdn/internal/storage"
 )
 
 type Server struct {
-	storage       *storage.Storage
-	configManager *config.ConfigManager
+	storage         *storage.Storage
+	configManager   *config.ConfigManager
+	apiKeys         *config.APIKeyManager
+	health          healthChecks
+	listening       atomic.Bool
+	publicMetrics   atomic.Bool
+	accessLog       *accesslog.Logger
+	trustProxy      bool
+	imageLimits     ImageLimits
+	signer          *signing.Signer
+	metadata        *metadata.Index
+	settingsManager *config.SettingsManager
+	maxUploadSize   int64
+	timeouts        Timeouts
 }
 
-func NewServer(storage *storage.Storage, cm *config.ConfigManager) *Server {
-	return &Server{
+// Timeouts bounds how long the HTTP listeners wait on clients. Zero means no
+// limit.
+type Timeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+}
+
+// DefaultTimeouts leaves reading and writing bodies unbounded, so large
+// uploads and downloads over slow connections can finish.
+var DefaultTimeouts = Timeouts{
+	ReadHeader: 10 * time.Second,
+	Idle:       2 * time.Minute,
+}
+
+func NewServer(storage *storage.Storage, cm *config.ConfigManager, apiKeys *config.APIKeyManager) *Server {
+	s := &Server{
 		storage:       storage,
 		configManager: cm,
+		apiKeys:       apiKeys,
+		imageLimits:   DefaultImageLimits,
+		maxUploadSize: DefaultMaxUploadSize,
+		timeouts:      DefaultTimeouts,
 	}
+
+	s.AddLivenessCheck("listener", func() error {
+		if !s.listening.Load() {
+			return errors.New("http listener is not running")
+		}
+		return nil
+	})
+	s.AddLivenessCheck("storage", storage.CheckWritable)
+
+	return s
 }
 
 func (s *Server) Handler() http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		host := r.Host
-		host = strings.TrimPrefix(host, "http://")
-		host = strings.TrimPrefix(host, "https://")
-
-		domainFolder, _, ok := s.configManager.GetDomainByFQDN(host)
-		if !ok {
-			s.serveNotFound(w, r)
+	api := s.apiHandler()
+
+	return s.instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Health and metrics endpoints don't depend on the Host header
+		switch r.URL.Path {
+		case healthPath:
+			s.serveHealth(w, r, false)
 			return
+		case readyPath:
+			s.serveHealth(w, r, true)
+			return
+		case metricsPath:
+			if s.publicMetrics.Load() {
+				metrics.Handler().ServeHTTP(w, r)
+				return
+			}
 		}
 
-		path := strings.TrimPrefix(r.URL.Path, "/")
-		parts := strings.SplitN(path, "/", 2)
-
-		if len(parts) < 2 {
-			s.serveNotFound(w, r)
+		// The management API is served on every host, ahead of file lookups
+		if strings.HasPrefix(r.URL.Path, apiPrefix) {
+			api.ServeHTTP(w, r)
 			return
 		}
 
-		category := parts[0]
-		filename := parts[1]
+		s.serveFile(w, r)
+	}))
+}
 
-		if r.Method == http.MethodOptions {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
-			w.Header().Set("Access-Control-Max-Age", "86400")
+// AdminHandler serves the endpoints meant for operators only: metrics and
+// health checks.
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, metrics.Handler())
+	mux.HandleFunc(healthPath, func(w http.ResponseWriter, r *http.Request) {
+		s.serveHealth(w, r, false)
+	})
+	mux.HandleFunc(readyPath, func(w http.ResponseWriter, r *http.Request) {
+		s.serveHealth(w, r, true)
+	})
+	return mux
+}
+
+// SetAccessLog enables per-request access logging.
+func (s *Server) SetAccessLog(logger *accesslog.Logger) {
+	s.accessLog = logger
+}
+
+// SetTrustProxyHeaders makes the server take the client IP from
+// X-Forwarded-For / X-Real-IP. Only enable it behind a reverse proxy.
+func (s *Server) SetTrustProxyHeaders(trust bool) {
+	s.trustProxy = trust
+}
+
+// SetSigner sets the key used to verify signed URLs for private categories.
+// Without a signer, files in private categories are never served.
+func (s *Server) SetSigner(signer *signing.Signer) {
+	s.signer = signer
+}
+
+// SetMetadataIndex sets the index that records where each file came from.
+// API uploads are added to it and its records are included in API responses.
+func (s *Server) SetMetadataIndex(index *metadata.Index) {
+	s.metadata = index
+}
+
+// SetTimeouts sets the timeouts of the public and admin listeners. It must
+// be called before they are started.
+func (s *Server) SetTimeouts(timeouts Timeouts) {
+	s.timeouts = timeouts
+}
+
+// SetPublicMetrics controls whether /metrics is served on the public
+// listener. It is meant for setups without a separate admin listener.
+func (s *Server) SetPublicMetrics(enabled bool) {
+	s.publicMetrics.Store(enabled)
+}
+
+// redirect permanently redirects a request for a renamed domain or category
+// to target, keeping its query.
+func (s *Server) redirect(w http.ResponseWriter, r *http.Request, target string) {
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request) {
+	info := requestInfoFrom(r)
+
+	host := r.Host
+
+	// Matches the domain's FQDN and aliases, ignoring case and port
+	domainFolder, _, ok := s.configManager.GetDomainByFQDN(host)
+	if !ok {
+		// Former FQDNs of a domain redirect to its current one
+		if fqdn, ok := s.configManager.RedirectHost(host); ok {
+			s.redirect(w, r, "https://"+fqdn+r.URL.EscapedPath())
 			return
 		}
+		s.serveNotFound(w, r, notFoundUnknownHost)
+		return
+	}
+	info.domain = domainFolder
 
-		if r.Method != http.MethodGet && r.Method != http.MethodHead {
-			s.serveNotFound(w, r)
-			return
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path == "" {
+		s.serveLanding(w, r, domainFolder)
+		return
+	}
+	parts := strings.SplitN(path, "/", 2)
+
+	// Dot folders such as the custom pages folder are never categories
+	if len(parts) < 2 || strings.HasPrefix(parts[0], ".") {
+		s.serveNotFound(w, r, notFoundBadPath)
+		return
+	}
+
+	category := parts[0]
+	filename := parts[1]
+	info.filename = filename
+	// Only label metrics with configured categories to keep cardinality bounded
+	if _, ok := s.configManager.GetCategoryID(domainFolder, category); ok {
+		info.category = category
+	} else if newCategory, ok := s.configManager.CategoryRedirect(domainFolder, category); ok {
+		_, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+		s.redirect(w, r, "/"+url.PathEscape(newCategory)+"/"+rest)
+		return
+	}
+
+	hotlink, protected := s.configManager.GetHotlinkRule(domainFolder, category)
+
+	if r.Method == http.MethodOptions {
+		if protected && len(hotlink.Origins) > 0 {
+			if !s.checkHotlink(w, r, domainFolder, host, config.HotlinkRule{Origins: hotlink.Origins}) {
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
 		}
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+		w.Header().Set("Access-Control-Max-Age", "86400")
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		s.serveNotFound(w, r, notFoundBadMethod)
+		return
+	}
+
+	if protected && !s.checkHotlink(w, r, domainFolder, host, hotlink) {
+		return
+	}
 
-		data, contentType, err := s.storage.GetFile(domainFolder, category, filename)
-		if err != nil || data == nil {
-			s.serveNotFound(w, r)
+	var signedUntil time.Time
+	if s.configManager.IsCategoryPrivate(domainFolder, category) {
+		expires, err := s.verifySignature(r, domainFolder, category, filename)
+		if err != nil {
+			s.serveForbidden(w, r, err.Error())
 			return
 		}
+		signedUntil = expires
+	}
 
-		etag := storage.GenerateETag(data)
-		if match := r.Header.Get("If-None-Match"); match == etag {
-			w.WriteHeader(http.StatusNotModified)
+	var record metadata.Record
+	if s.metadata != nil {
+		if s.metadata.Gone(domainFolder, category, filename, time.Now()) {
+			s.serveGone(w, r)
 			return
 		}
+		record, _ = s.metadata.Get(domainFolder, category, filename)
+	}
 
-		w.Header().Set("Content-Type", contentType)
-		w.Header().Set("ETag", etag)
-		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
-		w.Header().Set("X-Content-Type-Options", "nosniff")
+	f, fileInfo, contentType, err := s.storage.OpenFile(domainFolder, category, filename)
+	if err != nil || f == nil {
+		s.serveNotFound(w, r, notFoundMissingFile)
+		return
+	}
+	defer f.Close()
 
-		if r.Header.Get("Origin") != "" {
-			w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+	etag, err := s.storage.FileETag(f, fileInfo)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case !signedUntil.IsZero():
+		// Shared caches must not keep private files past the link's lifetime
+		until := signedUntil
+		if record.ExpiresAt != nil && record.ExpiresAt.Before(until) {
+			until = *record.ExpiresAt
 		}
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(time.Until(until).Seconds())))
+	case record.ExpiresAt != nil:
+		// Nor keep files past their expiry
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(time.Until(*record.ExpiresAt).Seconds())))
+	default:
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
 
-		if r.Method != http.MethodHead {
-			w.Write(data)
+	// Let browsers save the file under the name it was uploaded with
+	if record.OriginalName != "" {
+		if disposition := mime.FormatMediaType("inline", map[string]string{"filename": record.OriginalName}); disposition != "" {
+			w.Header().Set("Content-Disposition", disposition)
 		}
-	})
+	}
+
+	if r.Header.Get("Origin") != "" {
+		// Origins were already checked against the hotlink allowlist
+		w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+		addVary(w.Header(), "Origin")
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if imaging.Requested(r.URL.Query()) && imaging.SupportedSource(mediaType) {
+		s.serveImageVariant(w, r, domainFolder, category, filename, f, etag, mediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+
+	if s.serveEncoded(w, r, domainFolder, category, filename, f, fileInfo, mediaType, etag) {
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+
+	// ServeContent handles Range, If-Range, If-None-Match, If-Modified-Since
+	// and HEAD, streaming from the file instead of buffering it.
+	http.ServeContent(w, r, filename, fileInfo.ModTime, f)
 }
 
-func (s *Server) serveNotFound(w http.ResponseWriter, r *http.Request) {
+// verifySignature checks the expires/sig query parameters required for files
+// in private categories.
+func (s *Server) verifySignature(r *http.Request, domainFolder, category, filename string) (time.Time, error) {
+	if s.signer == nil {
+		return time.Time{}, signing.ErrInvalidSignature
+	}
+	query := r.URL.Query()
+	return s.signer.Verify(domainFolder, category, filename, query.Get(signing.ExpiresParam), query.Get(signing.SignatureParam), time.Now())
+}
+
+// serveForbidden answers with the domain's custom 403 page, or msg as plain
+// text when there is none.
+func (s *Server) serveForbidden(w http.ResponseWriter, r *http.Request, msg string) {
+	w.Header().Set("Cache-Control", "no-store")
+	if s.servePage(w, r, requestInfoFrom(r).domain, config.PageForbidden, http.StatusForbidden) {
+		return
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.Error(w, msg, http.StatusForbidden)
+}
+
+// serveNotFound answers with the domain's custom 404 page, or Go's plain
+// text 404 when there is none.
+func (s *Server) serveNotFound(w http.ResponseWriter, r *http.Request, reason string) {
+	notFoundTotal.Inc(reason)
 	w.Header().Set("Cache-Control", "public, max-age=60")
+	if s.servePage(w, r, requestInfoFrom(r).domain, config.PageNotFound, http.StatusNotFound) {
+		return
+	}
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	http.NotFound(w, r)
 }
 
+// ListenAndServeAdmin serves AdminHandler on a separate listener.
+func (s *Server) ListenAndServeAdmin(addr string) error {
+	return s.httpServer(addr, s.AdminHandler()).ListenAndServe()
+}
+
 func (s *Server) ListenAndServe(addr string) error {
-	return http.ListenAndServe(addr, s.Handler())
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.listening.Store(true)
+	defer s.listening.Store(false)
+
+	return s.httpServer(addr, s.Handler()).Serve(ln)
+}
+
+func (s *Server) httpServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: s.timeouts.ReadHeader,
+		ReadTimeout:       s.timeouts.Read,
+		WriteTimeout:      s.timeouts.Write,
+		IdleTimeout:       s.timeouts.Idle,
+	}
 }
@@ -0,0 +1,216 @@
+package cdn
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vixa/cdn/internal/config"
+	"github.com/vixa/cdn/internal/storage"
+)
+
+const testAPIKeys = `[
+	{"name": "docs", "token": "docs-token", "domains": ["main"], "categories": ["main/docs"]},
+	{"name": "all", "token": "all-token", "domains": ["*"], "categories": ["*"]}
+]`
+
+// newTestServer serves the domain "main" on cdn.example.com, with the
+// categories docs and images, and the "blog" domain on blog.example.com.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	stor, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cm := config.NewConfigManager()
+	for _, d := range []struct{ folder, fqdn string }{{"main", "cdn.example.com"}, {"blog", "blog.example.com"}} {
+		if err := cm.AddDomain(d.folder, d.folder, d.fqdn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []struct{ domain, folder string }{{"main", "docs"}, {"main", "images"}, {"blog", "docs"}} {
+		if err := cm.AddCategory(c.domain, c.folder, c.folder); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keysPath := filepath.Join(t.TempDir(), "api-keys.json")
+	if err := os.WriteFile(keysPath, []byte(testAPIKeys), 0600); err != nil {
+		t.Fatal(err)
+	}
+	apiKeys, err := config.NewAPIKeyManager(keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewServer(stor, cm, apiKeys)
+}
+
+func storeTestFile(t *testing.T, s *Server, domainFolder, category, name, content string) {
+	t.Helper()
+	upload, err := s.storage.Spool(strings.NewReader(content), 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upload.Close()
+	if _, err := s.storage.StoreFile(domainFolder, category, upload, storage.NameRequest{Name: name}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serve runs a request against the public handler. header may be nil.
+func serve(s *Server, method, target string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	return w
+}
+
+func TestServeFile(t *testing.T) {
+	s := newTestServer(t)
+	storeTestFile(t, s, "main", "docs", "a.txt", "hello, world")
+
+	w := serve(s, http.MethodGet, "http://CDN.example.com:8080/docs/a.txt", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "hello, world" {
+		t.Fatalf("GET = %d %q", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if w.Header().Get("ETag") == "" || w.Header().Get("Last-Modified") == "" {
+		t.Errorf("validators missing: %v", w.Header())
+	}
+
+	for _, target := range []string{
+		"http://cdn.example.com/docs/missing.txt",
+		"http://unknown.example.com/docs/a.txt",
+		"http://blog.example.com/docs/a.txt",
+		"http://cdn.example.com/a.txt",
+		"http://cdn.example.com/.refs/docs/a.txt",
+	} {
+		if w := serve(s, http.MethodGet, target, nil); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", target, w.Code)
+		}
+	}
+}
+
+func TestServeRange(t *testing.T) {
+	s := newTestServer(t)
+	storeTestFile(t, s, "main", "docs", "abc.txt", "abcdefghijklmnopqrstuvwxyz")
+
+	w := serve(s, http.MethodGet, "http://cdn.example.com/docs/abc.txt", http.Header{"Range": {"bytes=2-5"}})
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want 206", w.Code)
+	}
+	if w.Body.String() != "cdef" {
+		t.Errorf("body = %q", w.Body)
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 2-5/26" {
+		t.Errorf("Content-Range = %q", got)
+	}
+
+	w = serve(s, http.MethodGet, "http://cdn.example.com/docs/abc.txt", http.Header{"Range": {"bytes=-3"}})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "xyz" {
+		t.Errorf("suffix range = %d %q", w.Code, w.Body)
+	}
+
+	w = serve(s, http.MethodGet, "http://cdn.example.com/docs/abc.txt", http.Header{"Range": {"bytes=30-"}})
+	if w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("range past the end = %d, want 416", w.Code)
+	}
+
+	// A range for an older version of the file gets the whole file
+	w = serve(s, http.MethodGet, "http://cdn.example.com/docs/abc.txt", http.Header{"Range": {"bytes=2-5"}, "If-Range": {`"stale"`}})
+	if w.Code != http.StatusOK || w.Body.Len() != 26 {
+		t.Errorf("If-Range with another ETag = %d, %d bytes", w.Code, w.Body.Len())
+	}
+}
+
+func TestServeNotModified(t *testing.T) {
+	s := newTestServer(t)
+	storeTestFile(t, s, "main", "docs", "a.txt", "cached content")
+
+	first := serve(s, http.MethodGet, "http://cdn.example.com/docs/a.txt", nil)
+	etag, lastModified := first.Header().Get("ETag"), first.Header().Get("Last-Modified")
+
+	for name, header := range map[string]http.Header{
+		"If-None-Match":      {"If-None-Match": {etag}},
+		"weak If-None-Match": {"If-None-Match": {"W/" + etag}},
+		"If-Modified-Since":  {"If-Modified-Since": {lastModified}},
+	} {
+		w := serve(s, http.MethodGet, "http://cdn.example.com/docs/a.txt", header)
+		if w.Code != http.StatusNotModified {
+			t.Errorf("%s: status = %d, want 304", name, w.Code)
+		}
+		if w.Body.Len() != 0 {
+			t.Errorf("%s: 304 has a body", name)
+		}
+	}
+
+	w := serve(s, http.MethodGet, "http://cdn.example.com/docs/a.txt", http.Header{"If-None-Match": {`"other"`}})
+	if w.Code != http.StatusOK {
+		t.Errorf("other ETag: status = %d, want 200", w.Code)
+	}
+
+	w = serve(s, http.MethodHead, "http://cdn.example.com/docs/a.txt", nil)
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("Content-Length") != "14" {
+		t.Errorf("HEAD = %d, %d bytes, Content-Length %q", w.Code, w.Body.Len(), w.Header().Get("Content-Length"))
+	}
+}
+
+func TestServeHotlinkProtection(t *testing.T) {
+	s := newTestServer(t)
+	storeTestFile(t, s, "main", "images", "cat.txt", "a cat")
+	storeTestFile(t, s, "main", "docs", "blocked.txt", "no embedding")
+	err := s.configManager.SetHotlinkRule("main", "images", config.HotlinkRule{
+		Referers:    []string{"*.example.org"},
+		Origins:     []string{"app.example.org"},
+		Placeholder: "docs/blocked.txt",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   string
+	}{
+		{"allowed referer", http.Header{"Referer": {"https://www.example.org/page"}}, "a cat"},
+		{"own host", http.Header{"Referer": {"https://cdn.example.com/"}}, "a cat"},
+		{"other referer", http.Header{"Referer": {"https://example.net/"}}, "no embedding"},
+		{"apex of a wildcard", http.Header{"Referer": {"https://example.org/"}}, "no embedding"},
+		{"no referer", nil, "no embedding"},
+		{"other origin", http.Header{"Referer": {"https://www.example.org/"}, "Origin": {"https://evil.example.org"}}, "no embedding"},
+	}
+	for _, tt := range tests {
+		w := serve(s, http.MethodGet, "http://cdn.example.com/images/cat.txt", tt.header)
+		if w.Code != http.StatusOK || w.Body.String() != tt.want {
+			t.Errorf("%s: %d %q, want %q", tt.name, w.Code, w.Body, tt.want)
+		}
+		if vary := w.Header().Values("Vary"); !strings.Contains(strings.Join(vary, ","), "Referer") {
+			t.Errorf("%s: Vary = %v", tt.name, vary)
+		}
+	}
+
+	// Without a placeholder, blocked requests are refused
+	if err := s.configManager.SetHotlinkRule("main", "images", config.HotlinkRule{Referers: []string{"*.example.org"}}); err != nil {
+		t.Fatal(err)
+	}
+	w := serve(s, http.MethodGet, "http://cdn.example.com/images/cat.txt", http.Header{"Referer": {"https://example.net/"}})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("blocked without placeholder = %d, want 403", w.Code)
+	}
+	body, _ := io.ReadAll(w.Body)
+	if !strings.Contains(string(body), "hotlinking") {
+		t.Errorf("403 body = %q", body)
+	}
+}
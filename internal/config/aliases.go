@@ -0,0 +1,195 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// hostIndex maps hostnames to domain folders. Exact hosts are looked up
+// directly; wildcard entries are keyed by the suffix after "*." and found by
+// trying each parent of the host, so a lookup costs one map access per label
+// whatever the number of domains.
+type hostIndex struct {
+	exact    map[string]string
+	wildcard map[string]string
+	// redirect maps former FQDNs to the domain they now redirect to. They
+	// are only consulted for hosts no domain serves.
+	redirect map[string]string
+}
+
+func (h hostIndex) lookup(host string) (string, bool) {
+	if host == "" {
+		return "", false
+	}
+	if folder, ok := h.exact[host]; ok {
+		return folder, true
+	}
+	// The longest matching suffix wins, so *.eu.example.com takes
+	// precedence over *.example.com
+	for rest := host; ; {
+		_, parent, found := strings.Cut(rest, ".")
+		if !found {
+			return "", false
+		}
+		if folder, ok := h.wildcard[parent]; ok {
+			return folder, true
+		}
+		rest = parent
+	}
+}
+
+// owner returns the domain folder that has exactly this FQDN or alias,
+// which may be a wildcard pattern.
+func (h hostIndex) owner(pattern string) (string, bool) {
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		folder, ok := h.wildcard[suffix]
+		return folder, ok
+	}
+	folder, ok := h.exact[pattern]
+	return folder, ok
+}
+
+func (h *hostIndex) add(pattern, folder string) {
+	if h.exact == nil {
+		h.exact = make(map[string]string)
+		h.wildcard = make(map[string]string)
+	}
+	if owner, taken := h.owner(pattern); taken {
+		if owner != folder {
+			fmt.Printf("[Config] Host '%s' is claimed by domains '%s' and '%s', using '%s'\n", pattern, owner, folder, owner)
+		}
+		return
+	}
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		h.wildcard[suffix] = folder
+	} else {
+		h.exact[pattern] = folder
+	}
+}
+
+// rebuildHostIndex indexes the FQDN, aliases and redirect hosts of every
+// domain. FQDNs go first so an alias can never take over another domain's
+// own host. The caller must hold cm.mu for writing.
+func (cm *ConfigManager) rebuildHostIndex() {
+	folders := make([]string, 0, len(cm.domains))
+	for folder := range cm.domains {
+		folders = append(folders, folder)
+	}
+	sort.Strings(folders)
+
+	var hosts hostIndex
+	for _, folder := range folders {
+		if host := NormalizeHost(cm.domainFQDNs[folder]); host != "" {
+			hosts.add(host, folder)
+		}
+	}
+	for _, folder := range folders {
+		for _, alias := range cm.domainAliases[folder] {
+			if host := NormalizeHost(alias); host != "" {
+				hosts.add(host, folder)
+			}
+		}
+	}
+	for _, folder := range folders {
+		for _, old := range cm.domainRedirectHosts[folder] {
+			if host := NormalizeHost(old); host != "" {
+				if hosts.redirect == nil {
+					hosts.redirect = make(map[string]string)
+				}
+				hosts.redirect[host] = folder
+			}
+		}
+	}
+	cm.hosts = hosts
+}
+
+// NormalizeHost turns a Host header, FQDN or URL into the form hosts are
+// matched in: lowercase, without scheme, path, port or trailing dot.
+func NormalizeHost(host string) string {
+	host = strings.ToLower(stripProtocol(strings.TrimSpace(host)))
+	host, _, _ = strings.Cut(host, "/")
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimPrefix(strings.TrimSuffix(host, "]"), "[")
+	return strings.TrimSuffix(host, ".")
+}
+
+// validAlias reports whether a normalized alias is a hostname or a
+// wildcard of the form *.example.com.
+func validAlias(alias string) bool {
+	name := strings.TrimPrefix(alias, "*.")
+	if name == "" || !strings.Contains(name, ".") && alias != name {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ListDomainAliases returns the aliases of a domain.
+func (cm *ConfigManager) ListDomainAliases(folderName string) []string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return slices.Clone(cm.domainAliases[folderName])
+}
+
+// AddDomainAlias serves a domain on another hostname, or on every subdomain
+// of a name with "*.example.com". It returns the alias as stored.
+func (cm *ConfigManager) AddDomainAlias(folderName, alias string) (string, error) {
+	alias = NormalizeHost(alias)
+	if !validAlias(alias) {
+		return "", fmt.Errorf("'%s' is not a valid hostname or *.example.com wildcard", alias)
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if _, exists := cm.domains[folderName]; !exists {
+		return "", fmt.Errorf("domain '%s' not found", folderName)
+	}
+	if owner, taken := cm.hosts.owner(alias); taken {
+		if owner == folderName {
+			return "", fmt.Errorf("'%s' already points to this domain", alias)
+		}
+		return "", fmt.Errorf("'%s' is already used by domain '%s'", alias, owner)
+	}
+
+	cm.domainAliases[folderName] = append(cm.domainAliases[folderName], alias)
+	cm.rebuildHostIndex()
+	return alias, nil
+}
+
+// RemoveDomainAlias stops serving a domain on an alias.
+func (cm *ConfigManager) RemoveDomainAlias(folderName, alias string) error {
+	alias = NormalizeHost(alias)
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	aliases := cm.domainAliases[folderName]
+	i := slices.IndexFunc(aliases, func(a string) bool { return NormalizeHost(a) == alias })
+	if i < 0 {
+		return fmt.Errorf("domain '%s' has no alias '%s'", folderName, alias)
+	}
+
+	aliases = slices.Delete(slices.Clone(aliases), i, i+1)
+	if len(aliases) == 0 {
+		delete(cm.domainAliases, folderName)
+	} else {
+		cm.domainAliases[folderName] = aliases
+	}
+	cm.rebuildHostIndex()
+	return nil
+}
@@ -0,0 +1,118 @@
+package config
+
+import "testing"
+
+func TestNormalizeHost(t *testing.T) {
+	tests := map[string]string{
+		"cdn.example.com":               "cdn.example.com",
+		"CDN.Example.COM":               "cdn.example.com",
+		"cdn.example.com:8080":          "cdn.example.com",
+		"cdn.example.com.":              "cdn.example.com",
+		"https://cdn.example.com/files": "cdn.example.com",
+		" http://CDN.example.com:443/ ": "cdn.example.com",
+		"[::1]:8080":                    "::1",
+		"[::1]":                         "::1",
+		"127.0.0.1:80":                  "127.0.0.1",
+		"*.Example.com":                 "*.example.com",
+		"":                              "",
+	}
+	for in, want := range tests {
+		if got := NormalizeHost(in); got != want {
+			t.Errorf("NormalizeHost(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestValidAlias(t *testing.T) {
+	tests := map[string]bool{
+		"static.example.com":   true,
+		"localhost":            true,
+		"*.example.com":        true,
+		"*.eu.example.com":     true,
+		"a-b.example.com":      true,
+		"*":                    false,
+		"*.com":                false,
+		"":                     false,
+		"*.":                   false,
+		"static..example.com":  false,
+		"-static.example.com":  false,
+		"static-.example.com":  false,
+		"static_1.example.com": false,
+		"foo.*.example.com":    false,
+		"::1":                  false,
+	}
+	for alias, want := range tests {
+		if got := validAlias(alias); got != want {
+			t.Errorf("validAlias(%q) = %v, want %v", alias, got, want)
+		}
+	}
+}
+
+func TestHostIndexLookup(t *testing.T) {
+	var hosts hostIndex
+	for pattern, folder := range map[string]string{
+		"example.com":      "apex",
+		"*.example.com":    "any",
+		"*.eu.example.com": "eu",
+		"cdn.example.com":  "main",
+		"::1":              "local",
+	} {
+		hosts.add(pattern, folder)
+	}
+
+	tests := []struct {
+		host   string
+		folder string
+	}{
+		{"cdn.example.com", "main"},
+		{"example.com", "apex"},
+		{"img.example.com", "any"},
+		{"a.b.example.com", "any"},
+		{"img.eu.example.com", "eu"},
+		{"a.img.eu.example.com", "eu"},
+		{"eu.example.com", "any"},
+		{"::1", "local"},
+		{"example.org", ""},
+		{"com", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		folder, ok := hosts.lookup(tt.host)
+		if ok != (tt.folder != "") || folder != tt.folder {
+			t.Errorf("lookup(%q) = %q, %v; want %q", tt.host, folder, ok, tt.folder)
+		}
+	}
+
+	// A wildcard alone does not serve the bare apex
+	var wildcardOnly hostIndex
+	wildcardOnly.add("*.example.com", "any")
+	if folder, ok := wildcardOnly.lookup("example.com"); ok {
+		t.Errorf("*.example.com matches the apex: %q", folder)
+	}
+}
+
+func TestDomainLookupIgnoresPortAndCase(t *testing.T) {
+	cm := newTestConfig(t)
+	if _, err := cm.AddDomainAlias("blog", "*.Blog.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.AddDomainAlias("main", "[::1]:8080"); err == nil {
+		t.Error("IPv6 address accepted as an alias")
+	}
+
+	tests := map[string]string{
+		"CDN.example.com":             "main",
+		"cdn.example.com:8080":        "main",
+		"cdn.example.com.":            "main",
+		"blog.example.com:443":        "blog",
+		"Photos.BLOG.example.com:443": "blog",
+		"example.com":                 "",
+		"[::1]:8080":                  "",
+	}
+	for host, want := range tests {
+		folder, _, ok := cm.GetDomainByFQDN(host)
+		if ok != (want != "") || folder != want {
+			t.Errorf("GetDomainByFQDN(%q) = %q, %v; want %q", host, folder, ok, want)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package config
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/vixa/cdn/internal/atomicfile"
+)
+
+// APIKey grants bearer-token access to the HTTP API. Domains lists the
+// domain folder names the key may touch and Categories the categories, as
+// CategoryScope names; "*" allows all of them.
+type APIKey struct {
+	Name       string   `json:"name"`
+	Token      string   `json:"token"`
+	Domains    []string `json:"domains"`
+	Categories []string `json:"categories"`
+}
+
+// Allows reports whether the key is scoped to the given domain and category.
+func (k APIKey) Allows(domainFolder, category string) bool {
+	return scopeContains(k.Domains, domainFolder) && scopeContains(k.Categories, CategoryScope(domainFolder, category))
+}
+
+// AllowsDomain reports whether the key is scoped to the given domain.
+func (k APIKey) AllowsDomain(domainFolder string) bool {
+	return scopeContains(k.Domains, domainFolder)
+}
+
+func scopeContains(scope []string, name string) bool {
+	for _, s := range scope {
+		if s == "*" || s == name {
+			return true
+		}
+	}
+	return false
+}
+
+type APIKeyManager struct {
+	keysPath string
+	keys     []APIKey
+	mu       sync.RWMutex
+}
+
+func NewAPIKeyManager(keysPath string) (*APIKeyManager, error) {
+	km := &APIKeyManager{
+		keysPath: keysPath,
+		keys:     []APIKey{},
+	}
+
+	if err := km.load(); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to load api keys: %w", err)
+		}
+		// File doesn't exist, create it with no keys (API disabled)
+		if err := km.save(); err != nil {
+			return nil, fmt.Errorf("failed to create initial api keys file: %w", err)
+		}
+	}
+
+	return km, nil
+}
+
+func (km *APIKeyManager) load() error {
+	data, err := os.ReadFile(km.keysPath)
+	if err != nil {
+		return err
+	}
+
+	var keys []APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("failed to parse api keys: %w", err)
+	}
+
+	for _, k := range keys {
+		if k.Token == "" {
+			return fmt.Errorf("api key '%s' has an empty token", k.Name)
+		}
+	}
+
+	km.mu.Lock()
+	km.keys = keys
+	km.mu.Unlock()
+
+	return nil
+}
+
+func (km *APIKeyManager) save() error {
+	km.mu.RLock()
+	data, err := json.MarshalIndent(km.keys, "", "  ")
+	km.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal api keys: %w", err)
+	}
+
+	dir := getDirectory(km.keysPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create api keys directory: %w", err)
+	}
+
+	if err := atomicfile.WriteFile(km.keysPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write api keys file: %w", err)
+	}
+
+	return nil
+}
+
+// Authenticate returns the key matching token. Tokens are compared in
+// constant time.
+func (km *APIKeyManager) Authenticate(token string) (APIKey, bool) {
+	if token == "" {
+		return APIKey{}, false
+	}
+
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	for _, k := range km.keys {
+		if subtle.ConstantTimeCompare([]byte(k.Token), []byte(token)) == 1 {
+			return k, true
+		}
+	}
+	return APIKey{}, false
+}
+
+func (km *APIKeyManager) HasKeys() bool {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	return len(km.keys) > 0
+}
+
+// MigrateCategoryScopes rewrites category scopes that name a folder alone,
+// as older versions matched it in every domain, to the categories of that
+// name in the domains the key may touch, and saves the keys. Names no domain
+// has yet are left as they are. It reports whether any key changed. cm must
+// be fully loaded.
+func (km *APIKeyManager) MigrateCategoryScopes(cm *ConfigManager) (bool, error) {
+	km.mu.Lock()
+	changed := false
+	for i := range km.keys {
+		k := &km.keys[i]
+		if !slices.ContainsFunc(k.Categories, isUnscopedCategory) {
+			continue
+		}
+		migrated := make([]string, 0, len(k.Categories))
+		keyChanged := false
+		for _, name := range k.Categories {
+			var domains []string
+			if isUnscopedCategory(name) {
+				domains = cm.domainsWithCategory(name)
+			}
+			if len(domains) == 0 {
+				// Names no domain has yet are kept for when one gets them
+				if !slices.Contains(migrated, name) {
+					migrated = append(migrated, name)
+				}
+				continue
+			}
+			for _, domainFolder := range domains {
+				if scope := CategoryScope(domainFolder, name); k.AllowsDomain(domainFolder) && !slices.Contains(migrated, scope) {
+					migrated = append(migrated, scope)
+				}
+			}
+			keyChanged = true
+		}
+		if keyChanged {
+			k.Categories = migrated
+			changed = true
+		}
+	}
+	km.mu.Unlock()
+
+	if !changed {
+		return false, nil
+	}
+	return true, km.save()
+}
+
+func isUnscopedCategory(name string) bool {
+	return name != "*" && !strings.Contains(name, "/")
+}
+
+// RenameDomain updates the scopes of every key that lists the domain or one
+// of its categories.
+func (km *APIKeyManager) RenameDomain(folderName, newFolderName string) error {
+	domains := km.renameScopes(func(k *APIKey) *[]string { return &k.Domains }, func(name string) (string, bool) {
+		return newFolderName, name == folderName
+	})
+	categories := km.renameScopes(func(k *APIKey) *[]string { return &k.Categories }, func(name string) (string, bool) {
+		category, ok := strings.CutPrefix(name, folderName+"/")
+		return CategoryScope(newFolderName, category), ok
+	})
+	if !domains && !categories {
+		return nil
+	}
+	return km.save()
+}
+
+// RenameCategory updates the scopes of every key that lists the category.
+func (km *APIKeyManager) RenameCategory(domainFolder, folderName, newFolderName string) error {
+	from, to := CategoryScope(domainFolder, folderName), CategoryScope(domainFolder, newFolderName)
+	if !km.renameScopes(func(k *APIKey) *[]string { return &k.Categories }, func(name string) (string, bool) {
+		return to, name == from
+	}) {
+		return nil
+	}
+	return km.save()
+}
+
+// renameScopes replaces the names rename matches in one scope of every key
+// and reports whether any key changed. Scopes are rebuilt rather than edited
+// in place, as keys returned by Authenticate share them.
+func (km *APIKeyManager) renameScopes(scope func(*APIKey) *[]string, rename func(string) (string, bool)) bool {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	changed := false
+	for i := range km.keys {
+		names := scope(&km.keys[i])
+		renamed := make([]string, 0, len(*names))
+		keyChanged := false
+		for _, name := range *names {
+			if newName, ok := rename(name); ok {
+				name = newName
+				keyChanged = true
+			}
+			if !slices.Contains(renamed, name) {
+				renamed = append(renamed, name)
+			}
+		}
+		if keyChanged {
+			*names = renamed
+			changed = true
+		}
+	}
+	return changed
+}
@@ -3,37 +3,81 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
+	"sort"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/vixa/cdn/internal/atomicfile"
+	"github.com/vixa/cdn/internal/storage"
 )
 
 type Domain struct {
 	FolderName  string `json:"folder-name"`
 	DisplayName string `json:"display-name"`
 	DomainFQDN  string `json:"domain-fqdn"`
+	// Aliases are further hostnames the domain is served on, such as
+	// "www.cdn.example.com" or "*.example.com" for every subdomain
+	Aliases []string       `json:"aliases,omitempty"`
+	Hotlink *HotlinkPolicy `json:"hotlink,omitempty"`
+	// Pages maps page kinds (see PageKinds) to file names in the domain's
+	// reserved pages folder
+	Pages map[string]string `json:"pages,omitempty"`
+	// Redirects maps the old folder names of renamed categories to their
+	// new ones, so links to the old names keep working
+	Redirects map[string]string `json:"redirects,omitempty"`
+	// RedirectHosts are former FQDNs whose requests are redirected to the
+	// current one
+	RedirectHosts []string `json:"redirect-hosts,omitempty"`
 }
 
+// Category is a folder within one domain. Categories of different domains
+// are independent, even when they share a folder name.
 type Category struct {
+	// Domain is the folder name of the domain the category belongs to.
+	// Files written by older versions have no domain; see LoadCategories.
+	Domain      string `json:"domain,omitempty"`
 	FolderName  string `json:"folder-name"`
 	DisplayName string `json:"display-name"`
-}
-
-type Config struct {
-	BotToken         string `env:"BOT_TOKEN" envDefault:""`
-	StoragePath      string `env:"STORAGE_PATH" envDefault:"./storage"`
-	Port             int    `env:"PORT" envDefault:"8080"`
-	DomainsConfig    string `env:"DOMAINS_CONFIG" envDefault:"./configs/domains.json"`
-	CategoriesConfig string `env:"CATEGORIES_CONFIG" envDefault:"./configs/categories.json"`
+	Private     bool   `json:"private,omitempty"`
+	// TTL is how long files uploaded to the category are kept, e.g. "7d".
+	TTL string `json:"ttl,omitempty"`
+	// Naming is how new files are named, e.g. "uuid", "base62:10", "slug"
+	// or "hash". Empty means uuid.
+	Naming string `json:"naming,omitempty"`
 }
 
 type ConfigManager struct {
 	domains              map[string]string // folder-name -> exists
 	domainDisplayNames   map[string]string // folder-name -> display-name
 	domainFQDNs          map[string]string // folder-name -> domain-fqdn
-	categories           map[string]string // folder-name -> exists
-	categoryDisplayNames map[string]string // folder-name -> display-name
-	mu                   sync.RWMutex
+	domainAliases        map[string][]string
+	hosts                hostIndex
+	domainHotlink        map[string]*HotlinkPolicy
+	domainPages          map[string]map[string]string
+	domainRedirects      map[string]map[string]string
+	domainRedirectHosts  map[string][]string
+	categories           map[categoryKey]string // -> exists
+	categoryDisplayNames map[categoryKey]string // -> display-name
+	categoryPrivate      map[categoryKey]bool   // -> requires signed URLs
+	categoryTTL          map[categoryKey]time.Duration
+	categoryNaming       map[categoryKey]storage.Naming
+	// unassignedCategories are categories from before categories belonged
+	// to a domain, kept until there is a domain to migrate them to
+	unassignedCategories []Category
+	categoriesMigrated   bool
+	// reloader, if set, is told about writes to the config files so it
+	// doesn't reload them
+	reloader *Reloader
+	mu       sync.RWMutex
+}
+
+// categoryKey identifies a category by its domain and folder name.
+type categoryKey struct {
+	domain string
+	folder string
 }
 
 func NewConfigManager() *ConfigManager {
@@ -41,8 +85,16 @@ func NewConfigManager() *ConfigManager {
 		domains:              make(map[string]string),
 		domainDisplayNames:   make(map[string]string),
 		domainFQDNs:          make(map[string]string),
-		categories:           make(map[string]string),
-		categoryDisplayNames: make(map[string]string),
+		domainAliases:        make(map[string][]string),
+		domainHotlink:        make(map[string]*HotlinkPolicy),
+		domainPages:          make(map[string]map[string]string),
+		domainRedirects:      make(map[string]map[string]string),
+		domainRedirectHosts:  make(map[string][]string),
+		categories:           make(map[categoryKey]string),
+		categoryDisplayNames: make(map[categoryKey]string),
+		categoryPrivate:      make(map[categoryKey]bool),
+		categoryTTL:          make(map[categoryKey]time.Duration),
+		categoryNaming:       make(map[categoryKey]storage.Naming),
 	}
 }
 
@@ -63,17 +115,43 @@ func (cm *ConfigManager) LoadDomains(configPath string) error {
 	cm.domains = make(map[string]string)
 	cm.domainDisplayNames = make(map[string]string)
 	cm.domainFQDNs = make(map[string]string)
+	cm.domainAliases = make(map[string][]string)
+	cm.domainHotlink = make(map[string]*HotlinkPolicy)
+	cm.domainPages = make(map[string]map[string]string)
+	cm.domainRedirects = make(map[string]map[string]string)
+	cm.domainRedirectHosts = make(map[string][]string)
 	for _, d := range domains {
 		// Normalize folder name: replace spaces with dashes, keep casing
 		normalizedFolderName := strings.ReplaceAll(d.FolderName, " ", "-")
 		cm.domains[normalizedFolderName] = "exists"
 		cm.domainDisplayNames[normalizedFolderName] = d.DisplayName
 		cm.domainFQDNs[normalizedFolderName] = d.DomainFQDN
+		if len(d.Aliases) > 0 {
+			cm.domainAliases[normalizedFolderName] = d.Aliases
+		}
+		if d.Hotlink != nil {
+			cm.domainHotlink[normalizedFolderName] = d.Hotlink
+		}
+		if len(d.Pages) > 0 {
+			cm.domainPages[normalizedFolderName] = d.Pages
+		}
+		if len(d.Redirects) > 0 {
+			cm.domainRedirects[normalizedFolderName] = d.Redirects
+		}
+		if len(d.RedirectHosts) > 0 {
+			cm.domainRedirectHosts[normalizedFolderName] = d.RedirectHosts
+		}
 	}
+	cm.rebuildHostIndex()
 
 	return nil
 }
 
+// LoadCategories loads categories.json. Domains must be loaded first:
+// categories written before categories belonged to a domain are copied to
+// every configured domain, which keeps them working as before, and
+// CategoriesMigrated reports that the file should be saved again. Without
+// any domain they are kept as they are until the next load.
 func (cm *ConfigManager) LoadCategories(configPath string) error {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
@@ -88,36 +166,159 @@ func (cm *ConfigManager) LoadCategories(configPath string) error {
 		return fmt.Errorf("failed to parse categories config: %w", err)
 	}
 
-	cm.categories = make(map[string]string)
-	cm.categoryDisplayNames = make(map[string]string)
+	cm.categories = make(map[categoryKey]string)
+	cm.categoryDisplayNames = make(map[categoryKey]string)
+	cm.categoryPrivate = make(map[categoryKey]bool)
+	cm.categoryTTL = make(map[categoryKey]time.Duration)
+	cm.categoryNaming = make(map[categoryKey]storage.Naming)
+	cm.unassignedCategories = nil
+	cm.categoriesMigrated = false
+
+	var legacy []Category
 	for _, c := range categories {
-		// Normalize folder name: replace spaces with dashes, keep casing
-		normalizedFolderName := strings.ReplaceAll(c.FolderName, " ", "-")
-		cm.categories[normalizedFolderName] = "exists"
-		cm.categoryDisplayNames[normalizedFolderName] = c.DisplayName
+		if c.Domain == "" {
+			legacy = append(legacy, c)
+			continue
+		}
+		if err := cm.loadCategory(c); err != nil {
+			return err
+		}
 	}
 
+	if len(legacy) > 0 && len(cm.domains) == 0 {
+		fmt.Printf("[Config] %d category(s) have no domain and there is no domain to move them to yet\n", len(legacy))
+		cm.unassignedCategories = legacy
+		return nil
+	}
+	for _, c := range legacy {
+		for domainFolder := range cm.domains {
+			key := categoryKey{domainFolder, strings.ReplaceAll(c.FolderName, " ", "-")}
+			if _, exists := cm.categories[key]; exists {
+				continue
+			}
+			c.Domain = domainFolder
+			if err := cm.loadCategory(c); err != nil {
+				return err
+			}
+		}
+	}
+	if len(legacy) > 0 {
+		fmt.Printf("[Config] Migrated %d category(s) without a domain to all %d domain(s)\n", len(legacy), len(cm.domains))
+		cm.categoriesMigrated = true
+	}
+
+	return nil
+}
+
+// loadCategory adds a category read from categories.json. The caller must
+// hold cm.mu for writing.
+func (cm *ConfigManager) loadCategory(c Category) error {
+	// Normalize folder name: replace spaces with dashes, keep casing
+	key := categoryKey{c.Domain, strings.ReplaceAll(c.FolderName, " ", "-")}
+	cm.categories[key] = "exists"
+	cm.categoryDisplayNames[key] = c.DisplayName
+	if c.Private {
+		cm.categoryPrivate[key] = true
+	}
+	if c.TTL != "" {
+		ttl, err := ParseTTL(c.TTL)
+		if err != nil {
+			return fmt.Errorf("invalid ttl for category '%s/%s': %w", key.domain, key.folder, err)
+		}
+		cm.categoryTTL[key] = ttl
+	}
+	if c.Naming != "" {
+		naming, err := storage.ParseNaming(c.Naming)
+		if err != nil {
+			return fmt.Errorf("invalid naming for category '%s/%s': %w", key.domain, key.folder, err)
+		}
+		cm.categoryNaming[key] = naming
+	}
 	return nil
 }
 
-func (cm *ConfigManager) GetCategoryID(name string) (string, bool) {
+// CategoriesMigrated reports whether the last LoadCategories moved
+// categories without a domain to the configured domains.
+func (cm *ConfigManager) CategoriesMigrated() bool {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
-	_, ok := cm.categories[name]
+	return cm.categoriesMigrated
+}
+
+func (cm *ConfigManager) GetCategoryID(domainFolder, name string) (string, bool) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	_, ok := cm.categories[categoryKey{domainFolder, name}]
 	return name, ok
 }
 
-func (cm *ConfigManager) ListCategories() []string {
+// ListCategories returns the folder names of a domain's categories.
+func (cm *ConfigManager) ListCategories(domainFolder string) []string {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
 
-	names := make([]string, 0, len(cm.categories))
-	for name := range cm.categories {
-		names = append(names, name)
+	var names []string
+	for key := range cm.categories {
+		if key.domain == domainFolder {
+			names = append(names, key.folder)
+		}
 	}
+	sort.Strings(names)
 	return names
 }
 
+// domainsWithCategory returns the domains that have a category with the
+// given folder name.
+func (cm *ConfigManager) domainsWithCategory(folderName string) []string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	var domains []string
+	for key := range cm.categories {
+		if key.folder == folderName {
+			domains = append(domains, key.domain)
+		}
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// ListAllCategories returns the folder names used by categories of any
+// domain, each once.
+func (cm *ConfigManager) ListAllCategories() []string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var names []string
+	for key := range cm.categories {
+		if !seen[key.folder] {
+			seen[key.folder] = true
+			names = append(names, key.folder)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CategoryFolders returns the category folder names of every domain, keyed
+// by domain folder name.
+func (cm *ConfigManager) CategoryFolders() map[string][]string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	folders := make(map[string][]string, len(cm.domains))
+	for domainFolder := range cm.domains {
+		folders[domainFolder] = []string{}
+	}
+	for key := range cm.categories {
+		if _, ok := folders[key.domain]; ok {
+			folders[key.domain] = append(folders[key.domain], key.folder)
+		}
+	}
+	return folders
+}
+
 func (cm *ConfigManager) ListDomains() []string {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
@@ -166,28 +367,53 @@ func (cm *ConfigManager) GetDomainFQDN(folderName string) (string, bool) {
 	return url, true
 }
 
+// GetDomainByFQDN returns the domain served on a host, matching its FQDN
+// and aliases case-insensitively and ignoring any port.
 func (cm *ConfigManager) GetDomainByFQDN(fqdn string) (folderName string, displayName string, ok bool) {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
-	for folder, domainFQDN := range cm.domainFQDNs {
-		if domainFQDN == fqdn {
-			display := cm.domainDisplayNames[folder]
-			return folder, display, true
-		}
+	folder, ok := cm.hosts.lookup(NormalizeHost(fqdn))
+	if !ok {
+		return "", "", false
 	}
-	return "", "", false
+	return folder, cm.domainDisplayNames[folder], true
 }
 
-func (cm *ConfigManager) GetCategoryDisplayName(folderName string) (string, bool) {
+func (cm *ConfigManager) GetCategoryDisplayName(domainFolder, folderName string) (string, bool) {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
-	displayName, ok := cm.categoryDisplayNames[folderName]
+	displayName, ok := cm.categoryDisplayNames[categoryKey{domainFolder, folderName}]
 	if !ok {
 		return folderName, false
 	}
 	return displayName, true
 }
 
+// IsCategoryPrivate reports whether files in a category are only served
+// through signed URLs.
+func (cm *ConfigManager) IsCategoryPrivate(domainFolder, folderName string) bool {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.categoryPrivate[categoryKey{domainFolder, folderName}]
+}
+
+func (cm *ConfigManager) SetCategoryPrivate(domainFolder, folderName string, private bool) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	key := categoryKey{domainFolder, folderName}
+	if _, exists := cm.categories[key]; !exists {
+		return fmt.Errorf("category '%s' not found in domain '%s'", folderName, domainFolder)
+	}
+
+	if private {
+		cm.categoryPrivate[key] = true
+	} else {
+		delete(cm.categoryPrivate, key)
+	}
+	return nil
+}
+
 func (cm *ConfigManager) HasDomains() bool {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
@@ -218,10 +444,14 @@ func (cm *ConfigManager) AddDomain(folderName, displayName, domainFQDN string) e
 	if _, exists := cm.domains[normalizedFolderName]; exists {
 		return fmt.Errorf("domain with folder-name '%s' already exists", normalizedFolderName)
 	}
+	if owner, taken := cm.hosts.owner(NormalizeHost(cleanFQDN)); taken {
+		return fmt.Errorf("host '%s' is already used by domain '%s'", cleanFQDN, owner)
+	}
 
 	cm.domains[normalizedFolderName] = "exists"
 	cm.domainDisplayNames[normalizedFolderName] = displayName
 	cm.domainFQDNs[normalizedFolderName] = cleanFQDN
+	cm.rebuildHostIndex()
 
 	return nil
 }
@@ -237,50 +467,82 @@ func (cm *ConfigManager) RemoveDomain(folderName string) error {
 	delete(cm.domains, folderName)
 	delete(cm.domainDisplayNames, folderName)
 	delete(cm.domainFQDNs, folderName)
+	delete(cm.domainAliases, folderName)
+	delete(cm.domainHotlink, folderName)
+	delete(cm.domainPages, folderName)
+	delete(cm.domainRedirects, folderName)
+	delete(cm.domainRedirectHosts, folderName)
+	cm.rebuildHostIndex()
+	for key := range cm.categories {
+		if key.domain == folderName {
+			cm.removeCategory(key)
+		}
+	}
 
 	return nil
 }
 
-func (cm *ConfigManager) AddCategory(folderName, displayName string) error {
+func (cm *ConfigManager) AddCategory(domainFolder, folderName, displayName string) error {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
-	normalizedFolderName := strings.ReplaceAll(folderName, " ", "-")
+	if _, exists := cm.domains[domainFolder]; !exists {
+		return fmt.Errorf("domain '%s' not found", domainFolder)
+	}
 
-	if _, exists := cm.categories[normalizedFolderName]; exists {
-		return fmt.Errorf("category with folder-name '%s' already exists", normalizedFolderName)
+	key := categoryKey{domainFolder, strings.ReplaceAll(folderName, " ", "-")}
+	if _, exists := cm.categories[key]; exists {
+		return fmt.Errorf("category with folder-name '%s' already exists in domain '%s'", key.folder, domainFolder)
 	}
 
-	cm.categories[normalizedFolderName] = "exists"
-	cm.categoryDisplayNames[normalizedFolderName] = displayName
+	cm.categories[key] = "exists"
+	cm.categoryDisplayNames[key] = displayName
 
 	return nil
 }
 
-func (cm *ConfigManager) RemoveCategory(folderName string) error {
+func (cm *ConfigManager) RemoveCategory(domainFolder, folderName string) error {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
-	if _, exists := cm.categories[folderName]; !exists {
-		return fmt.Errorf("category '%s' not found", folderName)
+	key := categoryKey{domainFolder, folderName}
+	if _, exists := cm.categories[key]; !exists {
+		return fmt.Errorf("category '%s' not found in domain '%s'", folderName, domainFolder)
 	}
-
-	delete(cm.categories, folderName)
-	delete(cm.categoryDisplayNames, folderName)
+	cm.removeCategory(key)
 
 	return nil
 }
 
+// removeCategory forgets a category. The caller must hold cm.mu for
+// writing.
+func (cm *ConfigManager) removeCategory(key categoryKey) {
+	delete(cm.categories, key)
+	delete(cm.categoryDisplayNames, key)
+	delete(cm.categoryPrivate, key)
+	delete(cm.categoryTTL, key)
+	delete(cm.categoryNaming, key)
+}
+
 func (cm *ConfigManager) SaveDomains(configPath string) error {
+	return cm.watchingReloader().saving(func() error { return cm.writeDomains(configPath) }, configPath)
+}
+
+func (cm *ConfigManager) writeDomains(configPath string) error {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
 
 	domains := make([]Domain, 0, len(cm.domains))
 	for folderName := range cm.domains {
 		domains = append(domains, Domain{
-			FolderName:  folderName,
-			DisplayName: cm.domainDisplayNames[folderName],
-			DomainFQDN:  cm.domainFQDNs[folderName],
+			FolderName:    folderName,
+			DisplayName:   cm.domainDisplayNames[folderName],
+			DomainFQDN:    cm.domainFQDNs[folderName],
+			Aliases:       cm.domainAliases[folderName],
+			Hotlink:       cm.domainHotlink[folderName],
+			Pages:         cm.domainPages[folderName],
+			Redirects:     cm.domainRedirects[folderName],
+			RedirectHosts: cm.domainRedirectHosts[folderName],
 		})
 	}
 
@@ -294,7 +556,7 @@ func (cm *ConfigManager) SaveDomains(configPath string) error {
 		return fmt.Errorf("failed to create domains directory: %w", err)
 	}
 
-	if err := os.WriteFile(configPath, data, 0644); err != nil {
+	if err := atomicfile.WriteFile(configPath, data, 0644); err != nil {
 		return fmt.Errorf("failed to write domains file: %w", err)
 	}
 
@@ -302,16 +564,31 @@ func (cm *ConfigManager) SaveDomains(configPath string) error {
 }
 
 func (cm *ConfigManager) SaveCategories(configPath string) error {
+	return cm.watchingReloader().saving(func() error { return cm.writeCategories(configPath) }, configPath)
+}
+
+func (cm *ConfigManager) writeCategories(configPath string) error {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
 
-	categories := make([]Category, 0, len(cm.categories))
-	for folderName := range cm.categories {
+	categories := make([]Category, 0, len(cm.categories)+len(cm.unassignedCategories))
+	for key := range cm.categories {
 		categories = append(categories, Category{
-			FolderName:  folderName,
-			DisplayName: cm.categoryDisplayNames[folderName],
+			Domain:      key.domain,
+			FolderName:  key.folder,
+			DisplayName: cm.categoryDisplayNames[key],
+			Private:     cm.categoryPrivate[key],
+			TTL:         FormatTTL(cm.categoryTTL[key]),
+			Naming:      formatNaming(cm.categoryNaming[key]),
 		})
 	}
+	sort.Slice(categories, func(i, j int) bool {
+		if categories[i].Domain != categories[j].Domain {
+			return categories[i].Domain < categories[j].Domain
+		}
+		return categories[i].FolderName < categories[j].FolderName
+	})
+	categories = append(categories, cm.unassignedCategories...)
 
 	data, err := json.MarshalIndent(categories, "", "  ")
 	if err != nil {
@@ -323,9 +600,61 @@ func (cm *ConfigManager) SaveCategories(configPath string) error {
 		return fmt.Errorf("failed to create categories directory: %w", err)
 	}
 
-	if err := os.WriteFile(configPath, data, 0644); err != nil {
+	if err := atomicfile.WriteFile(configPath, data, 0644); err != nil {
 		return fmt.Errorf("failed to write categories file: %w", err)
 	}
 
 	return nil
 }
+
+// Save writes domains and categories together, for changes such as renames
+// that touch both. A Reloader watching the files never reads one of them
+// written and the other not.
+func (cm *ConfigManager) Save(domainsPath, categoriesPath string) error {
+	return cm.watchingReloader().saving(func() error {
+		if err := cm.writeDomains(domainsPath); err != nil {
+			return err
+		}
+		return cm.writeCategories(categoriesPath)
+	}, domainsPath, categoriesPath)
+}
+
+func (cm *ConfigManager) watchingReloader() *Reloader {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.reloader
+}
+
+// BuildFileURL returns the public URL of a stored file.
+func (cm *ConfigManager) BuildFileURL(domainFolder, category, filename string) (string, bool) {
+	domainURL, ok := cm.GetDomainFQDN(domainFolder)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("https://%s/%s/%s", domainURL, url.PathEscape(category), url.PathEscape(filename)), true
+}
+
+// ResolveFileURL maps a public file URL back to the domain folder, category
+// and filename it refers to.
+func (cm *ConfigManager) ResolveFileURL(rawURL string) (domainFolder, category, filename string, err error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid URL: %w", err)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(u.Path, "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", fmt.Errorf("invalid URL format")
+	}
+
+	domainFolder, _, ok := cm.GetDomainByFQDN(u.Host)
+	if !ok {
+		return "", "", "", fmt.Errorf("domain '%s' not found in configuration", u.Host)
+	}
+
+	return domainFolder, parts[0], parts[1], nil
+}
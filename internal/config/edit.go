@@ -0,0 +1,219 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// moveKey moves the entry of from, if any, to to.
+func moveKey[K comparable, V any](m map[K]V, from, to K) {
+	if v, ok := m[from]; ok {
+		delete(m, from)
+		m[to] = v
+	}
+}
+
+// SetDomainDisplayName changes the name a domain is shown with.
+func (cm *ConfigManager) SetDomainDisplayName(folderName, displayName string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if _, exists := cm.domains[folderName]; !exists {
+		return fmt.Errorf("domain '%s' not found", folderName)
+	}
+	cm.domainDisplayNames[folderName] = displayName
+	return nil
+}
+
+// SetDomainFQDN moves a domain to another host. With redirect, requests for
+// the old host are redirected to the new one, keeping their path.
+func (cm *ConfigManager) SetDomainFQDN(folderName, domainFQDN string, redirect bool) error {
+	cleanFQDN := stripProtocol(strings.TrimSpace(domainFQDN))
+	host := NormalizeHost(cleanFQDN)
+	if strings.HasPrefix(host, "*.") || !validAlias(host) {
+		return fmt.Errorf("'%s' is not a valid hostname", domainFQDN)
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if _, exists := cm.domains[folderName]; !exists {
+		return fmt.Errorf("domain '%s' not found", folderName)
+	}
+	if owner, taken := cm.hosts.owner(host); taken && owner != folderName {
+		return fmt.Errorf("host '%s' is already used by domain '%s'", host, owner)
+	}
+
+	oldHost := NormalizeHost(cm.domainFQDNs[folderName])
+	cm.domainFQDNs[folderName] = cleanFQDN
+	if oldHost == host {
+		return nil
+	}
+
+	// The new host may have been an alias or a former FQDN of this domain
+	isHost := func(h string) bool { return NormalizeHost(h) == host }
+	if aliases := slices.DeleteFunc(slices.Clone(cm.domainAliases[folderName]), isHost); len(aliases) > 0 {
+		cm.domainAliases[folderName] = aliases
+	} else {
+		delete(cm.domainAliases, folderName)
+	}
+	redirectHosts := slices.DeleteFunc(slices.Clone(cm.domainRedirectHosts[folderName]), isHost)
+	if redirect && oldHost != "" && !slices.Contains(redirectHosts, oldHost) {
+		redirectHosts = append(redirectHosts, oldHost)
+	}
+	if len(redirectHosts) > 0 {
+		cm.domainRedirectHosts[folderName] = redirectHosts
+	} else {
+		delete(cm.domainRedirectHosts, folderName)
+	}
+
+	cm.rebuildHostIndex()
+	return nil
+}
+
+// RenameDomain changes the folder name of a domain and of everything that
+// refers to it. It only updates the config; the storage folder is moved
+// with storage.RenameFolder.
+func (cm *ConfigManager) RenameDomain(folderName, newFolderName string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if _, exists := cm.domains[folderName]; !exists {
+		return fmt.Errorf("domain '%s' not found", folderName)
+	}
+	if !validFolderName(newFolderName) || strings.Contains(newFolderName, " ") {
+		return fmt.Errorf("invalid folder-name '%s'", newFolderName)
+	}
+	if _, exists := cm.domains[newFolderName]; exists {
+		return fmt.Errorf("domain with folder-name '%s' already exists", newFolderName)
+	}
+
+	moveKey(cm.domains, folderName, newFolderName)
+	moveKey(cm.domainDisplayNames, folderName, newFolderName)
+	moveKey(cm.domainFQDNs, folderName, newFolderName)
+	moveKey(cm.domainAliases, folderName, newFolderName)
+	moveKey(cm.domainHotlink, folderName, newFolderName)
+	moveKey(cm.domainPages, folderName, newFolderName)
+	moveKey(cm.domainRedirects, folderName, newFolderName)
+	moveKey(cm.domainRedirectHosts, folderName, newFolderName)
+	cm.rebuildHostIndex()
+
+	for key := range cm.categories {
+		if key.domain == folderName {
+			cm.moveCategory(key, categoryKey{newFolderName, key.folder})
+		}
+	}
+	return nil
+}
+
+// SetCategoryDisplayName changes the name a category is shown with.
+func (cm *ConfigManager) SetCategoryDisplayName(domainFolder, folderName, displayName string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	key := categoryKey{domainFolder, folderName}
+	if _, exists := cm.categories[key]; !exists {
+		return fmt.Errorf("category '%s' not found in domain '%s'", folderName, domainFolder)
+	}
+	cm.categoryDisplayNames[key] = displayName
+	return nil
+}
+
+// RenameCategory changes the folder name of a category, along with its
+// hotlink rules and placeholders. With redirect, URLs using the old name are
+// redirected to the new one. It only updates the config; the storage folder
+// is moved with storage.RenameFolder.
+func (cm *ConfigManager) RenameCategory(domainFolder, folderName, newFolderName string, redirect bool) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	key := categoryKey{domainFolder, folderName}
+	if _, exists := cm.categories[key]; !exists {
+		return fmt.Errorf("category '%s' not found in domain '%s'", folderName, domainFolder)
+	}
+	if !validFolderName(newFolderName) || strings.Contains(newFolderName, " ") {
+		return fmt.Errorf("invalid folder-name '%s'", newFolderName)
+	}
+	newKey := categoryKey{domainFolder, newFolderName}
+	if _, exists := cm.categories[newKey]; exists {
+		return fmt.Errorf("category with folder-name '%s' already exists in domain '%s'", newFolderName, domainFolder)
+	}
+
+	cm.moveCategory(key, newKey)
+
+	if policy, ok := cm.domainHotlink[domainFolder]; ok {
+		moveKey(policy.Categories, folderName, newFolderName)
+		policy.Placeholder = renamePlaceholder(policy.Placeholder, folderName, newFolderName)
+		for category, rule := range policy.Categories {
+			rule.Placeholder = renamePlaceholder(rule.Placeholder, folderName, newFolderName)
+			policy.Categories[category] = rule
+		}
+	}
+
+	// Earlier redirects follow the category to its new name, and the new
+	// name stops redirecting anywhere
+	redirects := cm.domainRedirects[domainFolder]
+	if redirects == nil {
+		redirects = make(map[string]string)
+	}
+	for from, to := range redirects {
+		if to == folderName {
+			redirects[from] = newFolderName
+		}
+	}
+	delete(redirects, newFolderName)
+	if redirect {
+		redirects[folderName] = newFolderName
+	}
+	if len(redirects) > 0 {
+		cm.domainRedirects[domainFolder] = redirects
+	} else {
+		delete(cm.domainRedirects, domainFolder)
+	}
+	return nil
+}
+
+// moveCategory moves a category's settings to another key. The caller must
+// hold cm.mu for writing.
+func (cm *ConfigManager) moveCategory(from, to categoryKey) {
+	moveKey(cm.categories, from, to)
+	moveKey(cm.categoryDisplayNames, from, to)
+	moveKey(cm.categoryPrivate, from, to)
+	moveKey(cm.categoryTTL, from, to)
+	moveKey(cm.categoryNaming, from, to)
+}
+
+func renamePlaceholder(placeholder, folderName, newFolderName string) string {
+	if rest, ok := strings.CutPrefix(placeholder, folderName+"/"); ok {
+		return newFolderName + "/" + rest
+	}
+	return placeholder
+}
+
+// RedirectHost returns the FQDN a former host of a domain now redirects to.
+// Hosts that a domain serves are never redirected.
+func (cm *ConfigManager) RedirectHost(host string) (string, bool) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	host = NormalizeHost(host)
+	if _, served := cm.hosts.lookup(host); served {
+		return "", false
+	}
+	folder, ok := cm.hosts.redirect[host]
+	if !ok {
+		return "", false
+	}
+	return cm.domainFQDNs[folder], true
+}
+
+// CategoryRedirect returns the folder name a renamed category now has, if
+// its old name redirects.
+func (cm *ConfigManager) CategoryRedirect(domainFolder, category string) (string, bool) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	to, ok := cm.domainRedirects[domainFolder][category]
+	return to, ok
+}
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRenameCategory(t *testing.T) {
+	cm := newTestConfig(t)
+	if err := cm.SetCategoryTTL("main", "images", 7*day); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.SetCategoryPrivate("main", "images", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.SetHotlinkRule("main", "", HotlinkRule{Referers: []string{"example.com"}, Placeholder: "images/blocked.png"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.SetHotlinkRule("main", "images", HotlinkRule{Origins: []string{"app.example.com"}, Placeholder: "images/cors.png"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cm.RenameCategory("main", "images", "pictures", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cm.GetCategoryID("main", "images"); ok {
+		t.Error("old category still exists")
+	}
+	if ttl, ok := cm.GetCategoryTTL("main", "pictures"); !ok || ttl != 7*day {
+		t.Errorf("TTL = %v, %v", ttl, ok)
+	}
+	if !cm.IsCategoryPrivate("main", "pictures") {
+		t.Error("renamed category is no longer private")
+	}
+	policy, _ := cm.GetHotlinkPolicy("main")
+	if policy.Placeholder != "pictures/blocked.png" {
+		t.Errorf("domain placeholder = %q", policy.Placeholder)
+	}
+	if rule, ok := policy.Categories["pictures"]; !ok || rule.Placeholder != "pictures/cors.png" {
+		t.Errorf("category rule = %+v, %v", rule, ok)
+	}
+	if to, ok := cm.CategoryRedirect("main", "images"); !ok || to != "pictures" {
+		t.Errorf("redirect = %q, %v", to, ok)
+	}
+
+	// The category of the same name in another domain is its own
+	if _, ok := cm.GetCategoryID("blog", "images"); !ok {
+		t.Error("category of another domain renamed")
+	}
+	if _, ok := cm.CategoryRedirect("blog", "images"); ok {
+		t.Error("another domain redirects the old name")
+	}
+
+	// Redirects follow a second rename, and a name taken back stops
+	// redirecting
+	if err := cm.RenameCategory("main", "pictures", "images", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cm.CategoryRedirect("main", "images"); ok {
+		t.Error("a category redirects to itself")
+	}
+	if err := cm.RenameCategory("main", "images", "photos", true); err != nil {
+		t.Fatal(err)
+	}
+	if to, ok := cm.CategoryRedirect("main", "images"); !ok || to != "photos" {
+		t.Errorf("redirect = %q, %v", to, ok)
+	}
+
+	if err := cm.RenameCategory("main", "photos", "docs", false); err == nil {
+		t.Error("renamed onto an existing category")
+	}
+	if err := cm.RenameCategory("main", "photos", "a/b", false); err == nil {
+		t.Error("renamed to an invalid folder name")
+	}
+}
+
+func TestRenameDomain(t *testing.T) {
+	cm := newTestConfig(t)
+	if err := cm.SetCategoryTTL("main", "docs", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.AddDomainAlias("main", "static.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cm.RenameDomain("main", "cdn"); err != nil {
+		t.Fatal(err)
+	}
+	if cm.DomainExists("main") || !cm.DomainExists("cdn") {
+		t.Fatal("domain not renamed")
+	}
+	if got := cm.ListCategories("cdn"); !slices.Equal(got, []string{"docs", "images"}) {
+		t.Errorf("categories = %v", got)
+	}
+	if _, ok := cm.GetCategoryTTL("cdn", "docs"); !ok {
+		t.Error("category settings left behind")
+	}
+	for _, host := range []string{"cdn.example.com", "static.example.com"} {
+		if folder, _, ok := cm.GetDomainByFQDN(host); !ok || folder != "cdn" {
+			t.Errorf("%s resolves to %q, %v", host, folder, ok)
+		}
+	}
+	if err := cm.RenameDomain("cdn", "blog"); err == nil {
+		t.Error("renamed onto an existing domain")
+	}
+}
+
+func TestSetDomainFQDN(t *testing.T) {
+	cm := newTestConfig(t)
+	if _, err := cm.AddDomainAlias("main", "static.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cm.SetDomainFQDN("main", "https://static.example.com", true); err != nil {
+		t.Fatal(err)
+	}
+	if fqdn, _ := cm.GetDomainFQDN("main"); fqdn != "static.example.com" {
+		t.Errorf("FQDN = %q", fqdn)
+	}
+	if aliases := cm.ListDomainAliases("main"); len(aliases) != 0 {
+		t.Errorf("new FQDN still an alias: %v", aliases)
+	}
+	if to, ok := cm.RedirectHost("cdn.example.com"); !ok || to != "static.example.com" {
+		t.Errorf("old host redirects to %q, %v", to, ok)
+	}
+	if _, ok := cm.RedirectHost("static.example.com"); ok {
+		t.Error("a served host redirects")
+	}
+
+	// Moving back serves the old host again instead of redirecting it
+	if err := cm.SetDomainFQDN("main", "cdn.example.com", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cm.RedirectHost("cdn.example.com"); ok {
+		t.Error("the current host redirects")
+	}
+	if to, ok := cm.RedirectHost("static.example.com"); ok {
+		t.Errorf("redirect added without asking: %q", to)
+	}
+
+	if err := cm.SetDomainFQDN("main", "blog.example.com", false); err == nil {
+		t.Error("took the host of another domain")
+	}
+}
+
+func TestRenamedConfigIsSaved(t *testing.T) {
+	cm := newTestConfig(t)
+	if err := cm.RenameCategory("main", "images", "pictures", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.RenameDomain("blog", "journal"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	domains, categories := filepath.Join(dir, "domains.json"), filepath.Join(dir, "categories.json")
+	if err := cm.SaveDomains(domains); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.SaveCategories(categories); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewConfigManager()
+	if err := loaded.LoadDomains(domains); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.LoadCategories(categories); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.validate(); err != nil {
+		t.Fatalf("saved config does not validate: %v", err)
+	}
+	if _, ok := loaded.GetCategoryID("main", "pictures"); !ok {
+		t.Error("renamed category not saved")
+	}
+	if to, ok := loaded.CategoryRedirect("main", "images"); !ok || to != "pictures" {
+		t.Errorf("redirect not saved: %q, %v", to, ok)
+	}
+	if _, ok := loaded.GetCategoryID("journal", "images"); !ok {
+		t.Error("category of the renamed domain not saved")
+	}
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TTL bounds. The janitor checks for expired files every minute or so, so a
+// shorter TTL could not be honoured; ten years is long enough to mean "for a
+// long time" without overflowing expiry times.
+const (
+	MinTTL = time.Minute
+	MaxTTL = 3650 * 24 * time.Hour
+)
+
+// ParseTTL parses how long files are kept, such as "90m", "12h", "7d" or
+// "4w".
+func ParseTTL(s string) (time.Duration, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+
+	var ttl time.Duration
+	if n, unit, ok := cutDayUnit(s); ok {
+		count, err := strconv.Atoi(n)
+		if err != nil || count <= 0 || count > int(MaxTTL/unit) {
+			return 0, fmt.Errorf("invalid TTL '%s' (use e.g. 30m, 12h, 7d or 4w, at most %d days)", s, MaxTTL/(24*time.Hour))
+		}
+		ttl = time.Duration(count) * unit
+	} else {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return 0, fmt.Errorf("invalid TTL '%s' (use e.g. 30m, 12h, 7d or 4w)", s)
+		}
+		ttl = d
+	}
+
+	switch {
+	case ttl < MinTTL:
+		return 0, fmt.Errorf("TTL must be at least %s", FormatTTL(MinTTL))
+	case ttl > MaxTTL:
+		return 0, fmt.Errorf("TTL must be at most %s", FormatTTL(MaxTTL))
+	}
+	return ttl, nil
+}
+
+// cutDayUnit splits the day and week counts time.ParseDuration does not
+// know from their unit.
+func cutDayUnit(s string) (string, time.Duration, bool) {
+	if n, ok := strings.CutSuffix(s, "d"); ok {
+		return n, 24 * time.Hour, true
+	}
+	if n, ok := strings.CutSuffix(s, "w"); ok {
+		return n, 7 * 24 * time.Hour, true
+	}
+	return "", 0, false
+}
+
+// GetCategoryTTL returns how long files uploaded to a category are kept.
+// ok is false when they are kept forever.
+func (cm *ConfigManager) GetCategoryTTL(domainFolder, folderName string) (time.Duration, bool) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	ttl, ok := cm.categoryTTL[categoryKey{domainFolder, folderName}]
+	return ttl, ok
+}
+
+// SetCategoryTTL sets how long files uploaded to a category are kept.
+// A zero ttl keeps them forever. Files already stored keep their expiry.
+func (cm *ConfigManager) SetCategoryTTL(domainFolder, folderName string, ttl time.Duration) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	key := categoryKey{domainFolder, folderName}
+	if _, exists := cm.categories[key]; !exists {
+		return fmt.Errorf("category '%s' not found in domain '%s'", folderName, domainFolder)
+	}
+
+	if ttl > 0 {
+		cm.categoryTTL[key] = ttl
+	} else {
+		delete(cm.categoryTTL, key)
+	}
+	return nil
+}
+
+// FormatTTL formats a TTL the way ParseTTL reads it, using days
+// when the lifetime is a whole number of days. Zero formats as "".
+func FormatTTL(ttl time.Duration) string {
+	switch {
+	case ttl <= 0:
+		return ""
+	case ttl%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", ttl/(24*time.Hour))
+	case ttl%time.Hour == 0:
+		return fmt.Sprintf("%dh", ttl/time.Hour)
+	case ttl%time.Minute == 0:
+		return fmt.Sprintf("%dm", ttl/time.Minute)
+	}
+	return ttl.String()
+}
+
+// FileExpiry returns when a file uploaded to a category at now expires, or
+// nil if it never does. A non-empty override such as "12h" or "7d" replaces
+// the category's TTL.
+func (cm *ConfigManager) FileExpiry(domainFolder, category, override string, now time.Time) (*time.Time, error) {
+	ttl, ok := cm.GetCategoryTTL(domainFolder, category)
+	if override != "" {
+		var err error
+		if ttl, err = ParseTTL(override); err != nil {
+			return nil, err
+		}
+		ok = true
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	expires := now.Add(ttl)
+	return &expires, nil
+}
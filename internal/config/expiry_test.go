@@ -0,0 +1,80 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+func TestParseTTL(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"90m", 90 * time.Minute, true},
+		{"12h", 12 * time.Hour, true},
+		{"7d", 7 * day, true},
+		{"4w", 28 * day, true},
+		{" 30D ", 30 * day, true},
+		{"1m", MinTTL, true},
+		{"730d", 730 * day, true},
+		{"3650d", MaxTTL, true},
+		{"3651d", 0, false},
+		{"600w", 0, false},
+		{"30s", 0, false},
+		{"0d", 0, false},
+		{"-2h", 0, false},
+		{"1.5d", 0, false},
+		{"forever", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseTTL(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseTTL(%q) = %v, %v; want %v, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFormatTTLRoundTrips(t *testing.T) {
+	for _, ttl := range []time.Duration{MinTTL, 90 * time.Minute, 36 * time.Hour, 7 * day, MaxTTL} {
+		got, err := ParseTTL(FormatTTL(ttl))
+		if err != nil || got != ttl {
+			t.Errorf("ParseTTL(FormatTTL(%v)) = %v, %v", ttl, got, err)
+		}
+	}
+	if s := FormatTTL(0); s != "" {
+		t.Errorf("FormatTTL(0) = %q, want empty", s)
+	}
+}
+
+func TestFileExpiry(t *testing.T) {
+	cm := NewConfigManager()
+	if err := cm.AddDomain("main", "Main", "cdn.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []string{"temp", "keep"} {
+		if err := cm.AddCategory("main", c, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cm.SetCategoryTTL("main", "temp", 7*day); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if exp, err := cm.FileExpiry("main", "temp", "", now); err != nil || exp == nil || !exp.Equal(now.Add(7*day)) {
+		t.Errorf("category TTL: %v, %v", exp, err)
+	}
+	if exp, err := cm.FileExpiry("main", "keep", "", now); err != nil || exp != nil {
+		t.Errorf("no TTL: %v, %v", exp, err)
+	}
+	if exp, err := cm.FileExpiry("main", "keep", "2w", now); err != nil || exp == nil || !exp.Equal(now.Add(14*day)) {
+		t.Errorf("upload override: %v, %v", exp, err)
+	}
+	if _, err := cm.FileExpiry("main", "temp", "tomorrow", now); err == nil {
+		t.Error("an invalid override was accepted")
+	}
+}
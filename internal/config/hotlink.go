@@ -0,0 +1,207 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// HotlinkRule restricts which sites may embed or fetch files. An empty
+// Referers or Origins list leaves that header unchecked.
+type HotlinkRule struct {
+	// Referers are hostnames allowed in the Referer header. "*.example.com"
+	// matches any subdomain of example.com.
+	Referers []string `json:"referers,omitempty"`
+	// Origins are hostnames allowed in the Origin header and reflected in
+	// Access-Control-Allow-Origin.
+	Origins []string `json:"origins,omitempty"`
+	// AllowEmptyReferer lets through requests without a Referer, such as
+	// direct visits and privacy-conscious browsers.
+	AllowEmptyReferer bool `json:"allow-empty-referer,omitempty"`
+	// Placeholder is a "category/filename" in the same domain served instead
+	// of a 403 to blocked requests.
+	Placeholder string `json:"placeholder,omitempty"`
+}
+
+// HotlinkPolicy is a domain's default rule plus per-category overrides.
+type HotlinkPolicy struct {
+	HotlinkRule
+	Categories map[string]HotlinkRule `json:"categories,omitempty"`
+}
+
+// Active reports whether the rule restricts anything.
+func (r HotlinkRule) Active() bool {
+	return len(r.Referers) > 0 || len(r.Origins) > 0
+}
+
+// AllowsReferer checks a Referer header value. selfHost is the domain's own
+// FQDN, which is always allowed.
+func (r HotlinkRule) AllowsReferer(referer, selfHost string) bool {
+	if len(r.Referers) == 0 {
+		return true
+	}
+	if referer == "" {
+		return r.AllowEmptyReferer
+	}
+
+	host := headerHostname(referer)
+	if host == "" {
+		return false
+	}
+	if strings.EqualFold(host, stripPort(selfHost)) {
+		return true
+	}
+	return matchHostPatterns(r.Referers, host)
+}
+
+// AllowsOrigin checks an Origin header value. Requests without an Origin
+// header are not cross-origin and always pass.
+func (r HotlinkRule) AllowsOrigin(origin string) bool {
+	if len(r.Origins) == 0 || origin == "" {
+		return true
+	}
+	host := headerHostname(origin)
+	return host != "" && matchHostPatterns(r.Origins, host)
+}
+
+func headerHostname(value string) string {
+	u, err := url.Parse(value)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+func stripPort(host string) string {
+	if h, _, found := strings.Cut(host, ":"); found {
+		return h
+	}
+	return host
+}
+
+func matchHostPatterns(patterns []string, host string) bool {
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if suffix, ok := strings.CutPrefix(p, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if p == host {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeHostPatterns turns a comma-separated list of hosts, origins or
+// URLs into lowercase host patterns.
+func NormalizeHostPatterns(list string) []string {
+	var patterns []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.ToLower(stripProtocol(strings.TrimSpace(item)))
+		item, _, _ = strings.Cut(item, "/")
+		item = stripPort(item)
+		if item != "" {
+			patterns = append(patterns, item)
+		}
+	}
+	return patterns
+}
+
+// GetHotlinkRule returns the rule that applies to a category of a domain:
+// the category override if there is one, otherwise the domain default.
+func (cm *ConfigManager) GetHotlinkRule(domainFolder, category string) (HotlinkRule, bool) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	policy, ok := cm.domainHotlink[domainFolder]
+	if !ok {
+		return HotlinkRule{}, false
+	}
+	if rule, ok := policy.Categories[category]; ok {
+		return rule, rule.Active()
+	}
+	return policy.HotlinkRule, policy.HotlinkRule.Active()
+}
+
+// GetHotlinkPolicy returns a copy of a domain's full hotlink policy.
+func (cm *ConfigManager) GetHotlinkPolicy(domainFolder string) (HotlinkPolicy, bool) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	policy, ok := cm.domainHotlink[domainFolder]
+	if !ok {
+		return HotlinkPolicy{}, false
+	}
+	return copyHotlinkPolicy(policy), true
+}
+
+// SetHotlinkRule sets the domain default rule, or the override for category
+// when it is not empty.
+func (cm *ConfigManager) SetHotlinkRule(domainFolder, category string, rule HotlinkRule) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if _, exists := cm.domains[domainFolder]; !exists {
+		return fmt.Errorf("domain '%s' not found", domainFolder)
+	}
+
+	policy, ok := cm.domainHotlink[domainFolder]
+	if !ok {
+		policy = &HotlinkPolicy{}
+		cm.domainHotlink[domainFolder] = policy
+	}
+
+	if category == "" {
+		policy.HotlinkRule = rule
+		return nil
+	}
+
+	if _, exists := cm.categories[categoryKey{domainFolder, category}]; !exists {
+		return fmt.Errorf("category '%s' not found in domain '%s'", category, domainFolder)
+	}
+	if policy.Categories == nil {
+		policy.Categories = make(map[string]HotlinkRule)
+	}
+	policy.Categories[category] = rule
+	return nil
+}
+
+// ClearHotlinkRule removes the domain default rule, or the override for
+// category when it is not empty.
+func (cm *ConfigManager) ClearHotlinkRule(domainFolder, category string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	policy, ok := cm.domainHotlink[domainFolder]
+	if !ok {
+		return fmt.Errorf("no hotlink protection configured for domain '%s'", domainFolder)
+	}
+
+	if category == "" {
+		policy.HotlinkRule = HotlinkRule{}
+	} else {
+		if _, ok := policy.Categories[category]; !ok {
+			return fmt.Errorf("no hotlink protection configured for category '%s'", category)
+		}
+		delete(policy.Categories, category)
+	}
+
+	if !policy.HotlinkRule.Active() && len(policy.Categories) == 0 {
+		delete(cm.domainHotlink, domainFolder)
+	}
+	return nil
+}
+
+func copyHotlinkPolicy(p *HotlinkPolicy) HotlinkPolicy {
+	c := HotlinkPolicy{HotlinkRule: p.HotlinkRule}
+	if len(p.Categories) > 0 {
+		c.Categories = make(map[string]HotlinkRule, len(p.Categories))
+		for k, v := range p.Categories {
+			c.Categories[k] = v
+		}
+	}
+	return c
+}
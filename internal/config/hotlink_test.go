@@ -0,0 +1,79 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMatchHostPatterns(t *testing.T) {
+	patterns := []string{"example.com", "*.Partner.org", "*.eu.shop.net"}
+	tests := map[string]bool{
+		"example.com":          true,
+		"www.example.com":      false,
+		"img.partner.org":      true,
+		"a.b.partner.org":      true,
+		"partner.org":          false,
+		"evilpartner.org":      false,
+		"partner.org.evil.com": false,
+		"de.eu.shop.net":       true,
+		"shop.net":             false,
+		"eu.shop.net":          false,
+		"":                     false,
+	}
+	for host, want := range tests {
+		if got := matchHostPatterns(patterns, host); got != want {
+			t.Errorf("matchHostPatterns(%q) = %v, want %v", host, got, want)
+		}
+	}
+	if matchHostPatterns(nil, "example.com") {
+		t.Error("no patterns match a host")
+	}
+}
+
+func TestHotlinkRuleAllows(t *testing.T) {
+	rule := HotlinkRule{Referers: []string{"*.example.com"}, Origins: []string{"app.example.com"}}
+
+	referers := map[string]bool{
+		"https://blog.example.com/post":  true,
+		"https://cdn.example.org:8443/x": true, // the domain's own host
+		"https://CDN.example.org/":       true,
+		"https://example.com/":           false,
+		"https://evil.com/?example.com":  false,
+		"not a url\x7f":                  false,
+		"":                               false,
+	}
+	for referer, want := range referers {
+		if got := rule.AllowsReferer(referer, "cdn.example.org:8443"); got != want {
+			t.Errorf("AllowsReferer(%q) = %v, want %v", referer, got, want)
+		}
+	}
+	rule.AllowEmptyReferer = true
+	if !rule.AllowsReferer("", "cdn.example.org") {
+		t.Error("empty Referer refused although allowed")
+	}
+
+	origins := map[string]bool{
+		"https://app.example.com":      true,
+		"https://APP.example.com:3000": true,
+		"https://www.example.com":      false,
+		"null":                         false,
+		"":                             true, // not a cross-origin request
+	}
+	for origin, want := range origins {
+		if got := rule.AllowsOrigin(origin); got != want {
+			t.Errorf("AllowsOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+
+	if open := (HotlinkRule{}); !open.AllowsReferer("https://evil.com/", "cdn.example.org") || !open.AllowsOrigin("https://evil.com") {
+		t.Error("a rule without lists blocks requests")
+	}
+}
+
+func TestNormalizeHostPatterns(t *testing.T) {
+	got := NormalizeHostPatterns(" https://Blog.Example.com/posts, *.Partner.org ,app.example.com:3000,, ")
+	want := []string{"blog.example.com", "*.partner.org", "app.example.com"}
+	if !slices.Equal(got, want) {
+		t.Errorf("NormalizeHostPatterns = %q, want %q", got, want)
+	}
+}
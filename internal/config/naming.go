@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/vixa/cdn/internal/storage"
+)
+
+// GetCategoryNaming returns how new files in a category are named. Categories
+// without a strategy use UUIDs.
+func (cm *ConfigManager) GetCategoryNaming(domainFolder, folderName string) storage.Naming {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.categoryNaming[categoryKey{domainFolder, folderName}]
+}
+
+// SetCategoryNaming sets how new files in a category are named. Files
+// already stored keep their names.
+func (cm *ConfigManager) SetCategoryNaming(domainFolder, folderName string, naming storage.Naming) error {
+	if err := naming.Validate(); err != nil {
+		return err
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	key := categoryKey{domainFolder, folderName}
+	if _, exists := cm.categories[key]; !exists {
+		return fmt.Errorf("category '%s' not found in domain '%s'", folderName, domainFolder)
+	}
+
+	if naming.Strategy == "" || naming.Strategy == storage.NamingUUID {
+		delete(cm.categoryNaming, key)
+	} else {
+		cm.categoryNaming[key] = naming
+	}
+	return nil
+}
+
+// formatNaming formats a strategy for categories.json, leaving the default
+// out.
+func formatNaming(naming storage.Naming) string {
+	if naming.Strategy == "" || naming.Strategy == storage.NamingUUID {
+		return ""
+	}
+	return naming.String()
+}
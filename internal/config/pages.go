@@ -0,0 +1,78 @@
+package config
+
+import "fmt"
+
+// Kinds of custom pages a domain can serve.
+const (
+	PageLanding   = "landing"
+	PageNotFound  = "404"
+	PageForbidden = "403"
+	PageGone      = "410"
+)
+
+// PageKinds lists every custom page kind.
+var PageKinds = []string{PageLanding, PageNotFound, PageForbidden, PageGone}
+
+// IsPageKind reports whether kind is one of PageKinds.
+func IsPageKind(kind string) bool {
+	for _, k := range PageKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// GetDomainPage returns the file name of a domain's custom page.
+func (cm *ConfigManager) GetDomainPage(domainFolder, kind string) (string, bool) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	name, ok := cm.domainPages[domainFolder][kind]
+	return name, ok
+}
+
+// ListDomainPages returns a copy of a domain's configured pages.
+func (cm *ConfigManager) ListDomainPages(domainFolder string) map[string]string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	pages := make(map[string]string, len(cm.domainPages[domainFolder]))
+	for kind, name := range cm.domainPages[domainFolder] {
+		pages[kind] = name
+	}
+	return pages
+}
+
+func (cm *ConfigManager) SetDomainPage(domainFolder, kind, name string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if _, exists := cm.domains[domainFolder]; !exists {
+		return fmt.Errorf("domain '%s' not found", domainFolder)
+	}
+	if !IsPageKind(kind) {
+		return fmt.Errorf("unknown page '%s'", kind)
+	}
+
+	if cm.domainPages[domainFolder] == nil {
+		cm.domainPages[domainFolder] = make(map[string]string)
+	}
+	cm.domainPages[domainFolder][kind] = name
+	return nil
+}
+
+func (cm *ConfigManager) RemoveDomainPage(domainFolder, kind string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if _, exists := cm.domainPages[domainFolder][kind]; !exists {
+		return fmt.Errorf("no %s page configured for domain '%s'", kind, domainFolder)
+	}
+
+	delete(cm.domainPages[domainFolder], kind)
+	if len(cm.domainPages[domainFolder]) == 0 {
+		delete(cm.domainPages, domainFolder)
+	}
+	return nil
+}
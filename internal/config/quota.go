@@ -0,0 +1,199 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Quota scopes: a domain folder, a category of a domain, a Discord user, or
+// the default that applies to every user without a quota of their own.
+const (
+	QuotaDomain      = "domain"
+	QuotaCategory    = "category"
+	QuotaUser        = "user"
+	QuotaDefaultUser = "every-user"
+)
+
+// Quota limits the space used by a domain, category or uploader. Uploads
+// that would go past HardBytes or MaxFiles are rejected; going past
+// SoftBytes only warns. Zero means no limit.
+type Quota struct {
+	SoftBytes int64 `json:"soft_bytes,omitempty"`
+	HardBytes int64 `json:"hard_bytes,omitempty"`
+	MaxFiles  int64 `json:"max_files,omitempty"`
+}
+
+// IsZero reports whether the quota sets no limit at all.
+func (q Quota) IsZero() bool {
+	return q == Quota{}
+}
+
+// Quotas holds every configured quota, keyed by domain folder name,
+// CategoryScope or user ID.
+type Quotas struct {
+	Domains     map[string]Quota `json:"domains,omitempty"`
+	Categories  map[string]Quota `json:"categories,omitempty"`
+	Users       map[string]Quota `json:"users,omitempty"`
+	DefaultUser *Quota           `json:"default_user,omitempty"`
+}
+
+// CategoryScope names a category of a domain in category quotas and API key
+// scopes, as "domain/category". Categories of different domains with the
+// same folder name are separate.
+func CategoryScope(domainFolder, category string) string {
+	return domainFolder + "/" + category
+}
+
+// GetQuota returns the quota of a domain, category or user; key is a
+// CategoryScope for categories. Users without a quota of their own get the
+// default user quota.
+func (sm *SettingsManager) GetQuota(scope, key string) (Quota, bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	q := sm.settings.Quotas
+	switch scope {
+	case QuotaDomain:
+		quota, ok := q.Domains[key]
+		return quota, ok
+	case QuotaCategory:
+		quota, ok := q.Categories[key]
+		return quota, ok
+	case QuotaUser:
+		if quota, ok := q.Users[key]; ok {
+			return quota, true
+		}
+		if q.DefaultUser != nil {
+			return *q.DefaultUser, true
+		}
+	case QuotaDefaultUser:
+		if q.DefaultUser != nil {
+			return *q.DefaultUser, true
+		}
+	}
+	return Quota{}, false
+}
+
+// SetQuota sets the quota of a domain, category or user, keyed as for
+// GetQuota. A zero quota removes it.
+func (sm *SettingsManager) SetQuota(scope, key string, quota Quota) error {
+	sm.mu.Lock()
+	q := &sm.settings.Quotas
+	var target *map[string]Quota
+	switch scope {
+	case QuotaDomain:
+		target = &q.Domains
+	case QuotaCategory:
+		target = &q.Categories
+	case QuotaUser:
+		target = &q.Users
+	case QuotaDefaultUser:
+		if quota.IsZero() {
+			q.DefaultUser = nil
+		} else {
+			q.DefaultUser = &quota
+		}
+	default:
+		sm.mu.Unlock()
+		return fmt.Errorf("unknown quota scope '%s'", scope)
+	}
+
+	if target != nil {
+		if quota.IsZero() {
+			delete(*target, key)
+		} else {
+			if *target == nil {
+				*target = make(map[string]Quota)
+			}
+			(*target)[key] = quota
+		}
+	}
+	sm.mu.Unlock()
+
+	return sm.save()
+}
+
+// MigrateCategoryQuotas moves category quotas keyed by folder name alone, as
+// older versions kept them for the folder across all domains, to each domain
+// that has a category of that name, and saves them. Quotas of a name no
+// domain has yet are left as they are. It reports whether any quota was
+// moved. cm must be fully loaded.
+func (sm *SettingsManager) MigrateCategoryQuotas(cm *ConfigManager) (bool, error) {
+	sm.mu.Lock()
+	migrated := migrateCategoryQuotas(&sm.settings.Quotas, cm)
+	sm.mu.Unlock()
+
+	if !migrated {
+		return false, nil
+	}
+	return true, sm.save()
+}
+
+// migrateCategoryQuotas rewrites the unscoped category quotas of q, see
+// MigrateCategoryQuotas. A quota set for a domain's category already wins
+// over the old one.
+func migrateCategoryQuotas(q *Quotas, cm *ConfigManager) bool {
+	migrated := false
+	for key, quota := range q.Categories {
+		if strings.Contains(key, "/") {
+			continue
+		}
+		domains := cm.domainsWithCategory(key)
+		if len(domains) == 0 {
+			// Kept for when a domain gets the category
+			continue
+		}
+		for _, domainFolder := range domains {
+			if _, exists := q.Categories[CategoryScope(domainFolder, key)]; !exists {
+				q.Categories[CategoryScope(domainFolder, key)] = quota
+			}
+		}
+		delete(q.Categories, key)
+		migrated = true
+	}
+	return migrated
+}
+
+// ParseSize parses sizes like "500MB", "2.5GB" or "1024". Units are powers
+// of 1024, so "1GB" and "1GiB" are the same size.
+func ParseSize(input string) (int64, error) {
+	s := strings.TrimSpace(strings.ToUpper(input))
+	s = strings.TrimSuffix(s, "IB")
+	s = strings.TrimSuffix(s, "B")
+
+	multiplier := int64(1)
+	if n := len(s); n > 0 {
+		if i := strings.IndexByte("KMGT", s[n-1]); i >= 0 {
+			multiplier = int64(1) << (10 * (i + 1))
+			s = strings.TrimSpace(s[:n-1])
+		}
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 || math.IsInf(value, 0) || math.IsNaN(value) {
+		return 0, fmt.Errorf("invalid size '%s', use e.g. 500MB or 2GB", input)
+	}
+	// Multiplying by a power of two is exact, and the float64 limit is 2^63,
+	// the first size an int64 can't hold
+	size := value * float64(multiplier)
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("size '%s' is too large", input)
+	}
+	return int64(size), nil
+}
+
+// FormatSize formats a byte count with a binary unit, e.g. "1.5 GiB".
+func FormatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,228 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// newTestConfig returns a config with domains main and blog, both with an
+// images category, and a docs category in main only.
+func newTestConfig(t *testing.T) *ConfigManager {
+	t.Helper()
+	cm := NewConfigManager()
+	for _, d := range []struct{ folder, fqdn string }{{"main", "cdn.example.com"}, {"blog", "blog.example.com"}} {
+		if err := cm.AddDomain(d.folder, d.folder, d.fqdn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []struct{ domain, folder string }{{"main", "images"}, {"blog", "images"}, {"main", "docs"}} {
+		if err := cm.AddCategory(c.domain, c.folder, c.folder); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cm
+}
+
+func newTestSettings(t *testing.T, content string) *SettingsManager {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "settings.json")
+	if content != "" {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sm, err := NewSettingsManager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sm
+}
+
+func TestCategoryQuotasArePerDomain(t *testing.T) {
+	sm := newTestSettings(t, "")
+	if err := sm.SetQuota(QuotaCategory, CategoryScope("main", "images"), Quota{HardBytes: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if q, ok := sm.GetQuota(QuotaCategory, CategoryScope("main", "images")); !ok || q.HardBytes != 100 {
+		t.Errorf("main/images quota = %+v, %v", q, ok)
+	}
+	if q, ok := sm.GetQuota(QuotaCategory, CategoryScope("blog", "images")); ok {
+		t.Errorf("blog/images got the quota of main/images: %+v", q)
+	}
+
+	// Zero removes it
+	if err := sm.SetQuota(QuotaCategory, CategoryScope("main", "images"), Quota{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sm.GetQuota(QuotaCategory, CategoryScope("main", "images")); ok {
+		t.Error("quota still set after removing it")
+	}
+}
+
+func TestMigrateCategoryQuotas(t *testing.T) {
+	cm := newTestConfig(t)
+	sm := newTestSettings(t, `{"quotas": {"categories": {
+		"images": {"hard_bytes": 100},
+		"docs": {"hard_bytes": 200},
+		"videos": {"hard_bytes": 300},
+		"blog/images": {"hard_bytes": 50}
+	}}}`)
+
+	migrated, err := sm.MigrateCategoryQuotas(cm)
+	if err != nil || !migrated {
+		t.Fatalf("MigrateCategoryQuotas = %v, %v", migrated, err)
+	}
+	want := map[string]int64{
+		"main/images": 100,
+		"blog/images": 50, // already set per domain
+		"main/docs":   200,
+		"videos":      300, // no domain has it yet
+	}
+	if len(sm.settings.Quotas.Categories) != len(want) {
+		t.Errorf("quotas = %v, want %v", sm.settings.Quotas.Categories, want)
+	}
+	for key, hard := range want {
+		if q := sm.settings.Quotas.Categories[key]; q.HardBytes != hard {
+			t.Errorf("quota of %s = %d, want %d", key, q.HardBytes, hard)
+		}
+	}
+
+	// Saved, and nothing left to do the next time
+	reloaded := newTestSettings(t, "")
+	reloaded.settingsPath = sm.settingsPath
+	if err := reloaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	if q, _ := reloaded.GetQuota(QuotaCategory, "main/docs"); q.HardBytes != 200 {
+		t.Errorf("migrated quota not saved: %+v", q)
+	}
+	if migrated, err := reloaded.MigrateCategoryQuotas(cm); err != nil || migrated {
+		t.Errorf("second MigrateCategoryQuotas = %v, %v", migrated, err)
+	}
+}
+
+func TestRenameMovesCategoryQuotas(t *testing.T) {
+	sm := newTestSettings(t, "")
+	for key, hard := range map[string]int64{"main/images": 100, "blog/images": 200} {
+		if err := sm.SetQuota(QuotaCategory, key, Quota{HardBytes: hard}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := sm.RenameCategory("main", "images", "pictures"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.RenameDomain("blog", "journal"); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{"main/pictures": 100, "journal/images": 200}
+	if len(sm.settings.Quotas.Categories) != len(want) {
+		t.Errorf("quotas = %v, want %v", sm.settings.Quotas.Categories, want)
+	}
+	for key, hard := range want {
+		if q, _ := sm.GetQuota(QuotaCategory, key); q.HardBytes != hard {
+			t.Errorf("quota of %s = %d, want %d", key, q.HardBytes, hard)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"1024":                1024,
+		"500MB":               500 << 20,
+		"2.5 gb":              5 << 29,
+		"1GiB":                1 << 30,
+		"10K":                 10 << 10,
+		"0":                   0,
+		"1.5TB":               3 << 39,
+		" 64 kb ":             64 << 10,
+		"8388607TB":           8388607 << 40,
+		"9223372036854774784": 9223372036854774784,
+	}
+	for in, want := range tests {
+		if got, err := ParseSize(in); err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "GB", "-1MB", "lots", "1PB", "NaN", "InfGB", "1e400", "99999999999TB", "8388608TB", "9223372036854775807"} {
+		if got, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q) = %d, want an error", in, got)
+		}
+	}
+}
+
+func TestAPIKeyCategoryScopes(t *testing.T) {
+	key := APIKey{Domains: []string{"main", "blog"}, Categories: []string{"main/images"}}
+	if !key.Allows("main", "images") {
+		t.Error("key not allowed in its category")
+	}
+	if key.Allows("blog", "images") {
+		t.Error("key allowed in the category of the same name in another domain")
+	}
+	if any := (APIKey{Domains: []string{"main"}, Categories: []string{"*"}}); !any.Allows("main", "docs") || any.Allows("blog", "images") {
+		t.Error("wildcard categories do not follow the domains")
+	}
+}
+
+func newTestAPIKeys(t *testing.T, content string) *APIKeyManager {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "api-keys.json")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	km, err := NewAPIKeyManager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return km
+}
+
+func TestMigrateCategoryScopes(t *testing.T) {
+	cm := newTestConfig(t)
+	km := newTestAPIKeys(t, `[
+		{"name": "ci", "token": "t1", "domains": ["main"], "categories": ["images", "docs", "videos"]},
+		{"name": "all", "token": "t2", "domains": ["*"], "categories": ["images", "blog/images"]},
+		{"name": "new", "token": "t3", "domains": ["blog"], "categories": ["*"]}
+	]`)
+
+	migrated, err := km.MigrateCategoryScopes(cm)
+	if err != nil || !migrated {
+		t.Fatalf("MigrateCategoryScopes = %v, %v", migrated, err)
+	}
+	want := map[string][]string{
+		"t1": {"main/images", "main/docs", "videos"},
+		"t2": {"blog/images", "main/images"},
+		"t3": {"*"},
+	}
+	for token, categories := range want {
+		key, _ := km.Authenticate(token)
+		if !slices.Equal(key.Categories, categories) {
+			t.Errorf("categories of %s = %v, want %v", key.Name, key.Categories, categories)
+		}
+	}
+	if migrated, err := km.MigrateCategoryScopes(cm); err != nil || migrated {
+		t.Errorf("second MigrateCategoryScopes = %v, %v", migrated, err)
+	}
+}
+
+func TestRenameUpdatesAPIKeyScopes(t *testing.T) {
+	km := newTestAPIKeys(t, `[
+		{"name": "ci", "token": "t1", "domains": ["main", "blog"], "categories": ["main/images", "blog/images"]}
+	]`)
+
+	if err := km.RenameCategory("main", "images", "pictures"); err != nil {
+		t.Fatal(err)
+	}
+	if err := km.RenameDomain("blog", "journal"); err != nil {
+		t.Fatal(err)
+	}
+	key, _ := km.Authenticate("t1")
+	if want := []string{"main", "journal"}; !slices.Equal(key.Domains, want) {
+		t.Errorf("domains = %v, want %v", key.Domains, want)
+	}
+	if want := []string{"main/pictures", "journal/images"}; !slices.Equal(key.Categories, want) {
+		t.Errorf("categories = %v, want %v", key.Categories, want)
+	}
+}
@@ -0,0 +1,265 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reloader reloads domains.json, categories.json and settings.json while
+// the server runs. A reload reads and checks all three files before
+// applying any of them, so a broken file leaves the running config as it
+// was. Files saved by the ConfigManager and SettingsManager it watches are
+// not reloaded, as they hold what is already running.
+type Reloader struct {
+	cm             *ConfigManager
+	sm             *SettingsManager
+	domainsPath    string
+	categoriesPath string
+
+	mu         sync.Mutex
+	seen       map[string]fileState
+	lastReload time.Time
+}
+
+// fileState is what polling compares to notice that a file has changed.
+type fileState struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+func statFile(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{modTime: info.ModTime(), size: info.Size(), exists: true}
+}
+
+func NewReloader(cm *ConfigManager, sm *SettingsManager, domainsPath, categoriesPath string) *Reloader {
+	r := &Reloader{
+		cm:             cm,
+		sm:             sm,
+		domainsPath:    domainsPath,
+		categoriesPath: categoriesPath,
+	}
+	r.seen = r.fileStates()
+
+	cm.mu.Lock()
+	cm.reloader = r
+	cm.mu.Unlock()
+	sm.mu.Lock()
+	sm.reloader = r
+	sm.mu.Unlock()
+	return r
+}
+
+// saving runs write, which saves the running config to some of the watched
+// files, so that no reload reads the files halfway through, and then marks
+// the files as seen. A nil Reloader just runs write.
+func (r *Reloader) saving(write func() error, paths ...string) error {
+	if r == nil {
+		return write()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := write()
+	for _, path := range paths {
+		r.seen[path] = statFile(path)
+	}
+	return err
+}
+
+func (r *Reloader) paths() []string {
+	return []string{r.domainsPath, r.categoriesPath, r.sm.settingsPath}
+}
+
+func (r *Reloader) fileStates() map[string]fileState {
+	states := make(map[string]fileState)
+	for _, path := range r.paths() {
+		states[path] = statFile(path)
+	}
+	return states
+}
+
+// Reload reads the config files and, if they are all valid, replaces the
+// running config with them. On error nothing is changed.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Stat before reading so a write that lands while reading is picked up
+	// by the next poll
+	r.seen = r.fileStates()
+
+	next := NewConfigManager()
+	if err := next.LoadDomains(r.domainsPath); err != nil {
+		return err
+	}
+	if err := next.LoadCategories(r.categoriesPath); err != nil {
+		return err
+	}
+	if err := next.validate(); err != nil {
+		return err
+	}
+	settings, err := readSettings(r.sm.settingsPath)
+	if err != nil {
+		return fmt.Errorf("failed to load settings: %w", err)
+	}
+	if err := validateSettings(settings); err != nil {
+		return err
+	}
+	// A hand-edited file may still name categories without their domain
+	migrateCategoryQuotas(&settings.Quotas, next)
+
+	r.cm.replaceWith(next)
+	r.sm.replace(settings)
+	r.lastReload = time.Now()
+
+	fmt.Printf("[Config] Reloaded %d domain(s), %d category(s) and %d channel config(s)\n", len(next.domains), len(next.categories), len(settings.ChannelConfigs))
+	return nil
+}
+
+// LastReload returns when the config was last reloaded successfully, or the
+// zero time if it has not been.
+func (r *Reloader) LastReload() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastReload
+}
+
+// Watch checks the config files for changes every interval and reloads
+// them when one has changed. A change that fails to load is reported once
+// and not retried until the files change again. The returned function stops
+// watching.
+func (r *Reloader) Watch(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var once sync.Once
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if changed := r.changedFiles(); len(changed) > 0 {
+					fmt.Printf("[Config] Changed on disk: %s\n", strings.Join(changed, ", "))
+					if err := r.Reload(); err != nil {
+						fmt.Printf("[Config] Reload failed, keeping the running config: %v\n", err)
+					}
+				}
+			}
+		}
+	}()
+
+	return func() { once.Do(func() { close(done) }) }
+}
+
+func (r *Reloader) changedFiles() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var changed []string
+	for _, path := range r.paths() {
+		if statFile(path) != r.seen[path] {
+			changed = append(changed, path)
+		}
+	}
+	return changed
+}
+
+// validate checks a freshly loaded config for what the bot would refuse to
+// create: unusable folder names, missing or conflicting hosts, unknown page
+// kinds and categories of unknown domains.
+func (cm *ConfigManager) validate() error {
+	hosts := make(map[string]string)
+	claim := func(host, folder string) error {
+		if owner, taken := hosts[host]; taken && owner != folder {
+			return fmt.Errorf("host '%s' is used by domains '%s' and '%s'", host, owner, folder)
+		}
+		hosts[host] = folder
+		return nil
+	}
+
+	for folder := range cm.domains {
+		if !validFolderName(folder) {
+			return fmt.Errorf("invalid domain folder-name '%s'", folder)
+		}
+		fqdn := NormalizeHost(cm.domainFQDNs[folder])
+		if fqdn == "" {
+			return fmt.Errorf("domain '%s' has no domain-fqdn", folder)
+		}
+		if err := claim(fqdn, folder); err != nil {
+			return err
+		}
+		for _, alias := range cm.domainAliases[folder] {
+			alias = NormalizeHost(alias)
+			if !validAlias(alias) {
+				return fmt.Errorf("domain '%s' has an invalid alias '%s'", folder, alias)
+			}
+			if err := claim(alias, folder); err != nil {
+				return err
+			}
+		}
+		for _, host := range cm.domainRedirectHosts[folder] {
+			if !validAlias(NormalizeHost(host)) || strings.HasPrefix(host, "*.") {
+				return fmt.Errorf("domain '%s' has an invalid redirect host '%s'", folder, host)
+			}
+		}
+		for from, to := range cm.domainRedirects[folder] {
+			if !validFolderName(from) || !validFolderName(to) {
+				return fmt.Errorf("domain '%s' has an invalid redirect from '%s' to '%s'", folder, from, to)
+			}
+		}
+		for kind := range cm.domainPages[folder] {
+			if !IsPageKind(kind) {
+				return fmt.Errorf("domain '%s' has an unknown page kind '%s'", folder, kind)
+			}
+		}
+	}
+
+	for key := range cm.categories {
+		if _, exists := cm.domains[key.domain]; !exists {
+			return fmt.Errorf("category '%s' belongs to unknown domain '%s'", key.folder, key.domain)
+		}
+		if !validFolderName(key.folder) {
+			return fmt.Errorf("invalid category folder-name '%s' in domain '%s'", key.folder, key.domain)
+		}
+	}
+	return nil
+}
+
+func validFolderName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, `/\`)
+}
+
+// replaceWith swaps in the domains and categories of a freshly loaded
+// config, which must not be used afterwards.
+func (cm *ConfigManager) replaceWith(next *ConfigManager) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	cm.domains = next.domains
+	cm.domainDisplayNames = next.domainDisplayNames
+	cm.domainFQDNs = next.domainFQDNs
+	cm.domainAliases = next.domainAliases
+	cm.hosts = next.hosts
+	cm.domainHotlink = next.domainHotlink
+	cm.domainPages = next.domainPages
+	cm.domainRedirects = next.domainRedirects
+	cm.domainRedirectHosts = next.domainRedirectHosts
+	cm.categories = next.categories
+	cm.categoryDisplayNames = next.categoryDisplayNames
+	cm.categoryPrivate = next.categoryPrivate
+	cm.categoryTTL = next.categoryTTL
+	cm.categoryNaming = next.categoryNaming
+	cm.unassignedCategories = next.unassignedCategories
+	cm.categoriesMigrated = next.categoriesMigrated
+}
@@ -0,0 +1,225 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type reloadFiles struct {
+	domains, categories, settings string
+}
+
+func (f reloadFiles) write(t *testing.T, domains, categories, settings string) {
+	t.Helper()
+	for path, content := range map[string]string{f.domains: domains, f.categories: categories, f.settings: settings} {
+		if content == "" {
+			continue
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+const (
+	reloadDomains = `[
+		{"folder-name": "main", "display-name": "Main", "domain-fqdn": "cdn.example.com"},
+		{"folder-name": "blog", "display-name": "Blog", "domain-fqdn": "blog.example.com"}
+	]`
+	reloadCategories = `[
+		{"domain": "main", "folder-name": "images", "display-name": "Images"},
+		{"domain": "blog", "folder-name": "posts", "display-name": "Posts"}
+	]`
+	reloadSettings = `{"channel_configs": {"42": {"domain": "main", "category": "images"}}}`
+)
+
+// newTestReloader loads the config files written by the reload tests the
+// way the server does at startup.
+func newTestReloader(t *testing.T) (*Reloader, *ConfigManager, *SettingsManager, reloadFiles) {
+	t.Helper()
+	dir := t.TempDir()
+	files := reloadFiles{
+		domains:    filepath.Join(dir, "domains.json"),
+		categories: filepath.Join(dir, "categories.json"),
+		settings:   filepath.Join(dir, "settings.json"),
+	}
+	files.write(t, reloadDomains, reloadCategories, reloadSettings)
+
+	cm := NewConfigManager()
+	if err := cm.LoadDomains(files.domains); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.LoadCategories(files.categories); err != nil {
+		t.Fatal(err)
+	}
+	sm, err := NewSettingsManager(files.settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewReloader(cm, sm, files.domains, files.categories), cm, sm, files
+}
+
+func TestReloadAppliesValidChanges(t *testing.T) {
+	r, cm, sm, files := newTestReloader(t)
+
+	files.write(t, `[
+		{"folder-name": "main", "display-name": "Main CDN", "domain-fqdn": "cdn.example.com", "aliases": ["static.example.com"]}
+	]`, `[
+		{"domain": "main", "folder-name": "images", "display-name": "Images"},
+		{"domain": "main", "folder-name": "docs", "display-name": "Docs", "ttl": "30d"}
+	]`, `{
+		"channel_configs": {"42": {"domain": "main", "category": "docs"}},
+		"quotas": {"categories": {"docs": {"hard_bytes": 100}}}
+	}`)
+	if changed := r.changedFiles(); len(changed) != 3 {
+		t.Errorf("changed files = %v, want all three", changed)
+	}
+
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if r.LastReload().IsZero() {
+		t.Error("LastReload not set")
+	}
+	if changed := r.changedFiles(); len(changed) != 0 {
+		t.Errorf("files still changed after a reload: %v", changed)
+	}
+
+	if name, _ := cm.GetDomainName("main"); name != "Main CDN" {
+		t.Errorf("display name = %q", name)
+	}
+	if cm.DomainExists("blog") {
+		t.Error("removed domain still exists")
+	}
+	if folder, _, ok := cm.GetDomainByFQDN("static.example.com"); !ok || folder != "main" {
+		t.Errorf("new alias resolves to %q, %v", folder, ok)
+	}
+	if _, ok := cm.GetCategoryTTL("main", "docs"); !ok {
+		t.Error("new category has no TTL")
+	}
+	if cc, _ := sm.GetChannelConfig("42"); cc.Category != "docs" {
+		t.Errorf("channel config = %+v", cc)
+	}
+	// Quotas named the old way are scoped to the domains with the category
+	if _, ok := sm.GetQuota(QuotaCategory, CategoryScope("main", "docs")); !ok {
+		t.Error("reloaded category quota not scoped to its domain")
+	}
+}
+
+func TestReloadKeepsRunningConfigOnError(t *testing.T) {
+	tests := []struct {
+		name                          string
+		domains, categories, settings string
+		wantErr                       string
+	}{
+		{
+			name: "host conflict",
+			domains: `[
+				{"folder-name": "main", "display-name": "Main", "domain-fqdn": "cdn.example.com"},
+				{"folder-name": "blog", "display-name": "Blog", "domain-fqdn": "blog.example.com", "aliases": ["CDN.example.com"]}
+			]`,
+			wantErr: "cdn.example.com",
+		},
+		{
+			name:       "category of an unknown domain",
+			categories: `[{"domain": "shop", "folder-name": "images", "display-name": "Images"}]`,
+			wantErr:    "unknown domain 'shop'",
+		},
+		{
+			name:       "invalid TTL",
+			categories: `[{"domain": "main", "folder-name": "images", "display-name": "Images", "ttl": "soon"}]`,
+			wantErr:    "TTL",
+		},
+		{
+			name:     "incomplete channel config",
+			settings: `{"channel_configs": {"7": {"domain": "main"}}}`,
+			wantErr:  "channel 7",
+		},
+		{
+			name:     "negative quota",
+			settings: `{"quotas": {"domains": {"main": {"hard_bytes": -1}}}}`,
+			wantErr:  "negative",
+		},
+		{
+			name:    "broken JSON",
+			domains: `[{"folder-name": "main"`,
+			wantErr: "failed to parse domains config",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, cm, sm, files := newTestReloader(t)
+			files.write(t, tt.domains, tt.categories, tt.settings)
+
+			err := r.Reload()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Reload = %v, want an error mentioning %q", err, tt.wantErr)
+			}
+			if !r.LastReload().IsZero() {
+				t.Error("failed reload recorded as the last reload")
+			}
+			if folder, _, ok := cm.GetDomainByFQDN("blog.example.com"); !ok || folder != "blog" {
+				t.Errorf("running domains changed: blog.example.com resolves to %q, %v", folder, ok)
+			}
+			if _, ok := cm.GetCategoryID("main", "images"); !ok {
+				t.Error("running categories changed")
+			}
+			if cc, ok := sm.GetChannelConfig("42"); !ok || cc.Category != "images" {
+				t.Errorf("running settings changed: %+v, %v", cc, ok)
+			}
+		})
+	}
+}
+
+func TestReloaderIgnoresOwnWrites(t *testing.T) {
+	r, cm, sm, files := newTestReloader(t)
+
+	if err := cm.SetDomainDisplayName("main", "Main CDN"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.SaveDomains(files.domains); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.SetCategoryDisplayName("main", "images", "Pictures"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.SaveCategories(files.categories); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.SetChannelConfig("43", "blog", "posts"); err != nil {
+		t.Fatal(err)
+	}
+	if changed := r.changedFiles(); len(changed) != 0 {
+		t.Errorf("own writes seen as changes: %v", changed)
+	}
+
+	// An edit made after the process's own write is still noticed
+	files.write(t, "", "", `{"channel_configs": {"7": {"domain": "main", "category": "images"}}}`)
+	if changed := r.changedFiles(); len(changed) != 1 || changed[0] != files.settings {
+		t.Errorf("changed files = %v, want the settings", changed)
+	}
+}
+
+func TestSaveWritesRenamesTogether(t *testing.T) {
+	r, cm, _, files := newTestReloader(t)
+
+	if err := cm.RenameDomain("blog", "journal"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.Save(files.domains, files.categories); err != nil {
+		t.Fatal(err)
+	}
+	if changed := r.changedFiles(); len(changed) != 0 {
+		t.Errorf("own writes seen as changes: %v", changed)
+	}
+
+	// What was saved reloads as the running config
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cm.GetCategoryID("journal", "posts"); !ok {
+		t.Error("renamed domain lost its category")
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
+
+	"github.com/vixa/cdn/internal/atomicfile"
 )
 
 type GlobalDefaults struct {
@@ -20,12 +23,16 @@ type ChannelConfig struct {
 type Settings struct {
 	GlobalDefaults GlobalDefaults           `json:"global_defaults,omitempty"`
 	ChannelConfigs map[string]ChannelConfig `json:"channel_configs,omitempty"`
+	Quotas         Quotas                   `json:"quotas,omitempty"`
 }
 
 type SettingsManager struct {
 	settingsPath string
 	settings     Settings
-	mu           sync.RWMutex
+	// reloader, if set, is told about writes to the settings file so it
+	// doesn't reload it
+	reloader *Reloader
+	mu       sync.RWMutex
 }
 
 func NewSettingsManager(settingsPath string) (*SettingsManager, error) {
@@ -50,14 +57,24 @@ func NewSettingsManager(settingsPath string) (*SettingsManager, error) {
 }
 
 func (sm *SettingsManager) load() error {
-	data, err := os.ReadFile(sm.settingsPath)
+	settings, err := readSettings(sm.settingsPath)
 	if err != nil {
 		return err
 	}
+	sm.replace(settings)
+	return nil
+}
+
+// readSettings reads a settings file without applying it.
+func readSettings(path string) (Settings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Settings{}, err
+	}
 
 	var settings Settings
 	if err := json.Unmarshal(data, &settings); err != nil {
-		return fmt.Errorf("failed to parse settings: %w", err)
+		return Settings{}, fmt.Errorf("failed to parse settings: %w", err)
 	}
 
 	// Ensure ChannelConfigs is initialized
@@ -65,14 +82,45 @@ func (sm *SettingsManager) load() error {
 		settings.ChannelConfigs = make(map[string]ChannelConfig)
 	}
 
+	return settings, nil
+}
+
+// validateSettings checks settings about to be reloaded for entries the bot
+// would not have written.
+func validateSettings(settings Settings) error {
+	for channelID, cc := range settings.ChannelConfigs {
+		if cc.Domain == "" || cc.Category == "" {
+			return fmt.Errorf("channel %s needs both a domain and a category", channelID)
+		}
+	}
+	for scope, quotas := range map[string]map[string]Quota{
+		QuotaDomain:   settings.Quotas.Domains,
+		QuotaCategory: settings.Quotas.Categories,
+		QuotaUser:     settings.Quotas.Users,
+	} {
+		for key, q := range quotas {
+			if q.HardBytes < 0 || q.SoftBytes < 0 || q.MaxFiles < 0 {
+				return fmt.Errorf("%s quota for '%s' is negative", scope, key)
+			}
+		}
+	}
+	return nil
+}
+
+func (sm *SettingsManager) replace(settings Settings) {
 	sm.mu.Lock()
 	sm.settings = settings
 	sm.mu.Unlock()
-
-	return nil
 }
 
 func (sm *SettingsManager) save() error {
+	sm.mu.RLock()
+	reloader := sm.reloader
+	sm.mu.RUnlock()
+	return reloader.saving(sm.write, sm.settingsPath)
+}
+
+func (sm *SettingsManager) write() error {
 	sm.mu.RLock()
 	data, err := json.MarshalIndent(sm.settings, "", "  ")
 	sm.mu.RUnlock()
@@ -86,7 +134,7 @@ func (sm *SettingsManager) save() error {
 		return fmt.Errorf("failed to create settings directory: %w", err)
 	}
 
-	if err := os.WriteFile(sm.settingsPath, data, 0644); err != nil {
+	if err := atomicfile.WriteFile(sm.settingsPath, data, 0644); err != nil {
 		return fmt.Errorf("failed to write settings file: %w", err)
 	}
 
@@ -159,3 +207,46 @@ func (sm *SettingsManager) HasGlobalDefaults() bool {
 	defer sm.mu.RUnlock()
 	return sm.settings.GlobalDefaults.Domain != "" && sm.settings.GlobalDefaults.Category != ""
 }
+
+// RenameDomain points the defaults, channel configs and quotas of a domain
+// and its categories at its new folder name.
+func (sm *SettingsManager) RenameDomain(folderName, newFolderName string) error {
+	sm.mu.Lock()
+	if sm.settings.GlobalDefaults.Domain == folderName {
+		sm.settings.GlobalDefaults.Domain = newFolderName
+	}
+	for channelID, cc := range sm.settings.ChannelConfigs {
+		if cc.Domain == folderName {
+			cc.Domain = newFolderName
+			sm.settings.ChannelConfigs[channelID] = cc
+		}
+	}
+	moveKey(sm.settings.Quotas.Domains, folderName, newFolderName)
+	for key := range sm.settings.Quotas.Categories {
+		if category, ok := strings.CutPrefix(key, folderName+"/"); ok {
+			moveKey(sm.settings.Quotas.Categories, key, CategoryScope(newFolderName, category))
+		}
+	}
+	sm.mu.Unlock()
+
+	return sm.save()
+}
+
+// RenameCategory points the defaults, channel configs and quota of a
+// category at its new folder name.
+func (sm *SettingsManager) RenameCategory(domainFolder, folderName, newFolderName string) error {
+	sm.mu.Lock()
+	if d := &sm.settings.GlobalDefaults; d.Domain == domainFolder && d.Category == folderName {
+		d.Category = newFolderName
+	}
+	for channelID, cc := range sm.settings.ChannelConfigs {
+		if cc.Domain == domainFolder && cc.Category == folderName {
+			cc.Category = newFolderName
+			sm.settings.ChannelConfigs[channelID] = cc
+		}
+	}
+	moveKey(sm.settings.Quotas.Categories, CategoryScope(domainFolder, folderName), CategoryScope(domainFolder, newFolderName))
+	sm.mu.Unlock()
+
+	return sm.save()
+}
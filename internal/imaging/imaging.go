@@ -0,0 +1,246 @@
+// Package imaging resizes and re-encodes PNG, JPEG and GIF images for the
+// CDN's on-the-fly image transforms.
+package imaging
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	FitContain = "contain"
+	FitCover   = "cover"
+	FitFill    = "fill"
+
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+	FormatGIF  = "gif"
+
+	defaultQuality = 85
+)
+
+var ErrTooLarge = errors.New("source image is too large to transform")
+
+// Options describe a transform requested through query parameters.
+type Options struct {
+	Width   int
+	Height  int
+	Fit     string
+	Quality int
+	Format  string
+}
+
+// transformParams are the query parameters that switch a request into
+// transform mode.
+var transformParams = []string{"w", "h", "fit", "q", "fmt"}
+
+// Requested reports whether the query asks for an image transform.
+func Requested(query url.Values) bool {
+	for _, p := range transformParams {
+		if query.Has(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseOptions reads w, h, fit, q and fmt from the query. Width and height
+// are limited to maxDimension pixels.
+func ParseOptions(query url.Values, maxDimension int) (Options, error) {
+	opts := Options{Fit: FitContain}
+
+	var err error
+	if opts.Width, err = parseDimension(query.Get("w"), "w", maxDimension); err != nil {
+		return Options{}, err
+	}
+	if opts.Height, err = parseDimension(query.Get("h"), "h", maxDimension); err != nil {
+		return Options{}, err
+	}
+
+	if fit := strings.ToLower(query.Get("fit")); fit != "" {
+		switch fit {
+		case FitContain, FitCover, FitFill:
+			opts.Fit = fit
+		default:
+			return Options{}, fmt.Errorf("invalid fit '%s' (expected contain, cover or fill)", fit)
+		}
+	}
+	if (opts.Fit == FitCover || opts.Fit == FitFill) && (opts.Width == 0 || opts.Height == 0) {
+		return Options{}, fmt.Errorf("fit=%s requires both w and h", opts.Fit)
+	}
+
+	if q := query.Get("q"); q != "" {
+		quality, err := strconv.Atoi(q)
+		if err != nil || quality < 1 || quality > 100 {
+			return Options{}, fmt.Errorf("invalid q '%s' (expected 1-100)", q)
+		}
+		opts.Quality = quality
+	}
+
+	if f := strings.ToLower(query.Get("fmt")); f != "" {
+		switch f {
+		case "png":
+			opts.Format = FormatPNG
+		case "jpg", "jpeg":
+			opts.Format = FormatJPEG
+		case "gif":
+			opts.Format = FormatGIF
+		default:
+			return Options{}, fmt.Errorf("invalid fmt '%s' (expected png, jpeg or gif)", f)
+		}
+	}
+
+	return opts, nil
+}
+
+func parseDimension(value, name string, maxDimension int) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid %s '%s'", name, value)
+	}
+	if n > maxDimension {
+		return 0, fmt.Errorf("%s must be at most %d", name, maxDimension)
+	}
+	return n, nil
+}
+
+// Key identifies the variant produced by these options from a source with
+// the given ETag. It is safe to use as a file name.
+func (o Options) Key(sourceETag string) string {
+	canonical := fmt.Sprintf("%s|w=%d|h=%d|fit=%s|q=%d|fmt=%s", sourceETag, o.Width, o.Height, o.Fit, o.Quality, o.Format)
+	sum := sha256.Sum256([]byte(canonical))
+	return hex.EncodeToString(sum[:12])
+}
+
+// SupportedSource reports whether a content type can be transformed.
+func SupportedSource(contentType string) bool {
+	return FormatFromContentType(contentType) != ""
+}
+
+// FormatFromContentType returns the format of a supported source type.
+func FormatFromContentType(contentType string) string {
+	switch contentType {
+	case "image/png":
+		return FormatPNG
+	case "image/jpeg":
+		return FormatJPEG
+	case "image/gif":
+		return FormatGIF
+	}
+	return ""
+}
+
+// ContentType returns the MIME type of an output format.
+func ContentType(format string) string {
+	return "image/" + format
+}
+
+// Transform decodes src, applies the options and re-encodes the result. It
+// returns the encoded image and its format. Sources with more than maxPixels
+// pixels are rejected before being decoded.
+func Transform(src io.ReadSeeker, o Options, maxPixels int) ([]byte, string, error) {
+	cfg, srcFormat, err := image.DecodeConfig(src)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read image header: %w", err)
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, "", ErrTooLarge
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	out := resizeToFit(img, o)
+
+	format := o.Format
+	if format == "" {
+		format = srcFormat
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case FormatPNG:
+		err = png.Encode(&buf, out)
+	case FormatJPEG:
+		quality := o.Quality
+		if quality == 0 {
+			quality = defaultQuality
+		}
+		err = jpeg.Encode(&buf, out, &jpeg.Options{Quality: quality})
+	case FormatGIF:
+		err = gif.Encode(&buf, out, nil)
+	default:
+		return nil, "", fmt.Errorf("unsupported output format '%s'", format)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	return buf.Bytes(), format, nil
+}
+
+func resizeToFit(img image.Image, o Options) image.Image {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw == 0 || sh == 0 || (o.Width == 0 && o.Height == 0) {
+		return img
+	}
+
+	switch o.Fit {
+	case FitFill:
+		return resample(toRGBA(img), o.Width, o.Height)
+
+	case FitCover:
+		scale := max(float64(o.Width)/float64(sw), float64(o.Height)/float64(sh))
+		rw := max(o.Width, int(float64(sw)*scale+0.5))
+		rh := max(o.Height, int(float64(sh)*scale+0.5))
+		resized := resample(toRGBA(img), rw, rh)
+		x0 := (rw - o.Width) / 2
+		y0 := (rh - o.Height) / 2
+		return resized.SubImage(image.Rect(x0, y0, x0+o.Width, y0+o.Height))
+
+	default:
+		// contain, never enlarging the source
+		scale := 1.0
+		if o.Width > 0 {
+			scale = min(scale, float64(o.Width)/float64(sw))
+		}
+		if o.Height > 0 {
+			scale = min(scale, float64(o.Height)/float64(sh))
+		}
+		if scale >= 1 {
+			return img
+		}
+		return resample(toRGBA(img), max(1, int(float64(sw)*scale+0.5)), max(1, int(float64(sh)*scale+0.5)))
+	}
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
@@ -0,0 +1,104 @@
+package imaging
+
+import (
+	"image"
+	"math"
+)
+
+// weight is the contribution of one source pixel to one destination pixel.
+type weight struct {
+	index int
+	value float64
+}
+
+// computeWeights builds a triangle (bilinear) filter for scaling srcSize to
+// dstSize. When shrinking, the filter is widened so every source pixel
+// contributes, which avoids aliasing.
+func computeWeights(srcSize, dstSize int) [][]weight {
+	scale := float64(srcSize) / float64(dstSize)
+	support := 1.0
+	if scale > 1 {
+		support = scale
+	}
+
+	weights := make([][]weight, dstSize)
+	for i := range weights {
+		center := (float64(i)+0.5)*scale - 0.5
+		start := int(math.Floor(center - support))
+		end := int(math.Ceil(center + support))
+
+		var sum float64
+		var ws []weight
+		for j := start; j <= end; j++ {
+			w := 1 - math.Abs(float64(j)-center)/support
+			if w <= 0 {
+				continue
+			}
+			idx := min(max(j, 0), srcSize-1)
+			ws = append(ws, weight{index: idx, value: w})
+			sum += w
+		}
+		for k := range ws {
+			ws[k].value /= sum
+		}
+		weights[i] = ws
+	}
+	return weights
+}
+
+// resample scales a premultiplied RGBA image to w x h in two separable passes.
+func resample(src *image.RGBA, w, h int) *image.RGBA {
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+
+	// Horizontal pass: sw x sh -> w x sh
+	xWeights := computeWeights(sw, w)
+	tmp := make([]float64, w*sh*4)
+	for y := 0; y < sh; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x, ws := range xWeights {
+			var r, g, b, a float64
+			for _, wt := range ws {
+				p := row[wt.index*4:]
+				r += float64(p[0]) * wt.value
+				g += float64(p[1]) * wt.value
+				b += float64(p[2]) * wt.value
+				a += float64(p[3]) * wt.value
+			}
+			o := (y*w + x) * 4
+			tmp[o], tmp[o+1], tmp[o+2], tmp[o+3] = r, g, b, a
+		}
+	}
+
+	// Vertical pass: w x sh -> w x h
+	yWeights := computeWeights(sh, h)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y, ws := range yWeights {
+		for x := 0; x < w; x++ {
+			var r, g, b, a float64
+			for _, wt := range ws {
+				o := (wt.index*w + x) * 4
+				r += tmp[o] * wt.value
+				g += tmp[o+1] * wt.value
+				b += tmp[o+2] * wt.value
+				a += tmp[o+3] * wt.value
+			}
+			d := dst.Pix[y*dst.Stride+x*4:]
+			// Keep colour channels within alpha so the result stays valid premultiplied RGBA
+			ca := clamp(a)
+			d[0], d[1], d[2], d[3] = min(clamp(r), ca), min(clamp(g), ca), min(clamp(b), ca), ca
+		}
+	}
+	return dst
+}
+
+func clamp(v float64) uint8 {
+	v = math.Round(v)
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
@@ -0,0 +1,81 @@
+package metadata
+
+import (
+	"mime"
+	"path"
+	"time"
+
+	"github.com/vixa/cdn/internal/storage"
+)
+
+// BackfillResult summarises a Backfill run.
+type BackfillResult struct {
+	Added   int
+	Removed int
+	Failed  int
+}
+
+// Backfill indexes files that are in storage but have no record yet, such as
+// files uploaded before the index existed, and drops records of files that
+// are gone. Only the given category folders of each domain folder are
+// scanned.
+// Backfilled records carry what storage knows: size, hash and the time the
+// file was stored.
+func (ix *Index) Backfill(stor *storage.Storage, folders map[string][]string) (BackfillResult, error) {
+	var result BackfillResult
+	started := time.Now().Add(-time.Second)
+
+	for domainFolder, categories := range folders {
+		for _, category := range categories {
+			files, err := stor.ListFiles(domainFolder, category)
+			if err != nil {
+				return result, err
+			}
+
+			present := make(map[string]bool, len(files))
+			for _, filename := range files {
+				present[filename] = true
+				if _, ok := ix.Get(domainFolder, category, filename); ok {
+					continue
+				}
+
+				hash, info, err := stor.FileHash(domainFolder, category, filename)
+				if err != nil {
+					result.Failed++
+					continue
+				}
+
+				added, err := ix.putIfAbsent(Record{
+					Domain:      domainFolder,
+					Category:    category,
+					Filename:    filename,
+					Source:      SourceBackfill,
+					UploadedAt:  info.ModTime,
+					Size:        info.Size,
+					SHA256:      hash,
+					ContentType: mime.TypeByExtension(path.Ext(filename)),
+				})
+				if err != nil {
+					return result, err
+				}
+				if added {
+					result.Added++
+				}
+			}
+
+			// Files uploaded after the listing are not stale
+			stale := ix.Find(func(r Record) bool {
+				return r.Domain == domainFolder && r.Category == category &&
+					!present[r.Filename] && r.UploadedAt.Before(started)
+			})
+			for _, r := range stale {
+				if err := ix.Delete(r.Domain, r.Category, r.Filename); err != nil {
+					return result, err
+				}
+				result.Removed++
+			}
+		}
+	}
+
+	return result, nil
+}
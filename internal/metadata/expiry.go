@@ -0,0 +1,131 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vixa/cdn/internal/metrics"
+	"github.com/vixa/cdn/internal/storage"
+)
+
+func tombstoneEntry(key string, expired time.Time) logEntry {
+	parts := strings.SplitN(key, "/", 3)
+	return logEntry{
+		Expired: true,
+		Record: Record{
+			Domain:    parts[0],
+			Category:  parts[1],
+			Filename:  parts[2],
+			ExpiresAt: &expired,
+		},
+	}
+}
+
+// Gone reports whether a file has expired, whether or not the janitor has
+// deleted it yet.
+func (ix *Index) Gone(domainFolder, category, filename string, now time.Time) bool {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	key := recordKey(domainFolder, category, filename)
+	if _, ok := ix.tombstones[key]; ok {
+		return true
+	}
+	r, ok := ix.records[key]
+	return ok && r.ExpiredBy(now)
+}
+
+// markExpired replaces the record of an expired file with a tombstone.
+func (ix *Index) markExpired(r Record) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	key := r.key()
+	current, ok := ix.records[key]
+	if !ok {
+		return nil
+	}
+
+	entry := tombstoneEntry(key, *r.ExpiresAt)
+	if err := ix.append(entry); err != nil {
+		return err
+	}
+	delete(ix.records, key)
+	ix.account(current, -1)
+	ix.tombstones[key] = *r.ExpiresAt
+	return nil
+}
+
+// ExpireFiles deletes every file whose lifetime is over at now and returns
+// how many were deleted.
+func (ix *Index) ExpireFiles(stor *storage.Storage, now time.Time) (int, error) {
+	expired := ix.Find(func(r Record) bool {
+		return r.ExpiredBy(now)
+	})
+
+	deleted := 0
+	var errs []error
+	for _, r := range expired {
+		if err := stor.DeleteFile(r.Domain, r.Category, r.Filename); err != nil && !errors.Is(err, storage.ErrFileNotFound) {
+			errs = append(errs, fmt.Errorf("%s/%s/%s: %w", r.Domain, r.Category, r.Filename, err))
+			continue
+		}
+		if err := ix.markExpired(r); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		metrics.DeletesTotal.Inc(r.Domain, r.Category, metrics.SourceExpiry)
+		deleted++
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// StartJanitor deletes expired files, purges files that have been in the
+// trash longer than trashRetention and compacts the index log, every
+// interval until stop is called.
+func (ix *Index) StartJanitor(stor *storage.Storage, interval, trashRetention time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			now := time.Now()
+			deleted, err := ix.ExpireFiles(stor, now)
+			if deleted > 0 {
+				fmt.Printf("[Janitor] Deleted %d expired file(s)\n", deleted)
+			}
+			if err != nil {
+				fmt.Printf("[Janitor] Failed to delete expired files: %v\n", err)
+			}
+
+			purged, err := ix.PurgeTrash(stor, now.Add(-trashRetention))
+			if purged > 0 {
+				fmt.Printf("[Janitor] Purged %d file(s) from the trash\n", purged)
+			}
+			if err != nil {
+				fmt.Printf("[Janitor] Failed to purge the trash: %v\n", err)
+			}
+
+			if compacted, err := ix.compactIfStale(now); err != nil {
+				fmt.Printf("[Janitor] Failed to compact the metadata index: %v\n", err)
+			} else if compacted {
+				fmt.Printf("[Janitor] Compacted the metadata index\n")
+			}
+
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -0,0 +1,482 @@
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vixa/cdn/internal/atomicfile"
+	"github.com/vixa/cdn/internal/config"
+)
+
+// Upload sources recorded for each file.
+const (
+	SourceCommand  = "command"
+	SourceAuto     = "auto"
+	SourceAPI      = "api"
+	SourceBackfill = "backfill"
+)
+
+// Record describes one stored file: where it came from and what it holds.
+type Record struct {
+	Domain       string    `json:"domain"`
+	Category     string    `json:"category"`
+	Filename     string    `json:"filename"`
+	OriginalName string    `json:"original_name,omitempty"`
+	UploaderID   string    `json:"uploader_id,omitempty"`
+	ChannelID    string    `json:"channel_id,omitempty"`
+	MessageID    string    `json:"message_id,omitempty"`
+	APIKey       string    `json:"api_key,omitempty"`
+	Source       string    `json:"source,omitempty"`
+	UploadedAt   time.Time `json:"uploaded_at"`
+	Size         int64     `json:"size"`
+	SHA256       string    `json:"sha256,omitempty"`
+	ContentType  string    `json:"content_type,omitempty"`
+	// ExpiresAt is when the file is deleted automatically, if ever.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// ExpiredBy reports whether the file's lifetime is over at t.
+func (r Record) ExpiredBy(t time.Time) bool {
+	return r.ExpiresAt != nil && !t.Before(*r.ExpiresAt)
+}
+
+func (r Record) key() string {
+	return recordKey(r.Domain, r.Category, r.Filename)
+}
+
+func recordKey(domainFolder, category, filename string) string {
+	return domainFolder + "/" + category + "/" + filename
+}
+
+// logEntry is one line of the index file. Deletions are written as the
+// record's identifying fields with Deleted set; files removed because they
+// expired are written the same way with Expired set instead. Files moved to
+// the trash are written as their full record with Trashed set.
+type logEntry struct {
+	Deleted bool `json:"deleted,omitempty"`
+	Expired bool `json:"expired,omitempty"`
+	Trashed bool `json:"trashed,omitempty"`
+	Record
+}
+
+// tombstoneRetention is how long expired files are remembered, and answered
+// with 410 Gone instead of 404 Not Found.
+const tombstoneRetention = 90 * 24 * time.Hour
+
+// compactThreshold is how many superseded lines the log may hold before it
+// is rewritten, on load or by the janitor.
+const compactThreshold = 1000
+
+// Index keeps a record for every stored file in memory and persists changes
+// by appending JSON lines to a log file, so writes never rewrite the whole
+// index. The log is compacted when it is opened and, once enough of it is
+// superseded, by the janitor.
+type Index struct {
+	path    string
+	file    *os.File
+	records map[string]Record
+	mu      sync.RWMutex
+	// Lines in the log, live or superseded
+	lines int
+
+	// Expired files by key, with the time they expired
+	tombstones map[string]time.Time
+	// Records of files in the trash, which count towards no usage
+	trash map[string]Record
+
+	// Running totals, kept in step with records
+	byDomain   map[string]Usage
+	byCategory map[string]Usage
+	byUploader map[string]Usage
+	// Space held by uploads that passed CheckQuotas and are not recorded
+	// yet, by quota scope
+	reserved map[string]Usage
+}
+
+// Usage is the space used by a group of files. Files stored once for several
+// uploads count fully towards each of them.
+type Usage struct {
+	Bytes int64
+	Files int64
+}
+
+// Open loads the index at path, creating it if it does not exist.
+func Open(path string) (*Index, error) {
+	ix, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if ix.needsCompaction() {
+		if err := ix.compact(); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open metadata index: %w", err)
+	}
+	ix.file = f
+
+	// Terminate a line torn by a crash so the next record starts cleanly
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			if _, err := f.Write([]byte{'\n'}); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("failed to repair metadata index: %w", err)
+			}
+		}
+	}
+
+	return ix, nil
+}
+
+// OpenReadOnly loads the index at path without ever writing to it, so it is
+// safe to use while another process has the index open. Changes fail.
+func OpenReadOnly(path string) (*Index, error) {
+	return load(path)
+}
+
+// load reads the index at path.
+func load(path string) (*Index, error) {
+	ix := &Index{
+		path:       path,
+		records:    make(map[string]Record),
+		tombstones: make(map[string]time.Time),
+		trash:      make(map[string]Record),
+		byDomain:   make(map[string]Usage),
+		byCategory: make(map[string]Usage),
+		byUploader: make(map[string]Usage),
+		reserved:   make(map[string]Usage),
+	}
+
+	if err := ix.replay(); err != nil {
+		return nil, err
+	}
+	for _, r := range ix.records {
+		ix.account(r, 1)
+	}
+	return ix, nil
+}
+
+// replay replays the log.
+func (ix *Index) replay() error {
+	f, err := os.Open(ix.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to open metadata index: %w", err)
+	}
+	defer f.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var entry logEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			// A crash while appending leaves at most one torn line behind
+			fmt.Printf("[Metadata] Skipping unreadable line %d in %s: %v\n", lines+1, ix.path, err)
+			lines++
+			continue
+		}
+		lines++
+
+		switch {
+		case entry.Deleted:
+			delete(ix.records, entry.key())
+			delete(ix.trash, entry.key())
+		case entry.Expired:
+			delete(ix.records, entry.key())
+			if entry.ExpiresAt != nil {
+				ix.tombstones[entry.key()] = *entry.ExpiresAt
+			}
+		case entry.Trashed:
+			delete(ix.records, entry.key())
+			ix.trash[entry.key()] = entry.Record
+		default:
+			delete(ix.tombstones, entry.key())
+			delete(ix.trash, entry.key())
+			ix.records[entry.key()] = entry.Record
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read metadata index: %w", err)
+	}
+
+	ix.lines = lines
+	ix.pruneTombstones(time.Now())
+	return nil
+}
+
+// pruneTombstones forgets files that expired longer than tombstoneRetention
+// before now.
+func (ix *Index) pruneTombstones(now time.Time) {
+	cutoff := now.Add(-tombstoneRetention)
+	for key, expired := range ix.tombstones {
+		if expired.Before(cutoff) {
+			delete(ix.tombstones, key)
+		}
+	}
+}
+
+// needsCompaction reports whether enough of the log is superseded to be
+// worth rewriting: more than compactThreshold lines, and more than are live.
+func (ix *Index) needsCompaction() bool {
+	live := len(ix.records) + len(ix.tombstones) + len(ix.trash)
+	stale := ix.lines - live
+	return stale > compactThreshold && stale > live
+}
+
+// compactIfStale compacts the log of an open index once enough of it is
+// superseded, reporting whether it did.
+func (ix *Index) compactIfStale(now time.Time) (bool, error) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if ix.file == nil {
+		return false, nil
+	}
+	ix.pruneTombstones(now)
+	if !ix.needsCompaction() {
+		return false, nil
+	}
+
+	if err := ix.compact(); err != nil {
+		return false, err
+	}
+	// The log was replaced, so appends have to go to the new file
+	old := ix.file
+	f, err := os.OpenFile(ix.path, os.O_RDWR|os.O_APPEND, 0644)
+	old.Close()
+	if err != nil {
+		ix.file = nil
+		return false, fmt.Errorf("failed to reopen metadata index: %w", err)
+	}
+	ix.file = f
+	return true, nil
+}
+
+// compact rewrites the log with one line per live record, tombstone and
+// trashed record.
+func (ix *Index) compact() error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range ix.sorted(nil) {
+		if err := enc.Encode(logEntry{Record: r}); err != nil {
+			return fmt.Errorf("failed to encode metadata: %w", err)
+		}
+	}
+	for key, expired := range ix.tombstones {
+		if err := enc.Encode(tombstoneEntry(key, expired)); err != nil {
+			return fmt.Errorf("failed to encode metadata: %w", err)
+		}
+	}
+	for _, r := range ix.trash {
+		if err := enc.Encode(logEntry{Trashed: true, Record: r}); err != nil {
+			return fmt.Errorf("failed to encode metadata: %w", err)
+		}
+	}
+
+	if err := atomicfile.WriteFile(ix.path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to compact metadata index: %w", err)
+	}
+	ix.lines = len(ix.records) + len(ix.tombstones) + len(ix.trash)
+	return nil
+}
+
+func (ix *Index) append(entry logEntry) error {
+	if ix.file == nil {
+		return errors.New("metadata index is open read-only")
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	if _, err := ix.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	ix.lines++
+	if err := ix.file.Sync(); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	return nil
+}
+
+// Put adds or replaces the record of a file.
+func (ix *Index) Put(r Record) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	return ix.put(r)
+}
+
+// putIfAbsent adds the record unless the file already has one, reporting
+// whether it was added.
+func (ix *Index) putIfAbsent(r Record) (bool, error) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if _, ok := ix.records[r.key()]; ok {
+		return false, nil
+	}
+	return true, ix.put(r)
+}
+
+func (ix *Index) put(r Record) error {
+	if r.UploadedAt.IsZero() {
+		r.UploadedAt = time.Now()
+	}
+	r.UploadedAt = r.UploadedAt.UTC().Truncate(time.Second)
+	if r.ExpiresAt != nil {
+		expires := r.ExpiresAt.UTC().Truncate(time.Second)
+		r.ExpiresAt = &expires
+	}
+
+	if err := ix.append(logEntry{Record: r}); err != nil {
+		return err
+	}
+	if old, ok := ix.records[r.key()]; ok {
+		ix.account(old, -1)
+	}
+	delete(ix.tombstones, r.key())
+	delete(ix.trash, r.key())
+	ix.records[r.key()] = r
+	ix.account(r, 1)
+	return nil
+}
+
+// Delete removes the record of a file, whether or not it is in the trash.
+// Removing a file that has no record is not an error.
+func (ix *Index) Delete(domainFolder, category, filename string) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	key := recordKey(domainFolder, category, filename)
+	old, ok := ix.records[key]
+	_, trashed := ix.trash[key]
+	if !ok && !trashed {
+		return nil
+	}
+
+	entry := logEntry{Deleted: true, Record: Record{Domain: domainFolder, Category: category, Filename: filename}}
+	if err := ix.append(entry); err != nil {
+		return err
+	}
+	if ok {
+		delete(ix.records, key)
+		ix.account(old, -1)
+	}
+	delete(ix.trash, key)
+	return nil
+}
+
+// account adds (sign 1) or removes (sign -1) a record from the totals.
+func (ix *Index) account(r Record, sign int64) {
+	add := func(totals map[string]Usage, key string) {
+		u := totals[key]
+		u.Bytes += sign * r.Size
+		u.Files += sign
+		if u.Files <= 0 {
+			delete(totals, key)
+			return
+		}
+		totals[key] = u
+	}
+
+	add(ix.byDomain, r.Domain)
+	add(ix.byCategory, config.CategoryScope(r.Domain, r.Category))
+	if r.UploaderID != "" {
+		add(ix.byUploader, r.UploaderID)
+	}
+}
+
+// DomainUsage returns the space used by the files of a domain folder.
+func (ix *Index) DomainUsage(domainFolder string) Usage {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.byDomain[domainFolder]
+}
+
+// CategoryUsage returns the space used by the files of a domain's category.
+func (ix *Index) CategoryUsage(domainFolder, category string) Usage {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.byCategory[config.CategoryScope(domainFolder, category)]
+}
+
+// UploaderUsage returns the space used by the files a Discord user uploaded.
+func (ix *Index) UploaderUsage(userID string) Usage {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.byUploader[userID]
+}
+
+// Get returns the record of a file.
+func (ix *Index) Get(domainFolder, category, filename string) (Record, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	r, ok := ix.records[recordKey(domainFolder, category, filename)]
+	return r, ok
+}
+
+// Find returns the records match accepts, newest first. A nil match returns
+// every record.
+func (ix *Index) Find(match func(Record) bool) []Record {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	return ix.sorted(match)
+}
+
+// Len returns the number of indexed files.
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	return len(ix.records)
+}
+
+func (ix *Index) sorted(match func(Record) bool) []Record {
+	result := []Record{}
+	for _, r := range ix.records {
+		if match == nil || match(r) {
+			result = append(result, r)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].UploadedAt.Equal(result[j].UploadedAt) {
+			return result[i].UploadedAt.After(result[j].UploadedAt)
+		}
+		return result[i].key() < result[j].key()
+	})
+	return result
+}
+
+// Close closes the index file.
+func (ix *Index) Close() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if ix.file == nil {
+		return nil
+	}
+	return ix.file.Close()
+}
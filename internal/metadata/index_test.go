@@ -0,0 +1,65 @@
+package metadata
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte{'\n'})
+}
+
+func TestCompactWhileRunning(t *testing.T) {
+	ix := newTestIndex(t)
+	putRecord(t, ix, "main", "images", "kept.png", "1", 10)
+	if err := ix.Trash("main", "images", "kept.png"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= compactThreshold; i++ {
+		putRecord(t, ix, "main", "docs", "a.txt", "1", int64(i))
+	}
+	if err := ix.Delete("main", "docs", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	putRecord(t, ix, "main", "docs", "b.txt", "1", 5)
+
+	compacted, err := ix.compactIfStale(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !compacted {
+		t.Fatal("log with superseded lines not compacted")
+	}
+	if lines := countLines(t, ix.path); lines != 2 {
+		t.Errorf("compacted log has %d lines, want 2", lines)
+	}
+	if compacted, _ := ix.compactIfStale(time.Now()); compacted {
+		t.Error("compacted log compacted again")
+	}
+
+	// Later changes go to the new log
+	putRecord(t, ix, "main", "docs", "c.txt", "1", 7)
+	reopened, err := OpenReadOnly(ix.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reopened.Get("main", "docs", "c.txt"); !ok {
+		t.Error("record written after compaction lost")
+	}
+	if _, ok := reopened.Get("main", "docs", "a.txt"); ok {
+		t.Error("deleted record came back")
+	}
+	if got := reopened.DomainUsage("main"); got != (Usage{Bytes: 12, Files: 2}) {
+		t.Errorf("usage = %+v", got)
+	}
+	if len(reopened.trash) != 1 {
+		t.Errorf("trash = %v", reopened.trash)
+	}
+}
@@ -0,0 +1,127 @@
+package metadata
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/vixa/cdn/internal/config"
+)
+
+// QuotaCheck is the outcome of checking an upload against the quotas.
+type QuotaCheck struct {
+	// Rejection explains why the upload is refused. It is empty when the
+	// upload may go ahead.
+	Rejection string
+	// Warnings lists the soft quotas the upload goes past.
+	Warnings []string
+
+	reservation *reservation
+}
+
+// reservation is the space an accepted upload holds in the quotas until it
+// is recorded, so uploads checked at the same time can't each fit on their
+// own and together go past a quota.
+type reservation struct {
+	ix   *Index
+	keys []string
+	size int64
+	once sync.Once
+}
+
+// Release gives back the space the check reserved. Callers release an
+// accepted check once the upload has been recorded with Put, or has failed.
+// It does nothing for a rejected check and may be called more than once.
+func (c QuotaCheck) Release() {
+	r := c.reservation
+	if r == nil {
+		return
+	}
+	r.once.Do(func() {
+		r.ix.mu.Lock()
+		defer r.ix.mu.Unlock()
+		for _, key := range r.keys {
+			u := r.ix.reserved[key]
+			u.Bytes -= r.size
+			u.Files--
+			if u.Files <= 0 {
+				delete(r.ix.reserved, key)
+				continue
+			}
+			r.ix.reserved[key] = u
+		}
+	})
+}
+
+// CheckQuotas checks whether an upload of size bytes fits in the quotas of
+// its domain, its category and its uploader, counting the uploads other
+// checks let through that are not recorded yet. uploaderID may be empty for
+// uploads that don't come from a Discord user. An accepted upload reserves
+// its size until the check is released.
+func (ix *Index) CheckQuotas(sm *config.SettingsManager, domainFolder, category, uploaderID string, size int64) QuotaCheck {
+	type scope struct {
+		kind, key, label string
+		usage            Usage
+	}
+	scopes := []scope{
+		{config.QuotaDomain, domainFolder, fmt.Sprintf("domain `%s`", domainFolder), Usage{}},
+		{config.QuotaCategory, config.CategoryScope(domainFolder, category), fmt.Sprintf("category `%s`", category), Usage{}},
+	}
+	if uploaderID != "" {
+		scopes = append(scopes, scope{config.QuotaUser, uploaderID, "your uploads", Usage{}})
+	}
+
+	// Checking and reserving happen under one lock, so no other upload
+	// slips in between
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	totals := map[string]map[string]Usage{
+		config.QuotaDomain:   ix.byDomain,
+		config.QuotaCategory: ix.byCategory,
+		config.QuotaUser:     ix.byUploader,
+	}
+	for i, sc := range scopes {
+		u := totals[sc.kind][sc.key]
+		pending := ix.reserved[reservationKey(sc.kind, sc.key)]
+		scopes[i].usage = Usage{Bytes: u.Bytes + pending.Bytes, Files: u.Files + pending.Files}
+	}
+
+	var check QuotaCheck
+	for _, sc := range scopes {
+		quota, ok := sm.GetQuota(sc.kind, sc.key)
+		if !ok {
+			continue
+		}
+
+		total := sc.usage.Bytes + size
+		if quota.HardBytes > 0 && total > quota.HardBytes {
+			check.Rejection = fmt.Sprintf("Quota exceeded for %s: %s of %s used, this file is %s.",
+				sc.label, config.FormatSize(sc.usage.Bytes), config.FormatSize(quota.HardBytes), config.FormatSize(size))
+			return check
+		}
+		if quota.MaxFiles > 0 && sc.usage.Files >= quota.MaxFiles {
+			check.Rejection = fmt.Sprintf("File limit of %d file(s) reached for %s.", quota.MaxFiles, sc.label)
+			return check
+		}
+		if quota.SoftBytes > 0 && total > quota.SoftBytes {
+			check.Warnings = append(check.Warnings, fmt.Sprintf("Soft quota exceeded for %s: %s of %s used.",
+				sc.label, config.FormatSize(total), config.FormatSize(quota.SoftBytes)))
+		}
+	}
+
+	r := &reservation{ix: ix, size: size}
+	for _, sc := range scopes {
+		key := reservationKey(sc.kind, sc.key)
+		u := ix.reserved[key]
+		u.Bytes += size
+		u.Files++
+		ix.reserved[key] = u
+		r.keys = append(r.keys, key)
+	}
+	check.reservation = r
+	return check
+}
+
+func reservationKey(kind, key string) string {
+	return kind + ":" + key
+}
@@ -0,0 +1,154 @@
+package metadata
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vixa/cdn/internal/config"
+)
+
+func newTestIndex(t *testing.T) *Index {
+	t.Helper()
+	ix, err := Open(filepath.Join(t.TempDir(), "metadata.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ix.Close() })
+	return ix
+}
+
+func putRecord(t *testing.T, ix *Index, domainFolder, category, filename, uploaderID string, size int64) {
+	t.Helper()
+	if err := ix.Put(Record{Domain: domainFolder, Category: category, Filename: filename, UploaderID: uploaderID, Size: size}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestSettings(t *testing.T) *config.SettingsManager {
+	t.Helper()
+	sm, err := config.NewSettingsManager(filepath.Join(t.TempDir(), "settings.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sm
+}
+
+func TestCategoryUsageIsPerDomain(t *testing.T) {
+	ix := newTestIndex(t)
+	putRecord(t, ix, "main", "images", "a.png", "", 100)
+	putRecord(t, ix, "main", "images", "b.png", "", 50)
+	putRecord(t, ix, "blog", "images", "c.png", "", 30)
+
+	if u := ix.CategoryUsage("main", "images"); u != (Usage{Bytes: 150, Files: 2}) {
+		t.Errorf("main/images usage = %+v", u)
+	}
+	if u := ix.CategoryUsage("blog", "images"); u != (Usage{Bytes: 30, Files: 1}) {
+		t.Errorf("blog/images usage = %+v", u)
+	}
+
+	if err := ix.Delete("main", "images", "a.png"); err != nil {
+		t.Fatal(err)
+	}
+	if u := ix.CategoryUsage("main", "images"); u != (Usage{Bytes: 50, Files: 1}) {
+		t.Errorf("main/images usage after a delete = %+v", u)
+	}
+
+	if _, err := ix.Rename("main", "images", "pictures"); err != nil {
+		t.Fatal(err)
+	}
+	if u := ix.CategoryUsage("main", "pictures"); u != (Usage{Bytes: 50, Files: 1}) {
+		t.Errorf("usage after a rename = %+v", u)
+	}
+	if u := ix.CategoryUsage("main", "images"); u != (Usage{}) {
+		t.Errorf("usage left under the old name = %+v", u)
+	}
+}
+
+func TestCheckQuotas(t *testing.T) {
+	ix := newTestIndex(t)
+	sm := newTestSettings(t)
+	putRecord(t, ix, "main", "images", "a.png", "u1", 80)
+	putRecord(t, ix, "blog", "images", "b.png", "u2", 10)
+
+	set := func(scope, key string, q config.Quota) {
+		t.Helper()
+		if err := sm.SetQuota(scope, key, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	set(config.QuotaCategory, config.CategoryScope("main", "images"), config.Quota{HardBytes: 100, SoftBytes: 90})
+
+	tests := []struct {
+		name                 string
+		domain, uploader     string
+		size                 int64
+		rejected, warned     bool
+		rejectionMentionsKey string
+	}{
+		{"fits", "main", "u1", 5, false, false, ""},
+		{"past the soft quota", "main", "u1", 15, false, true, ""},
+		{"past the hard quota", "main", "u1", 25, true, false, "images"},
+		{"other domain's category", "blog", "u2", 500, false, false, ""},
+	}
+	for _, tt := range tests {
+		check := ix.CheckQuotas(sm, tt.domain, "images", tt.uploader, tt.size)
+		check.Release()
+		if (check.Rejection != "") != tt.rejected || (len(check.Warnings) > 0) != tt.warned {
+			t.Errorf("%s: %+v", tt.name, check)
+		}
+		if !strings.Contains(check.Rejection, tt.rejectionMentionsKey) {
+			t.Errorf("%s: rejection %q does not name %q", tt.name, check.Rejection, tt.rejectionMentionsKey)
+		}
+	}
+
+	set(config.QuotaDomain, "blog", config.Quota{MaxFiles: 1})
+	if check := ix.CheckQuotas(sm, "blog", "images", "", 1); check.Rejection == "" {
+		t.Error("file limit of the domain not enforced")
+	}
+
+	set(config.QuotaDefaultUser, "", config.Quota{HardBytes: 50})
+	if check := ix.CheckQuotas(sm, "main", "docs", "u1", 1); check.Rejection == "" {
+		t.Error("default user quota not enforced")
+	}
+	if check := ix.CheckQuotas(sm, "main", "docs", "", 1); check.Rejection != "" {
+		t.Errorf("upload without a user checked against a user quota: %s", check.Rejection)
+	}
+}
+
+func TestQuotaReservations(t *testing.T) {
+	ix := newTestIndex(t)
+	sm := newTestSettings(t)
+	if err := sm.SetQuota(config.QuotaDomain, "main", config.Quota{HardBytes: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two uploads checked before either is recorded can't both fit
+	first := ix.CheckQuotas(sm, "main", "images", "", 60)
+	if first.Rejection != "" {
+		t.Fatalf("first upload rejected: %s", first.Rejection)
+	}
+	if second := ix.CheckQuotas(sm, "main", "images", "", 60); second.Rejection == "" {
+		t.Error("second upload fits next to the space the first one holds")
+	}
+
+	// Recording the first upload and releasing it counts it once
+	putRecord(t, ix, "main", "images", "a.png", "", 60)
+	first.Release()
+	first.Release()
+	third := ix.CheckQuotas(sm, "main", "images", "", 40)
+	if third.Rejection != "" {
+		t.Errorf("upload that fits rejected: %s", third.Rejection)
+	}
+
+	// A failed upload gives its space back
+	third.Release()
+	if fourth := ix.CheckQuotas(sm, "main", "images", "", 40); fourth.Rejection != "" {
+		t.Errorf("space of a released upload still held: %s", fourth.Rejection)
+	} else {
+		fourth.Release()
+	}
+	if len(ix.reserved) != 0 {
+		t.Errorf("reservations left after every release: %v", ix.reserved)
+	}
+}
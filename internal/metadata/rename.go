@@ -0,0 +1,72 @@
+package metadata
+
+// Rename moves the records of a renamed domain folder, or of a renamed
+// category folder of a domain when category is set, to their new keys.
+// Trashed records and tombstones move along. It returns how many live
+// records were moved.
+func (ix *Index) Rename(domainFolder, category, newName string) (int, error) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	matches := func(r Record) bool {
+		return r.Domain == domainFolder && (category == "" || r.Category == category)
+	}
+	renamed := func(r Record) Record {
+		if category == "" {
+			r.Domain = newName
+		} else {
+			r.Category = newName
+		}
+		return r
+	}
+	deleted := func(r Record) logEntry {
+		return logEntry{Deleted: true, Record: Record{Domain: r.Domain, Category: r.Category, Filename: r.Filename}}
+	}
+
+	moved := 0
+	for key, r := range ix.records {
+		if !matches(r) {
+			continue
+		}
+		if err := ix.append(deleted(r)); err != nil {
+			return moved, err
+		}
+		delete(ix.records, key)
+		ix.account(r, -1)
+		if err := ix.put(renamed(r)); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+
+	for key, r := range ix.trash {
+		if !matches(r) {
+			continue
+		}
+		if err := ix.append(deleted(r)); err != nil {
+			return moved, err
+		}
+		delete(ix.trash, key)
+		r = renamed(r)
+		if err := ix.append(logEntry{Trashed: true, Record: r}); err != nil {
+			return moved, err
+		}
+		ix.trash[r.key()] = r
+	}
+
+	// Tombstones can't be removed from the log, so the old ones stay until
+	// they age out
+	for key, expired := range ix.tombstones {
+		entry := tombstoneEntry(key, expired)
+		if !matches(entry.Record) {
+			continue
+		}
+		entry.Record = renamed(entry.Record)
+		if err := ix.append(entry); err != nil {
+			return moved, err
+		}
+		ix.tombstones[entry.key()] = expired
+	}
+
+	return moved, nil
+}
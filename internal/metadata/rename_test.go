@@ -0,0 +1,97 @@
+package metadata
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenameCategory(t *testing.T) {
+	ix := newTestIndex(t)
+	putRecord(t, ix, "main", "images", "a.png", "u1", 10)
+	putRecord(t, ix, "main", "images", "trashed.png", "u1", 20)
+	putRecord(t, ix, "main", "images", "expired.png", "u1", 30)
+	putRecord(t, ix, "blog", "images", "b.png", "u2", 40)
+	if err := ix.Trash("main", "images", "trashed.png"); err != nil {
+		t.Fatal(err)
+	}
+	expired := time.Now().Add(-time.Hour)
+	if err := ix.markExpired(Record{Domain: "main", Category: "images", Filename: "expired.png", ExpiresAt: &expired}); err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := ix.Rename("main", "images", "pictures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 1 {
+		t.Errorf("moved %d records, want 1", moved)
+	}
+	checkRenamed(t, ix, "main", "pictures", "main", "images")
+
+	// The same name in another domain is left alone
+	if _, ok := ix.Get("blog", "images", "b.png"); !ok {
+		t.Error("record of another domain moved")
+	}
+	if u := ix.UploaderUsage("u1"); u != (Usage{Bytes: 10, Files: 1}) {
+		t.Errorf("uploader usage = %+v", u)
+	}
+}
+
+func TestRenameDomain(t *testing.T) {
+	ix := newTestIndex(t)
+	putRecord(t, ix, "main", "images", "a.png", "u1", 10)
+	putRecord(t, ix, "main", "images", "trashed.png", "u1", 20)
+	putRecord(t, ix, "main", "images", "expired.png", "u1", 30)
+	if err := ix.Trash("main", "images", "trashed.png"); err != nil {
+		t.Fatal(err)
+	}
+	expired := time.Now().Add(-time.Hour)
+	if err := ix.markExpired(Record{Domain: "main", Category: "images", Filename: "expired.png", ExpiresAt: &expired}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ix.Rename("main", "", "cdn"); err != nil {
+		t.Fatal(err)
+	}
+	checkRenamed(t, ix, "cdn", "images", "main", "images")
+	if u := ix.DomainUsage("cdn"); u != (Usage{Bytes: 10, Files: 1}) {
+		t.Errorf("usage of the renamed domain = %+v", u)
+	}
+	if u := ix.DomainUsage("main"); u != (Usage{}) {
+		t.Errorf("usage left under the old domain = %+v", u)
+	}
+}
+
+// checkRenamed checks that the records, trash and tombstones set up by the
+// rename tests moved from oldDomain/oldCategory to domain/category, both in
+// memory and in the log.
+func checkRenamed(t *testing.T, ix *Index, domain, category, oldDomain, oldCategory string) {
+	t.Helper()
+	reopened, err := OpenReadOnly(ix.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, index := range map[string]*Index{"running": ix, "reloaded": reopened} {
+		if _, ok := index.Get(domain, category, "a.png"); !ok {
+			t.Errorf("%s: record not moved", name)
+		}
+		if _, ok := index.Get(oldDomain, oldCategory, "a.png"); ok {
+			t.Errorf("%s: record left under the old name", name)
+		}
+		if _, ok := index.Trashed(domain, category, "trashed.png"); !ok {
+			t.Errorf("%s: trashed record not moved", name)
+		}
+		if _, ok := index.Trashed(oldDomain, oldCategory, "trashed.png"); ok {
+			t.Errorf("%s: trashed record left under the old name", name)
+		}
+		if !index.Gone(domain, category, "expired.png", time.Now()) {
+			t.Errorf("%s: expired file not gone under the new name", name)
+		}
+		if u := index.CategoryUsage(domain, category); u != (Usage{Bytes: 10, Files: 1}) {
+			t.Errorf("%s: usage of the renamed category = %+v", name, u)
+		}
+		if u := index.CategoryUsage(oldDomain, oldCategory); u != (Usage{}) {
+			t.Errorf("%s: usage left under the old name = %+v", name, u)
+		}
+	}
+}
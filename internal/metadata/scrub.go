@@ -0,0 +1,162 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/vixa/cdn/internal/storage"
+)
+
+// Kinds of problems a scrub finds.
+const (
+	IssueChecksum      = "checksum-mismatch"
+	IssueEmpty         = "empty"
+	IssueUnreadable    = "unreadable"
+	IssueMissing       = "missing"
+	IssueUnknownFolder = "unknown-folder"
+)
+
+// ScrubIssue is one inconsistency found by a scrub. Unknown folders have no
+// filename, and no category when the whole domain folder is unknown.
+type ScrubIssue struct {
+	Kind        string
+	Domain      string
+	Category    string
+	Filename    string
+	Detail      string
+	Quarantined bool
+}
+
+func (i ScrubIssue) String() string {
+	where := i.Domain
+	for _, elem := range []string{i.Category, i.Filename} {
+		if elem != "" {
+			where += "/" + elem
+		}
+	}
+
+	s := fmt.Sprintf("%s %s", i.Kind, where)
+	if i.Detail != "" {
+		s += ": " + i.Detail
+	}
+	if i.Quarantined {
+		s += " (quarantined)"
+	}
+	return s
+}
+
+// ScrubReport is the outcome of a scrub.
+type ScrubReport struct {
+	Checked     int
+	Issues      []ScrubIssue
+	Quarantined int
+}
+
+// Scrub reads every file under the given category folders of each domain
+// folder and checks it against the checksum it is stored under and the one
+// recorded at upload. It reports empty and unreadable files, records of files
+// that are gone, and folders in storage that no configured domain or category
+// of that domain refers to. With quarantine set, damaged files are moved to the quarantine folder
+// and their records dropped; nothing else is changed.
+func (ix *Index) Scrub(stor *storage.Storage, folders map[string][]string, quarantine bool) (ScrubReport, error) {
+	var report ScrubReport
+	started := time.Now().Add(-time.Second)
+
+	stored, err := stor.Folders()
+	if err != nil {
+		return report, err
+	}
+	for _, domainFolder := range slices.Sorted(maps.Keys(stored)) {
+		categories, ok := folders[domainFolder]
+		if !ok {
+			report.Issues = append(report.Issues, ScrubIssue{Kind: IssueUnknownFolder, Domain: domainFolder, Detail: "not a configured domain"})
+			continue
+		}
+		for _, category := range stored[domainFolder] {
+			if !slices.Contains(categories, category) {
+				report.Issues = append(report.Issues, ScrubIssue{Kind: IssueUnknownFolder, Domain: domainFolder, Category: category, Detail: "not a category of this domain"})
+			}
+		}
+	}
+
+	for _, domainFolder := range slices.Sorted(maps.Keys(folders)) {
+		for _, category := range folders[domainFolder] {
+			files, err := stor.ListFiles(domainFolder, category)
+			if err != nil {
+				return report, err
+			}
+
+			present := make(map[string]bool, len(files))
+			for _, filename := range files {
+				present[filename] = true
+				issue, ok := ix.scrubFile(stor, domainFolder, category, filename)
+				if !ok {
+					continue
+				}
+				report.Checked++
+				if issue == nil {
+					continue
+				}
+
+				if quarantine {
+					if err := stor.QuarantineFile(domainFolder, category, filename); err != nil {
+						issue.Detail += fmt.Sprintf("; quarantine failed: %v", err)
+					} else {
+						issue.Quarantined = true
+						report.Quarantined++
+						if err := ix.Delete(domainFolder, category, filename); err != nil {
+							return report, err
+						}
+					}
+				}
+				report.Issues = append(report.Issues, *issue)
+			}
+
+			// Files uploaded after the listing are not missing
+			missing := ix.Find(func(r Record) bool {
+				return r.Domain == domainFolder && r.Category == category &&
+					!present[r.Filename] && r.UploadedAt.Before(started)
+			})
+			for _, r := range missing {
+				report.Issues = append(report.Issues, ScrubIssue{
+					Kind:     IssueMissing,
+					Domain:   r.Domain,
+					Category: r.Category,
+					Filename: r.Filename,
+					Detail:   "recorded but not in storage",
+				})
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// scrubFile checks one file. ok is false when the file was deleted while
+// the scrub ran.
+func (ix *Index) scrubFile(stor *storage.Storage, domainFolder, category, filename string) (issue *ScrubIssue, ok bool) {
+	newIssue := func(kind, detail string) *ScrubIssue {
+		return &ScrubIssue{Kind: kind, Domain: domainFolder, Category: category, Filename: filename, Detail: detail}
+	}
+
+	expected, actual, size, err := stor.VerifyFile(domainFolder, category, filename)
+	if errors.Is(err, storage.ErrFileNotFound) {
+		return nil, false
+	}
+	if err != nil {
+		return newIssue(IssueUnreadable, err.Error()), true
+	}
+	if size == 0 {
+		return newIssue(IssueEmpty, "file has no content"), true
+	}
+	if expected != "" && actual != expected {
+		return newIssue(IssueChecksum, fmt.Sprintf("stored as %.12s, content is %.12s", expected, actual)), true
+	}
+	if r, found := ix.Get(domainFolder, category, filename); found && r.SHA256 != "" && r.SHA256 != actual {
+		return newIssue(IssueChecksum, fmt.Sprintf("recorded %.12s, content is %.12s", r.SHA256, actual)), true
+	}
+	return nil, true
+}
@@ -0,0 +1,65 @@
+package metadata
+
+import (
+	"time"
+
+	"github.com/vixa/cdn/internal/storage"
+)
+
+// Trash sets aside the record of a file moved to the trash, so it no longer
+// counts towards usage but comes back if the file is restored.
+func (ix *Index) Trash(domainFolder, category, filename string) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	key := recordKey(domainFolder, category, filename)
+	r, ok := ix.records[key]
+	if !ok {
+		return nil
+	}
+
+	if err := ix.append(logEntry{Trashed: true, Record: r}); err != nil {
+		return err
+	}
+	delete(ix.records, key)
+	ix.account(r, -1)
+	ix.trash[key] = r
+	return nil
+}
+
+// Restore brings back the record of a file restored from the trash. It
+// reports false when the trashed file had no record.
+func (ix *Index) Restore(domainFolder, category, filename string) (Record, bool, error) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	r, ok := ix.trash[recordKey(domainFolder, category, filename)]
+	if !ok {
+		return Record{}, false, nil
+	}
+	if err := ix.put(r); err != nil {
+		return Record{}, false, err
+	}
+	return r, true, nil
+}
+
+// Trashed returns the record of a file in the trash.
+func (ix *Index) Trashed(domainFolder, category, filename string) (Record, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	r, ok := ix.trash[recordKey(domainFolder, category, filename)]
+	return r, ok
+}
+
+// PurgeTrash permanently deletes the files trashed before cutoff along with
+// their records, and returns how many were purged.
+func (ix *Index) PurgeTrash(stor *storage.Storage, cutoff time.Time) (int, error) {
+	purged, err := stor.PurgeTrash(cutoff)
+	for _, f := range purged {
+		if derr := ix.Delete(f.Domain, f.Category, f.Filename); derr != nil && err == nil {
+			err = derr
+		}
+	}
+	return len(purged), err
+}
@@ -0,0 +1,40 @@
+package metrics
+
+// Upload sources used as the "source" label.
+const (
+	SourceCommand = "command"
+	SourceAuto    = "auto"
+	SourceAPI     = "api"
+	// SourceExpiry labels deletions of files whose lifetime is over.
+	SourceExpiry = "expiry"
+)
+
+// Metrics shared by the bot and the HTTP API.
+var (
+	UploadsTotal = NewCounterVec(
+		"vixa_uploads_total",
+		"Files stored, by domain folder, category and upload source.",
+		"domain", "category", "source",
+	)
+	UploadBytes = NewHistogramVec(
+		"vixa_upload_size_bytes",
+		"Size of stored files in bytes, by upload source.",
+		SizeBuckets,
+		"source",
+	)
+	UploadFailuresTotal = NewCounterVec(
+		"vixa_upload_failures_total",
+		"Uploads that could not be stored, by upload source and stage.",
+		"source", "stage",
+	)
+	DeletesTotal = NewCounterVec(
+		"vixa_deletes_total",
+		"Files deleted, by domain folder, category and source.",
+		"domain", "category", "source",
+	)
+	DownloadFailuresTotal = NewCounterVec(
+		"vixa_download_failures_total",
+		"Failed attachment downloads, by reason.",
+		"reason",
+	)
+)
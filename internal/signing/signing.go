@@ -0,0 +1,135 @@
+// Package signing creates and verifies expiring HMAC-signed file URLs.
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vixa/cdn/internal/atomicfile"
+)
+
+// Query parameters carrying the signature.
+const (
+	ExpiresParam   = "expires"
+	SignatureParam = "sig"
+)
+
+// MaxLifetime is the longest lifetime a signed link may be minted with.
+const MaxLifetime = 365 * 24 * time.Hour
+
+var (
+	ErrMissingSignature = errors.New("missing signature")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrExpired          = errors.New("link has expired")
+)
+
+type Signer struct {
+	secret []byte
+}
+
+func NewSigner(secret []byte) *Signer {
+	return &Signer{secret: secret}
+}
+
+// LoadOrCreateSecret reads the signing secret from path, generating and
+// saving a random one if the file doesn't exist yet.
+func LoadOrCreateSecret(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		secret, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(secret) < 16 {
+			return nil, fmt.Errorf("invalid signing secret in %s", path)
+		}
+		return secret, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read signing secret: %w", err)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate signing secret: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create signing secret directory: %w", err)
+	}
+	if err := atomicfile.WriteFile(path, []byte(hex.EncodeToString(secret)+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write signing secret: %w", err)
+	}
+	return secret, nil
+}
+
+func (s *Signer) signature(domainFolder, category, filename string, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%d", domainFolder, category, filename, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignURL appends expires and sig parameters to a public file URL.
+func (s *Signer) SignURL(fileURL, domainFolder, category, filename string, expires time.Time) string {
+	exp := expires.Unix()
+	sep := "?"
+	if strings.Contains(fileURL, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%s%s=%d&%s=%s", fileURL, sep, ExpiresParam, exp, SignatureParam, s.signature(domainFolder, category, filename, exp))
+}
+
+// Verify checks the expires and sig parameters of a request for a file and
+// returns the expiry time when they are valid.
+func (s *Signer) Verify(domainFolder, category, filename, expiresParam, sigParam string, now time.Time) (time.Time, error) {
+	if expiresParam == "" || sigParam == "" {
+		return time.Time{}, ErrMissingSignature
+	}
+
+	exp, err := strconv.ParseInt(expiresParam, 10, 64)
+	if err != nil {
+		return time.Time{}, ErrInvalidSignature
+	}
+
+	expected := s.signature(domainFolder, category, filename, exp)
+	if !hmac.Equal([]byte(expected), []byte(sigParam)) {
+		return time.Time{}, ErrInvalidSignature
+	}
+
+	expires := time.Unix(exp, 0)
+	if !now.Before(expires) {
+		return time.Time{}, ErrExpired
+	}
+	return expires, nil
+}
+
+// ParseLifetime parses durations like "90m", "12h" or "7d".
+func ParseLifetime(s string) (time.Duration, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid lifetime '%s'", s)
+		}
+		return checkLifetime(time.Duration(n) * 24 * time.Hour)
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid lifetime '%s' (use e.g. 30m, 12h or 7d)", s)
+	}
+	return checkLifetime(d)
+}
+
+func checkLifetime(d time.Duration) (time.Duration, error) {
+	if d > MaxLifetime {
+		return 0, fmt.Errorf("lifetime must be at most %d days", int(MaxLifetime.Hours()/24))
+	}
+	return d, nil
+}
@@ -0,0 +1,126 @@
+package signing
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func signedParams(t *testing.T, signed string) (expires, sig string) {
+	t.Helper()
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query().Get(ExpiresParam), u.Query().Get(SignatureParam)
+}
+
+func TestSignAndVerify(t *testing.T) {
+	s := NewSigner([]byte("0123456789abcdef"))
+	now := time.Unix(1_700_000_000, 0)
+	expiresAt := now.Add(time.Hour)
+
+	signed := s.SignURL("https://cdn.example.com/private/a.png", "main", "private", "a.png", expiresAt)
+	exp, sig := signedParams(t, signed)
+
+	got, err := s.Verify("main", "private", "a.png", exp, sig, now)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !got.Equal(expiresAt) {
+		t.Errorf("expires = %v, want %v", got, expiresAt)
+	}
+
+	tests := []struct {
+		name                       string
+		domain, category, filename string
+		expires, sig               string
+		now                        time.Time
+		want                       error
+	}{
+		{"expired", "main", "private", "a.png", exp, sig, expiresAt, ErrExpired},
+		{"other file", "main", "private", "b.png", exp, sig, now, ErrInvalidSignature},
+		{"other category", "main", "public", "a.png", exp, sig, now, ErrInvalidSignature},
+		{"other domain", "other", "private", "a.png", exp, sig, now, ErrInvalidSignature},
+		{"extended expiry", "main", "private", "a.png", "1900000000", sig, now, ErrInvalidSignature},
+		{"malformed expiry", "main", "private", "a.png", "soon", sig, now, ErrInvalidSignature},
+		{"missing signature", "main", "private", "a.png", exp, "", now, ErrMissingSignature},
+		{"missing expiry", "main", "private", "a.png", "", sig, now, ErrMissingSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.Verify(tt.domain, tt.category, tt.filename, tt.expires, tt.sig, tt.now); !errors.Is(err, tt.want) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	other := NewSigner([]byte("fedcba9876543210"))
+	if _, err := other.Verify("main", "private", "a.png", exp, sig, now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify with another secret = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestSignURLKeepsQuery(t *testing.T) {
+	s := NewSigner([]byte("0123456789abcdef"))
+	signed := s.SignURL("https://cdn.example.com/private/a.png?w=100", "main", "private", "a.png", time.Unix(1_700_000_000, 0))
+
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Query().Get("w") != "100" || u.Query().Get(SignatureParam) == "" {
+		t.Errorf("signed URL %s lost its query or signature", signed)
+	}
+}
+
+func TestParseLifetime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"30m", 30 * time.Minute, true},
+		{"12h", 12 * time.Hour, true},
+		{"7d", 7 * 24 * time.Hour, true},
+		{" 2D ", 2 * 24 * time.Hour, true},
+		{"365d", MaxLifetime, true},
+		{"366d", 0, false},
+		{"0d", 0, false},
+		{"-1h", 0, false},
+		{"soon", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseLifetime(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseLifetime(%q) = %v, %v; want %v, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLoadOrCreateSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config", "signing.key")
+
+	secret, err := LoadOrCreateSecret(path)
+	if err != nil {
+		t.Fatalf("creating secret: %v", err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("secret is %d bytes, want 32", len(secret))
+	}
+	again, err := LoadOrCreateSecret(path)
+	if err != nil || string(again) != string(secret) {
+		t.Errorf("reloaded secret differs: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("abcd\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadOrCreateSecret(path); err == nil {
+		t.Error("a too short secret was accepted")
+	}
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"io"
+	"time"
+)
+
+// Backend stores objects under slash-separated keys such as
+// "domain/category/filename". Backends report missing objects with
+// ErrFileNotFound.
+type Backend interface {
+	// Put stores the contents of r under key, replacing any existing object.
+	// size is the number of bytes r will yield, or -1 if unknown. Readers
+	// never see a partially written object.
+	Put(key string, r io.Reader, size int64, contentType string) error
+	// Open opens an object for streaming. The caller must close the file.
+	Open(key string) (File, ObjectInfo, error)
+	Stat(key string) (ObjectInfo, error)
+	Delete(key string) error
+	// List returns every object whose key starts with prefix, at any depth.
+	List(prefix string) ([]ObjectInfo, error)
+	// Check verifies that the backend is reachable and writable.
+	Check() error
+}
+
+// File is a stored object opened for reading. It is seekable so it can be
+// served with http.ServeContent, which needs random access for Range
+// requests.
+type File interface {
+	io.Reader
+	io.Seeker
+	io.Closer
+}
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+	// ContentType is the type recorded when the object was stored, if the
+	// backend keeps one.
+	ContentType string
+}
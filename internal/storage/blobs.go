@@ -0,0 +1,309 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Files are stored once per content: the bytes live in a blob keyed by their
+// SHA-256, and each public domain/category/filename is a reference to it.
+//
+// A reference is a marker object whose key ends in the blob's hash,
+// .refs/<domain>/<category>/<filename>/<sha256>, so references can be
+// resolved and listed without reading any object. Each blob has a counter
+// object next to it; the blob is removed when the counter drops to zero.
+//
+// A marker is empty, and its modification time is when the file was
+// uploaded, unless it was moved after the upload, as by the trash: then it
+// holds the upload time in RFC 3339 format.
+//
+// Files stored before references existed stay at their plain
+// domain/category/filename key and are still served from there.
+const (
+	blobsDir = ".blobs"
+	refsDir  = ".refs"
+
+	refCountSuffix = ".refs"
+)
+
+func blobKey(hash string) string {
+	return objectKey(blobsDir, hash[:2], hash)
+}
+
+func refCountKey(hash string) string {
+	return blobKey(hash) + refCountSuffix
+}
+
+// refPrefix is the key prefix of the reference marker of a public file.
+func refPrefix(domainFolder, category, filename string) string {
+	return objectKey(refsDir, domainFolder, category, filename) + "/"
+}
+
+// blobHash returns the content hash of a blob key.
+func blobHash(key string) (string, bool) {
+	if !strings.HasPrefix(key, blobsDir+"/") {
+		return "", false
+	}
+	hash := key[strings.LastIndex(key, "/")+1:]
+	if len(hash) != 64 {
+		return "", false
+	}
+	return hash, true
+}
+
+// refEntry caches how a public file resolves, so serving it does not list
+// its reference prefix on every request. Files without a reference are
+// cached too, as ok false.
+type refEntry struct {
+	hash    string
+	ref     ObjectInfo
+	ok      bool
+	expires time.Time
+}
+
+const (
+	// refCacheTTL bounds how long a resolution is trusted, in case another
+	// process such as the scrub command changed the references.
+	refCacheTTL = time.Minute
+	// refCacheSize bounds the cache, which requests for missing files would
+	// otherwise grow without limit. It is emptied when full.
+	refCacheSize = 10000
+)
+
+// lookupRef returns the blob hash a public file refers to, along with the
+// reference marker's info. ok is false for files without a reference. The
+// caller must hold s.mu, which every change to a reference holds for
+// writing.
+func (s *Storage) lookupRef(domainFolder, category, filename string) (hash string, ref ObjectInfo, ok bool, err error) {
+	prefix := refPrefix(domainFolder, category, filename)
+
+	s.refMu.Lock()
+	entry, cached := s.refs[prefix]
+	s.refMu.Unlock()
+	if cached && time.Now().Before(entry.expires) {
+		return entry.hash, entry.ref, entry.ok, nil
+	}
+
+	refs, err := s.backend.List(prefix)
+	if err != nil {
+		return "", ObjectInfo{}, false, err
+	}
+	entry = refEntry{expires: time.Now().Add(refCacheTTL)}
+	for _, r := range refs {
+		hash := strings.TrimPrefix(r.Key, prefix)
+		if len(hash) == 64 && !strings.Contains(hash, "/") {
+			uploadedAt, err := s.markerUploadTime(r)
+			if err != nil {
+				return "", ObjectInfo{}, false, err
+			}
+			if !uploadedAt.IsZero() {
+				r.ModTime = uploadedAt
+			}
+			entry.hash, entry.ref, entry.ok = hash, r, true
+			break
+		}
+	}
+
+	s.refMu.Lock()
+	if len(s.refs) >= refCacheSize {
+		s.refs = make(map[string]refEntry)
+	}
+	s.refs[prefix] = entry
+	s.refMu.Unlock()
+
+	return entry.hash, entry.ref, entry.ok, nil
+}
+
+// markerUploadTime returns the upload time a marker holds, or the zero time
+// for an empty marker.
+func (s *Storage) markerUploadTime(marker ObjectInfo) (time.Time, error) {
+	if marker.Size == 0 {
+		return time.Time{}, nil
+	}
+	f, _, err := s.backend.Open(marker.Key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, 64))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read marker: %w", err)
+	}
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+	if err != nil {
+		// The marker's own time is still a usable upload time
+		fmt.Printf("[Storage] Ignoring invalid upload time in %s: %v\n", marker.Key, err)
+		return time.Time{}, nil
+	}
+	return t, nil
+}
+
+// markerContent returns the content of a marker holding uploadedAt.
+func markerContent(uploadedAt time.Time) []byte {
+	if uploadedAt.IsZero() {
+		return nil
+	}
+	return []byte(uploadedAt.UTC().Format(time.RFC3339Nano))
+}
+
+// forgetMarker drops the cached resolution of the file a marker object
+// belongs to. It must be called whenever a marker is created or deleted.
+func (s *Storage) forgetMarker(key string) {
+	s.refMu.Lock()
+	delete(s.refs, path.Dir(key)+"/")
+	s.refMu.Unlock()
+}
+
+// forgetRefs drops every cached resolution.
+func (s *Storage) forgetRefs() {
+	s.refMu.Lock()
+	s.refs = make(map[string]refEntry)
+	s.refMu.Unlock()
+}
+
+// addRef stores content under hash unless an identical blob already exists,
+// then records a reference to it from the public file. The caller must hold
+// s.mu for writing.
+func (s *Storage) addRef(domainFolder, category, filename, hash string, content io.Reader, size int64, contentType string) error {
+	return s.addMarker(refPrefix(domainFolder, category, filename)+hash, hash, content, size, contentType, time.Time{})
+}
+
+// addMarker stores content under hash unless an identical blob already
+// exists, then creates the marker object key referring to it, holding
+// uploadedAt unless it is zero. The caller must hold s.mu for writing.
+func (s *Storage) addMarker(key, hash string, content io.Reader, size int64, contentType string, uploadedAt time.Time) error {
+	if _, err := s.backend.Stat(blobKey(hash)); err != nil {
+		if !errors.Is(err, ErrFileNotFound) {
+			return err
+		}
+		if err := s.putBlob(hash, content, size, contentType); err != nil {
+			return err
+		}
+	}
+
+	// Count the reference before creating it: a crash in between leaks the
+	// blob instead of letting a later delete reclaim it while still in use
+	count, err := s.refCount(hash)
+	if err != nil {
+		return err
+	}
+	if err := s.setRefCount(hash, count+1); err != nil {
+		return err
+	}
+
+	s.forgetMarker(key)
+	body := markerContent(uploadedAt)
+	if err := s.backend.Put(key, bytes.NewReader(body), int64(len(body)), ""); err != nil {
+		s.releaseBlob(hash)
+		return err
+	}
+	return nil
+}
+
+// putBlob stores content as the blob hash. An upload spooled under the root
+// of a local backend is moved into place rather than copied. The caller
+// must hold s.mu for writing.
+func (s *Storage) putBlob(hash string, content io.Reader, size int64, contentType string) error {
+	if u, ok := content.(*Upload); ok && !u.moved {
+		if m, ok := s.backend.(fileMover); ok {
+			err := m.MoveFile(blobKey(hash), u.file.Name())
+			if err == nil {
+				u.moved = true
+				return nil
+			}
+			if _, serr := os.Stat(u.file.Name()); serr != nil {
+				// Moved, but not flushed to disk
+				u.moved = true
+				return err
+			}
+			// Spooled where it can't be renamed from, so copy it
+		}
+	}
+	return s.backend.Put(blobKey(hash), content, size, contentType)
+}
+
+// moveMarker replaces the marker object from with to, both referring to the
+// blob hash; to holds uploadedAt unless it is zero. The blob is counted once more while both markers exist, so a
+// crash part way leaks it rather than leaving a marker to a freed blob. The
+// caller must hold s.mu for writing.
+func (s *Storage) moveMarker(from, to, hash string, uploadedAt time.Time) error {
+	count, err := s.refCount(hash)
+	if err != nil {
+		return err
+	}
+	if err := s.setRefCount(hash, count+1); err != nil {
+		return err
+	}
+
+	s.forgetMarker(from)
+	s.forgetMarker(to)
+	body := markerContent(uploadedAt)
+	if err := s.backend.Put(to, bytes.NewReader(body), int64(len(body)), ""); err != nil {
+		s.releaseBlob(hash)
+		return err
+	}
+	if err := s.backend.Delete(from); err != nil && !errors.Is(err, ErrFileNotFound) {
+		s.backend.Delete(to)
+		s.releaseBlob(hash)
+		return err
+	}
+	return s.releaseBlob(hash)
+}
+
+// releaseBlob drops one reference to a blob and removes the blob once no
+// references are left. The caller must hold s.mu for writing.
+func (s *Storage) releaseBlob(hash string) error {
+	count, err := s.refCount(hash)
+	if err != nil {
+		return err
+	}
+
+	if count > 1 {
+		return s.setRefCount(hash, count-1)
+	}
+
+	if err := s.backend.Delete(blobKey(hash)); err != nil && !errors.Is(err, ErrFileNotFound) {
+		return err
+	}
+	if err := s.backend.Delete(refCountKey(hash)); err != nil && !errors.Is(err, ErrFileNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (s *Storage) refCount(hash string) (int, error) {
+	f, _, err := s.backend.Open(refCountKey(hash))
+	if err != nil {
+		if errors.Is(err, ErrFileNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, 32))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read reference count: %w", err)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid reference count for blob %s: %w", hash, err)
+	}
+	return count, nil
+}
+
+func (s *Storage) setRefCount(hash string, count int) error {
+	data := []byte(strconv.Itoa(count))
+	if err := s.backend.Put(refCountKey(hash), bytes.NewReader(data), int64(len(data)), "text/plain"); err != nil {
+		return fmt.Errorf("failed to update reference count: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func hashOf(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func storeString(t *testing.T, s *Storage, domainFolder, category, name, content string) {
+	t.Helper()
+	if _, err := s.StoreFile(domainFolder, category, spoolString(t, s, content), NameRequest{Name: name}); err != nil {
+		t.Fatalf("StoreFile %s: %v", name, err)
+	}
+}
+
+func readString(t *testing.T, s *Storage, domainFolder, category, name string) (string, bool) {
+	t.Helper()
+	f, _, _, err := s.OpenFile(domainFolder, category, name)
+	if err != nil {
+		t.Fatalf("OpenFile %s: %v", name, err)
+	}
+	if f == nil {
+		return "", false
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), true
+}
+
+func assertRefCount(t *testing.T, s *Storage, hash string, want int) {
+	t.Helper()
+	got, err := s.refCount(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("reference count = %d, want %d", got, want)
+	}
+	_, err = s.backend.Stat(blobKey(hash))
+	if exists := err == nil; exists != (want > 0) {
+		t.Errorf("blob exists = %v with %d references", exists, want)
+	}
+}
+
+func TestIdenticalFilesShareABlob(t *testing.T) {
+	s := newTestStorage(t)
+	hash := hashOf("same bytes")
+
+	storeString(t, s, "main", "images", "a.txt", "same bytes")
+	storeString(t, s, "main", "docs", "b.txt", "same bytes")
+	storeString(t, s, "other", "images", "c.txt", "same bytes")
+	assertRefCount(t, s, hash, 3)
+
+	for _, f := range []struct{ domain, category, name string }{
+		{"main", "images", "a.txt"}, {"main", "docs", "b.txt"}, {"other", "images", "c.txt"},
+	} {
+		if got, ok := readString(t, s, f.domain, f.category, f.name); !ok || got != "same bytes" {
+			t.Errorf("%s/%s/%s = %q, %v", f.domain, f.category, f.name, got, ok)
+		}
+	}
+
+	if err := s.DeleteFile("main", "images", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	assertRefCount(t, s, hash, 2)
+	if _, ok := readString(t, s, "main", "images", "a.txt"); ok {
+		t.Error("deleted file still served")
+	}
+	if got, ok := readString(t, s, "main", "docs", "b.txt"); !ok || got != "same bytes" {
+		t.Errorf("sibling reference broken by delete: %q, %v", got, ok)
+	}
+
+	if err := s.DeleteFile("main", "docs", "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteFile("other", "images", "c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	assertRefCount(t, s, hash, 0)
+}
+
+func TestTrashAndRestoreKeepBlobCounted(t *testing.T) {
+	s := newTestStorage(t)
+	hash := hashOf("trashed")
+
+	storeString(t, s, "main", "images", "a.txt", "trashed")
+	if err := s.TrashFile("main", "images", "a.txt"); err != nil {
+		t.Fatalf("TrashFile: %v", err)
+	}
+	assertRefCount(t, s, hash, 1)
+	if _, ok := readString(t, s, "main", "images", "a.txt"); ok {
+		t.Error("trashed file still served")
+	}
+
+	if err := s.RestoreFile("main", "images", "a.txt"); err != nil {
+		t.Fatalf("RestoreFile: %v", err)
+	}
+	assertRefCount(t, s, hash, 1)
+	if got, ok := readString(t, s, "main", "images", "a.txt"); !ok || got != "trashed" {
+		t.Errorf("restored file = %q, %v", got, ok)
+	}
+
+	if err := s.TrashFile("main", "images", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PurgeTrash(time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("PurgeTrash: %v", err)
+	}
+	assertRefCount(t, s, hash, 0)
+	if err := s.RestoreFile("main", "images", "a.txt"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("RestoreFile after purge = %v, want ErrFileNotFound", err)
+	}
+}
+
+func TestRestoreKeepsUploadTime(t *testing.T) {
+	s := newTestStorage(t)
+	storeString(t, s, "main", "images", "a.txt", "old upload")
+
+	// Back-date the upload so a reset time would show
+	uploadedAt := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	marker := refPrefix("main", "images", "a.txt") + hashOf("old upload")
+	if err := os.Chtimes(s.backend.(*FSBackend).path(marker), uploadedAt, uploadedAt); err != nil {
+		t.Fatal(err)
+	}
+	s.forgetRefs()
+
+	open := func() (ObjectInfo, string) {
+		t.Helper()
+		f, info, etag, err := s.OpenFile("main", "images", "a.txt")
+		if err != nil || f == nil {
+			t.Fatalf("OpenFile: %v", err)
+		}
+		f.Close()
+		return info, etag
+	}
+	before, etag := open()
+	if !before.ModTime.Equal(uploadedAt) {
+		t.Fatalf("ModTime = %v, want %v", before.ModTime, uploadedAt)
+	}
+
+	if err := s.TrashFile("main", "images", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	trashed, err := s.ListTrash("main")
+	if err != nil || len(trashed) != 1 {
+		t.Fatalf("ListTrash = %v, %v", trashed, err)
+	}
+	if trashed[0].TrashedAt.Before(time.Now().Add(-time.Minute)) {
+		t.Errorf("TrashedAt = %v, want the time of trashing", trashed[0].TrashedAt)
+	}
+
+	if err := s.RestoreFile("main", "images", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	after, restoredETag := open()
+	if !after.ModTime.Equal(uploadedAt) {
+		t.Errorf("ModTime after restore = %v, want %v", after.ModTime, uploadedAt)
+	}
+	if restoredETag != etag {
+		t.Errorf("ETag after restore = %q, want %q", restoredETag, etag)
+	}
+}
+
+func TestTrashedNameStaysTaken(t *testing.T) {
+	s := newTestStorage(t)
+	storeString(t, s, "main", "images", "a.txt", "older")
+	if err := s.TrashFile("main", "images", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The name is kept for a restore rather than handed to a new upload
+	_, err := s.StoreFile("main", "images", spoolString(t, s, "newer"), NameRequest{Name: "a.txt"})
+	if !errors.Is(err, ErrFileExists) {
+		t.Errorf("StoreFile over a trashed name = %v, want ErrFileExists", err)
+	}
+	assertRefCount(t, s, hashOf("newer"), 0)
+}
+
+func TestRefCacheFollowsChanges(t *testing.T) {
+	s := newTestStorage(t)
+
+	// A miss is cached, and must not hide a file stored afterwards
+	if _, ok := readString(t, s, "main", "images", "a.txt"); ok {
+		t.Fatal("file served before it was stored")
+	}
+	storeString(t, s, "main", "images", "a.txt", "first")
+	if got, ok := readString(t, s, "main", "images", "a.txt"); !ok || got != "first" {
+		t.Fatalf("stored file = %q, %v", got, ok)
+	}
+
+	if err := s.DeleteFile("main", "images", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	storeString(t, s, "main", "images", "a.txt", "second")
+	if got, ok := readString(t, s, "main", "images", "a.txt"); !ok || got != "second" {
+		t.Errorf("replaced file = %q, %v", got, ok)
+	}
+
+	if err := s.RenameFolder("main", "images", "pics", func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := readString(t, s, "main", "images", "a.txt"); ok {
+		t.Error("file still served under its old folder")
+	}
+	if got, ok := readString(t, s, "main", "pics", "a.txt"); !ok || got != "second" {
+		t.Errorf("renamed file = %q, %v", got, ok)
+	}
+}
+
+func TestVariantOfDeletedFileIsDropped(t *testing.T) {
+	s := newTestStorage(t)
+	storeString(t, s, "main", "images", "a.txt", "content")
+
+	if err := s.StoreVariant("main", "images", "a.txt", "v1", []byte("variant")); err != nil {
+		t.Fatalf("StoreVariant: %v", err)
+	}
+	if n := s.CountVariants("main", "images", "a.txt"); n != 1 {
+		t.Errorf("CountVariants = %d, want 1", n)
+	}
+
+	if err := s.DeleteFile("main", "images", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.CountVariants("main", "images", "a.txt"); n != 0 {
+		t.Errorf("CountVariants after delete = %d, want 0", n)
+	}
+	if err := s.StoreVariant("main", "images", "a.txt", "v2", []byte("late")); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("StoreVariant after delete = %v, want ErrFileNotFound", err)
+	}
+	if f, _, _ := s.OpenVariant("main", "images", "a.txt", "v2"); f != nil {
+		f.Close()
+		t.Error("variant stored for a deleted file")
+	}
+}
+
+func TestSidecarIsResolvedThroughItsReference(t *testing.T) {
+	s := newTestStorage(t)
+	storeString(t, s, "main", "docs", "x.js", "console.log(1)")
+	storeString(t, s, "main", "docs", "x.js.br", "brotli bytes")
+
+	f, info, err := s.OpenSidecar("main", "docs", "x.js", ".br")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f == nil {
+		t.Fatal("sidecar not found")
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "brotli bytes" {
+		t.Errorf("sidecar = %q", data)
+	}
+	if hash, ok := blobHash(info.Key); !ok || hash != hashOf("brotli bytes") {
+		t.Errorf("sidecar key = %q", info.Key)
+	}
+
+	f, _, err = s.OpenSidecar("main", "docs", "x.js", ".gz")
+	if err != nil || f != nil {
+		t.Errorf("missing sidecar = %v, %v", f, err)
+	}
+}
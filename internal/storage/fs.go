@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vixa/cdn/internal/atomicfile"
+)
+
+// FSBackend stores objects as files under a root directory, one directory
+// level per key segment.
+type FSBackend struct {
+	root string
+}
+
+func NewFSBackend(root string) (*FSBackend, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &FSBackend{root: filepath.Clean(root)}, nil
+}
+
+func (b *FSBackend) path(key string) string {
+	return filepath.Join(b.root, filepath.FromSlash(key))
+}
+
+// Put writes to a temporary file in the target directory, flushes it and
+// renames it into place, so readers see either the old or the new contents
+// and a crash never leaves a truncated file behind.
+func (b *FSBackend) Put(key string, r io.Reader, size int64, contentType string) error {
+	path := b.path(key)
+	if err := b.mkdirAll(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	if err := atomicfile.Write(path, r, 0644); err != nil {
+		return fmt.Errorf("failed to store file: %w", err)
+	}
+	return nil
+}
+
+// mkdirAll creates dir and any missing parents, flushing each parent that
+// gained an entry so the new directories survive a crash.
+func (b *FSBackend) mkdirAll(dir string) error {
+	var missing []string
+	for d := dir; d != b.root && strings.HasPrefix(d, b.root); d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		}
+		missing = append(missing, d)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, d := range missing {
+		if err := atomicfile.SyncDir(filepath.Dir(d)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *FSBackend) Open(key string) (File, ObjectInfo, error) {
+	f, err := os.Open(b.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ObjectInfo{}, ErrFileNotFound
+		}
+		return nil, ObjectInfo{}, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, ObjectInfo{}, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, ObjectInfo{}, ErrFileNotFound
+	}
+
+	return f, fileObjectInfo(key, info), nil
+}
+
+func (b *FSBackend) Stat(key string) (ObjectInfo, error) {
+	info, err := os.Stat(b.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ObjectInfo{}, ErrFileNotFound
+		}
+		return ObjectInfo{}, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if info.IsDir() {
+		return ObjectInfo{}, ErrFileNotFound
+	}
+	return fileObjectInfo(key, info), nil
+}
+
+// Delete removes the file and any directories the removal leaves empty.
+func (b *FSBackend) Delete(key string) error {
+	path := b.path(key)
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return ErrFileNotFound
+		}
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+
+	// Stop at the first directory that still has entries; domain and
+	// category folders are normally kept alive by their other files.
+	for dir := filepath.Dir(path); dir != b.root && strings.HasPrefix(dir, b.root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (b *FSBackend) List(prefix string) ([]ObjectInfo, error) {
+	// Walk the deepest directory the prefix names completely
+	dirKey := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dirKey = prefix[:i]
+	}
+	start := b.path(dirKey)
+
+	var objects []ObjectInfo
+	err := filepath.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == start {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), atomicfile.TempPrefix) {
+			// Writes and uploads in progress
+			return nil
+		}
+
+		rel, err := filepath.Rel(b.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			// Removed while walking
+			return nil
+		}
+		objects = append(objects, fileObjectInfo(key, info))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+	return objects, nil
+}
+
+// RenamePrefix moves every object under from/ to to/ by renaming the
+// directory. It fails if to already holds files.
+func (b *FSBackend) RenamePrefix(from, to string) error {
+	src, dst := b.path(from), b.path(to)
+	if _, err := os.Stat(src); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to stat directory: %w", err)
+	}
+
+	if err := b.mkdirAll(filepath.Dir(dst)); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	// An empty directory left behind by earlier deletes is no obstacle;
+	// os.Remove fails on anything else
+	os.Remove(dst)
+	if err := os.Rename(src, dst); err != nil {
+		return fmt.Errorf("failed to rename directory: %w", err)
+	}
+
+	if err := atomicfile.SyncDir(filepath.Dir(dst)); err != nil {
+		return err
+	}
+	if filepath.Dir(src) != filepath.Dir(dst) {
+		return atomicfile.SyncDir(filepath.Dir(src))
+	}
+	return nil
+}
+
+// Check verifies that files can be created in the storage root.
+func (b *FSBackend) Check() error {
+	f, err := os.CreateTemp(b.root, atomicfile.TempPrefix+"healthcheck-*")
+	if err != nil {
+		return fmt.Errorf("storage is not writable: %w", err)
+	}
+	name := f.Name()
+	f.Close()
+
+	if err := os.Remove(name); err != nil {
+		return fmt.Errorf("failed to remove health check file: %w", err)
+	}
+	return nil
+}
+
+// SpoolDir returns the directory uploads are spooled to before they are
+// stored.
+func (b *FSBackend) SpoolDir() string {
+	return b.root
+}
+
+// MoveFile stores the file at path under key by renaming it, so a spooled
+// upload is not copied a second time. The file is flushed first, so it
+// survives a crash like an object written by Put. path must be on the same
+// filesystem as the root.
+func (b *FSBackend) MoveFile(key, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	err = f.Chmod(0644)
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to flush file: %w", err)
+	}
+
+	target := b.path(key)
+	if err := b.mkdirAll(filepath.Dir(target)); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.Rename(path, target); err != nil {
+		return fmt.Errorf("failed to store file: %w", err)
+	}
+	return atomicfile.SyncDir(filepath.Dir(target))
+}
+
+// RemoveTempFiles deletes temporary files left anywhere under the root by
+// writes that were interrupted by a crash, returning their keys.
+func (b *FSBackend) RemoveTempFiles() ([]string, error) {
+	paths, err := atomicfile.Cleanup(b.root, true)
+	keys := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if rel, err := filepath.Rel(b.root, p); err == nil {
+			keys = append(keys, filepath.ToSlash(rel))
+		}
+	}
+	return keys, err
+}
+
+func fileObjectInfo(key string, info fs.FileInfo) ObjectInfo {
+	return ObjectInfo{
+		Key:     key,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vixa/cdn/internal/atomicfile"
+	"github.com/vixa/cdn/internal/metrics"
+)
+
+// ErrTooLarge is returned when an upload is larger than the allowed size.
+var ErrTooLarge = errors.New("file is too large")
+
+// sniffLen is how many leading bytes http.DetectContentType looks at.
+const sniffLen = 512
+
+// Upload is a received file spooled to a temporary file on disk, so it
+// never has to fit in memory. The caller must close it, which removes the
+// temporary file.
+//
+// Backends that keep files on local disk spool under their root, named
+// like the temporary files of interrupted writes so RemoveTempFiles clears
+// any a crash left behind. Other backends spool to the system's temporary
+// directory.
+type Upload struct {
+	file *os.File
+	// moved is set once the temporary file became a stored object
+	moved bool
+
+	Size        int64
+	SHA256      string
+	ContentType string
+}
+
+// spoolDirer is implemented by backends that keep files on local disk.
+type spoolDirer interface {
+	SpoolDir() string
+}
+
+// fileMover is implemented by backends that can take over a file spooled
+// to their SpoolDir without copying it.
+type fileMover interface {
+	MoveFile(key, path string) error
+}
+
+// Spool copies r to a temporary file, hashing it on the way. It fails with
+// ErrTooLarge as soon as more than maxSize bytes arrive; a maxSize of zero
+// or less means no limit. When contentType is empty or generic, the type is
+// sniffed from the first bytes. Parameters such as charset are dropped from
+// the type.
+func (s *Storage) Spool(r io.Reader, maxSize int64, contentType string) (*Upload, error) {
+	dir := ""
+	if d, ok := s.backend.(spoolDirer); ok {
+		dir = d.SpoolDir()
+	}
+	f, err := os.CreateTemp(dir, atomicfile.TempPrefix+"upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to buffer upload: %w", err)
+	}
+	u := &Upload{file: f}
+
+	if maxSize > 0 {
+		// One byte past the limit tells a file of exactly maxSize from a
+		// larger one
+		r = io.LimitReader(r, maxSize+1)
+	}
+	hash := sha256.New()
+	head := &headWriter{limit: sniffLen}
+	n, err := io.Copy(io.MultiWriter(f, hash, head), r)
+	if err != nil {
+		u.Close()
+		return nil, err
+	}
+	if maxSize > 0 && n > maxSize {
+		u.Close()
+		return nil, ErrTooLarge
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		u.Close()
+		return nil, fmt.Errorf("failed to buffer upload: %w", err)
+	}
+
+	contentType = baseContentType(contentType)
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = baseContentType(http.DetectContentType(head.buf))
+	}
+
+	u.Size = n
+	u.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	u.ContentType = contentType
+	return u, nil
+}
+
+func (u *Upload) Read(p []byte) (int, error) {
+	return u.file.Read(p)
+}
+
+func (u *Upload) Seek(offset int64, whence int) (int64, error) {
+	return u.file.Seek(offset, whence)
+}
+
+// Close removes the temporary file, unless it was moved into storage.
+func (u *Upload) Close() error {
+	err := u.file.Close()
+	if u.moved {
+		return err
+	}
+	if rerr := os.Remove(u.file.Name()); rerr != nil && err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// baseContentType strips parameters such as charset from a content type.
+func baseContentType(contentType string) string {
+	if idx := strings.Index(contentType, ";"); idx != -1 {
+		contentType = contentType[:idx]
+	}
+	return strings.TrimSpace(contentType)
+}
+
+// headWriter keeps the first limit bytes written to it.
+type headWriter struct {
+	buf   []byte
+	limit int
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if room := w.limit - len(w.buf); room > 0 {
+		w.buf = append(w.buf, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// downloadClient fetches Discord attachments.
+var downloadClient = &http.Client{Timeout: DefaultDownloadTimeout}
+
+// DefaultDownloadTimeout bounds a whole attachment download, including
+// reading the body.
+const DefaultDownloadTimeout = 10 * time.Minute
+
+// SetDownloadTimeout sets how long DownloadFile waits for a download to
+// finish. Zero means no limit. It must be called before any download starts.
+func SetDownloadTimeout(timeout time.Duration) {
+	downloadClient.Timeout = timeout
+}
+
+// DownloadFile streams the file at url to a temporary file, as Spool does.
+// Downloads larger than maxSize are refused up front when the server
+// announces their length, and cut off otherwise; a maxSize of zero or less
+// means no limit.
+func (s *Storage) DownloadFile(url string, maxSize int64) (*Upload, error) {
+	resp, err := downloadClient.Get(url)
+	if err != nil {
+		metrics.DownloadFailuresTotal.Inc("request")
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		metrics.DownloadFailuresTotal.Inc("status")
+		return nil, fmt.Errorf("download failed with status: %d", resp.StatusCode)
+	}
+
+	if maxSize > 0 && resp.ContentLength > maxSize {
+		metrics.DownloadFailuresTotal.Inc("too_large")
+		return nil, ErrTooLarge
+	}
+
+	upload, err := s.Spool(resp.Body, maxSize, resp.Header.Get("Content-Type"))
+	if err != nil {
+		if errors.Is(err, ErrTooLarge) {
+			metrics.DownloadFailuresTotal.Inc("too_large")
+			return nil, err
+		}
+		metrics.DownloadFailuresTotal.Inc("read")
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	return upload, nil
+}
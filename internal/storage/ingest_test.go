@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSpoolUnderStorageRoot(t *testing.T) {
+	root := t.TempDir()
+	s, err := NewStorage(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload, err := s.Spool(strings.NewReader("spooled"), 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spooled, _ := filepath.Glob(filepath.Join(root, ".tmp-*"))
+	if len(spooled) != 1 {
+		t.Fatalf("temporary files under the root = %v, want one", spooled)
+	}
+
+	// Listings never show an upload in progress
+	objects, err := s.backend.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 0 {
+		t.Errorf("List = %v, want nothing", objects)
+	}
+
+	if err := upload.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(spooled[0]); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temporary file left after Close: %v", err)
+	}
+
+	// One a crash left behind is cleaned up
+	if _, err := s.Spool(strings.NewReader("abandoned"), 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := s.RemoveTempFiles()
+	if err != nil || len(removed) != 1 {
+		t.Errorf("RemoveTempFiles = %v, %v; want the abandoned upload", removed, err)
+	}
+}
+
+func TestSpoolContentType(t *testing.T) {
+	s := newTestStorage(t)
+	tests := []struct {
+		content, contentType, want string
+	}{
+		{"plain words", "", "text/plain"},
+		{"<html><body>hi</body></html>", "application/octet-stream", "text/html"},
+		{"body{}", "text/css; charset=utf-8", "text/css"},
+		{"\x89PNG\r\n\x1a\n", "", "image/png"},
+		{"not really", "image/webp", "image/webp"},
+	}
+	for _, tt := range tests {
+		upload, err := s.Spool(strings.NewReader(tt.content), 0, tt.contentType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		upload.Close()
+		if upload.ContentType != tt.want {
+			t.Errorf("Spool(%q, %q) type = %q, want %q", tt.content, tt.contentType, upload.ContentType, tt.want)
+		}
+	}
+}
+
+func TestSpoolTooLarge(t *testing.T) {
+	s := newTestStorage(t)
+	if upload, err := s.Spool(strings.NewReader("12345"), 5, ""); err != nil {
+		t.Errorf("file of exactly the limit: %v", err)
+	} else {
+		upload.Close()
+		if upload.Size != 5 || upload.SHA256 != hashOf("12345") {
+			t.Errorf("Size = %d, SHA256 = %s", upload.Size, upload.SHA256)
+		}
+	}
+	if _, err := s.Spool(strings.NewReader("123456"), 5, ""); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("file over the limit: %v, want ErrTooLarge", err)
+	}
+}
+
+func TestStoreMovesSpooledUpload(t *testing.T) {
+	root := t.TempDir()
+	s, err := NewStorage(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload, err := s.Spool(strings.NewReader("moved, not copied"), 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spooled, err := os.Stat(upload.file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.StoreFile("main", "docs", upload, NameRequest{Name: "a.txt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	blob, err := os.Stat(filepath.Join(root, filepath.FromSlash(blobKey(upload.SHA256))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(spooled, blob) {
+		t.Error("blob is a copy of the spooled upload")
+	}
+	if blob.Mode().Perm() != 0644 {
+		t.Errorf("blob mode = %v", blob.Mode())
+	}
+	if err := upload.Close(); err != nil {
+		t.Errorf("Close after the upload was moved: %v", err)
+	}
+	if got, ok := readString(t, s, "main", "docs", "a.txt"); !ok || got != "moved, not copied" {
+		t.Errorf("stored file = %q, %v", got, ok)
+	}
+
+	// A second upload of the same content is dropped with its spool file
+	again, err := s.Spool(strings.NewReader("moved, not copied"), 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.StoreFile("main", "docs", again, NameRequest{Name: "b.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := again.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := filepath.Glob(filepath.Join(root, ".tmp-*")); len(left) != 0 {
+		t.Errorf("temporary files left: %v", left)
+	}
+	assertRefCount(t, s, upload.SHA256, 2)
+}
@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Naming strategies for new files.
+const (
+	NamingUUID   = "uuid"
+	NamingBase62 = "base62"
+	NamingSlug   = "slug"
+	NamingHash   = "hash"
+)
+
+// NamingStrategies lists the strategies in the order they are offered.
+var NamingStrategies = []string{NamingUUID, NamingBase62, NamingSlug, NamingHash}
+
+// Default and maximum lengths of generated names. For base62 the length is
+// that of the whole ID, for slug that of the random suffix and for hash that
+// of the hash prefix.
+var (
+	defaultNamingLength = map[string]int{NamingBase62: 8, NamingSlug: 6, NamingHash: 12}
+	maxNamingLength     = map[string]int{NamingBase62: 32, NamingSlug: 16, NamingHash: 64}
+)
+
+const (
+	// minNamingLength keeps generated names from being trivially guessable.
+	minNamingLength = 4
+	// maxNameAttempts bounds the retries when a generated name is taken.
+	maxNameAttempts = 10
+	// maxSlugLength bounds the part of a slug taken from the original name.
+	maxSlugLength = 48
+	// maxFilenameLength bounds user-provided names.
+	maxFilenameLength = 128
+)
+
+// ErrInvalidName is returned for user-provided names that cannot be used in
+// a URL.
+var ErrInvalidName = errors.New("invalid file name")
+
+const base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// Naming is how a category names new files. The zero value names them with a
+// UUIDv4.
+type Naming struct {
+	Strategy string
+	// Length is the length of the generated part of the name; zero uses the
+	// strategy's default. It is ignored by uuid.
+	Length int
+}
+
+// ParseNaming parses a strategy with an optional length, such as "base62"
+// or "base62:10".
+func ParseNaming(s string) (Naming, error) {
+	strategy, lengthStr, hasLength := strings.Cut(strings.ToLower(strings.TrimSpace(s)), ":")
+	n := Naming{Strategy: strategy}
+	if hasLength {
+		length, err := strconv.Atoi(lengthStr)
+		if err != nil {
+			return Naming{}, fmt.Errorf("invalid length %q", lengthStr)
+		}
+		n.Length = length
+	}
+	if err := n.Validate(); err != nil {
+		return Naming{}, err
+	}
+	return n, nil
+}
+
+// Validate reports whether the strategy is known and its length in range.
+func (n Naming) Validate() error {
+	switch n.Strategy {
+	case "", NamingUUID:
+		if n.Length != 0 {
+			return fmt.Errorf("the %s strategy has no length", NamingUUID)
+		}
+		return nil
+	case NamingBase62, NamingSlug, NamingHash:
+	default:
+		return fmt.Errorf("unknown naming strategy %q (expected one of %s)", n.Strategy, strings.Join(NamingStrategies, ", "))
+	}
+
+	if n.Length != 0 && (n.Length < minNamingLength || n.Length > maxNamingLength[n.Strategy]) {
+		return fmt.Errorf("length for %s must be between %d and %d", n.Strategy, minNamingLength, maxNamingLength[n.Strategy])
+	}
+	return nil
+}
+
+// String formats the strategy the way ParseNaming reads it.
+func (n Naming) String() string {
+	if n.Strategy == "" {
+		return NamingUUID
+	}
+	if n.Length == 0 {
+		return n.Strategy
+	}
+	return fmt.Sprintf("%s:%d", n.Strategy, n.Length)
+}
+
+func (n Naming) length() int {
+	if n.Length > 0 {
+		return n.Length
+	}
+	return defaultNamingLength[n.Strategy]
+}
+
+// NameRequest describes the file being named.
+type NameRequest struct {
+	Naming Naming
+	// OriginalName is the name the file was uploaded with. Its extension is
+	// kept and slugs are made from the rest.
+	OriginalName string
+	// Name, if set, is used as is instead of generating one. The original
+	// extension is added when it has none.
+	Name string
+}
+
+// generate returns a candidate name for the attempt'th try, starting at 0.
+func (req NameRequest) generate(hash string, attempt int) (string, error) {
+	ext := path.Ext(req.OriginalName)
+	length := req.Naming.length()
+
+	switch req.Naming.Strategy {
+	case NamingBase62:
+		// Give up on the configured length after a few collisions so a
+		// crowded category still gets names
+		id, err := randomBase62(length + attempt/3)
+		if err != nil {
+			return "", err
+		}
+		return id + ext, nil
+
+	case NamingSlug:
+		suffix, err := randomBase62(length)
+		if err != nil {
+			return "", err
+		}
+		return slugify(strings.TrimSuffix(req.OriginalName, path.Ext(req.OriginalName))) + "-" + strings.ToLower(suffix) + ext, nil
+
+	case NamingHash:
+		// Lengthen the prefix on collision; the same content stored twice
+		// shares the full hash, so it gets a random suffix instead
+		if n := length + 4*attempt; n <= len(hash) {
+			return hash[:n] + ext, nil
+		}
+		suffix, err := randomBase62(minNamingLength)
+		if err != nil {
+			return "", err
+		}
+		return hash[:length] + "-" + suffix + ext, nil
+	}
+
+	return uuid.New().String() + ext, nil
+}
+
+// userFilename checks a user-provided name and adds the original extension
+// when the name has none.
+func (req NameRequest) userFilename() (string, error) {
+	name := strings.TrimSpace(req.Name)
+	if path.Ext(name) == "" {
+		name += path.Ext(req.OriginalName)
+	}
+	if !validPathElement(name) || strings.HasPrefix(name, ".") || len(name) > maxFilenameLength {
+		return "", ErrInvalidName
+	}
+	for _, r := range name {
+		if !isNameRune(r) {
+			return "", ErrInvalidName
+		}
+	}
+	return name, nil
+}
+
+func isNameRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.'
+}
+
+// slugify lowercases a name and replaces every run of characters that are
+// not letters or digits with a dash.
+func slugify(name string) string {
+	var sb strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			if dash && sb.Len() > 0 {
+				sb.WriteByte('-')
+			}
+			dash = false
+			sb.WriteRune(r)
+			if sb.Len() >= maxSlugLength {
+				break
+			}
+			continue
+		}
+		dash = true
+	}
+	if sb.Len() == 0 {
+		return "file"
+	}
+	return sb.String()
+}
+
+func randomBase62(n int) (string, error) {
+	max := big.NewInt(int64(len(base62Alphabet)))
+	b := make([]byte, n)
+	for i := range b {
+		j, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate name: %w", err)
+		}
+		b[i] = base62Alphabet[j.Int64()]
+	}
+	return string(b), nil
+}
+
+// nameTaken reports whether a file, or a trashed file that may be restored,
+// already uses filename. The caller must hold s.mu.
+func (s *Storage) nameTaken(domainFolder, category, filename string) (bool, error) {
+	if _, _, ok, err := s.lookupRef(domainFolder, category, filename); err != nil || ok {
+		return ok, err
+	}
+	if _, err := s.backend.Stat(objectKey(domainFolder, category, filename)); err == nil {
+		return true, nil
+	} else if !errors.Is(err, ErrFileNotFound) {
+		return false, err
+	}
+	_, _, ok, err := s.lookupTrash(domainFolder, category, filename)
+	return ok, err
+}
+
+// newFilename picks the name of a new file. The caller must hold s.mu for
+// writing so the name is still free when the file is stored.
+func (s *Storage) newFilename(domainFolder, category, hash string, req NameRequest) (string, error) {
+	if req.Name != "" {
+		filename, err := req.userFilename()
+		if err != nil {
+			return "", err
+		}
+		taken, err := s.nameTaken(domainFolder, category, filename)
+		if err != nil {
+			return "", err
+		}
+		if taken {
+			return "", ErrFileExists
+		}
+		return filename, nil
+	}
+
+	for attempt := 0; attempt < maxNameAttempts; attempt++ {
+		filename, err := req.generate(hash, attempt)
+		if err != nil {
+			return "", err
+		}
+		taken, err := s.nameTaken(domainFolder, category, filename)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return filename, nil
+		}
+		fmt.Printf("[Storage] Generated name %s/%s/%s is taken, retrying\n", domainFolder, category, filename)
+	}
+	return "", fmt.Errorf("failed to find a free %s name after %d attempts", req.Naming, maxNameAttempts)
+}
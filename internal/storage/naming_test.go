@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseNaming(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Naming
+		ok   bool
+	}{
+		{"uuid", Naming{Strategy: NamingUUID}, true},
+		{"", Naming{}, true},
+		{" Base62:10 ", Naming{Strategy: NamingBase62, Length: 10}, true},
+		{"slug", Naming{Strategy: NamingSlug}, true},
+		{"hash:64", Naming{Strategy: NamingHash, Length: 64}, true},
+		{"hash:65", Naming{}, false},
+		{"base62:3", Naming{}, false},
+		{"uuid:8", Naming{}, false},
+		{"base62:ten", Naming{}, false},
+		{"random", Naming{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseNaming(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseNaming(%q) = %+v, %v; want %+v, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+		if tt.ok {
+			if again, err := ParseNaming(got.String()); err != nil || again.String() != got.String() {
+				t.Errorf("ParseNaming(%q) does not round-trip: %+v, %v", got.String(), again, err)
+			}
+		}
+	}
+}
+
+func TestGenerateName(t *testing.T) {
+	hash := hashOf("content")
+	tests := []struct {
+		naming  Naming
+		pattern string
+	}{
+		{Naming{}, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}\.png$`},
+		{Naming{Strategy: NamingBase62}, `^[0-9A-Za-z]{8}\.png$`},
+		{Naming{Strategy: NamingBase62, Length: 12}, `^[0-9A-Za-z]{12}\.png$`},
+		{Naming{Strategy: NamingSlug}, `^holiday-photo-1-[0-9a-z]{6}\.png$`},
+		{Naming{Strategy: NamingHash}, `^` + hash[:12] + `\.png$`},
+		{Naming{Strategy: NamingHash, Length: 20}, `^` + hash[:20] + `\.png$`},
+	}
+	for _, tt := range tests {
+		req := NameRequest{Naming: tt.naming, OriginalName: "Holiday Photo (1).png"}
+		name, err := req.generate(hash, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !regexp.MustCompile(tt.pattern).MatchString(name) {
+			t.Errorf("%s named the file %q, want a match for %s", tt.naming, name, tt.pattern)
+		}
+	}
+}
+
+func TestGenerateNameAfterCollisions(t *testing.T) {
+	hash := hashOf("content")
+
+	base62 := NameRequest{Naming: Naming{Strategy: NamingBase62}}
+	if name, _ := base62.generate(hash, 3); len(name) != 9 {
+		t.Errorf("base62 name after 3 collisions = %q, want 9 characters", name)
+	}
+
+	h := NameRequest{Naming: Naming{Strategy: NamingHash}}
+	if name, _ := h.generate(hash, 1); name != hash[:16] {
+		t.Errorf("hash name after a collision = %q, want %q", name, hash[:16])
+	}
+	name, _ := h.generate(hash, 20)
+	if !regexp.MustCompile(`^` + hash[:12] + `-[0-9A-Za-z]{4}$`).MatchString(name) {
+		t.Errorf("hash name once the hash is used up = %q", name)
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"Holiday Photo (1)":     "holiday-photo-1",
+		"  --Über  café--  ":    "ber-caf",
+		"???":                   "file",
+		strings.Repeat("a", 60): strings.Repeat("a", maxSlugLength),
+	}
+	for in, want := range tests {
+		if got := slugify(in); got != want {
+			t.Errorf("slugify(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestUserFilename(t *testing.T) {
+	tests := []struct {
+		name, original, want string
+		ok                   bool
+	}{
+		{"logo", "upload.png", "logo.png", true},
+		{"logo.svg", "upload.png", "logo.svg", true},
+		{"  spaced  ", "a.txt", "spaced.txt", true},
+		{"my file", "a.txt", "", false},
+		{"../escape", "a.txt", "", false},
+		{".hidden", "a.txt", "", false},
+		{"ünïcode", "a.txt", "", false},
+		{strings.Repeat("n", maxFilenameLength+1), "", "", false},
+	}
+	for _, tt := range tests {
+		got, err := NameRequest{Name: tt.name, OriginalName: tt.original}.userFilename()
+		if tt.ok && (err != nil || got != tt.want) {
+			t.Errorf("userFilename(%q) = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+		if !tt.ok && !errors.Is(err, ErrInvalidName) {
+			t.Errorf("userFilename(%q) = %q, %v; want ErrInvalidName", tt.name, got, err)
+		}
+	}
+}
+
+func TestNewFilenameAvoidsTakenNames(t *testing.T) {
+	s := newTestStorage(t)
+	req := NameRequest{Naming: Naming{Strategy: NamingHash, Length: 4}, OriginalName: "a.txt"}
+
+	// The same content stored again gets a longer hash prefix
+	var names []string
+	for range 3 {
+		name, err := s.StoreFile("main", "docs", spoolString(t, s, "same"), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	hash := hashOf("same")
+	if want := []string{hash[:4] + ".txt", hash[:8] + ".txt", hash[:12] + ".txt"}; strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+
+	// A trashed name is still taken, both as a chosen and a generated name
+	if err := s.TrashFile("main", "docs", names[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.StoreFile("main", "docs", spoolString(t, s, "other"), NameRequest{Name: names[0]}); !errors.Is(err, ErrFileExists) {
+		t.Errorf("storing under a trashed name = %v, want ErrFileExists", err)
+	}
+	name, err := s.StoreFile("main", "docs", spoolString(t, s, "same"), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != hash[:16]+".txt" {
+		t.Errorf("name next to a trashed one = %q, want %q", name, hash[:16]+".txt")
+	}
+}
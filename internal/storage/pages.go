@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// PagesDir is the reserved folder inside each domain folder that holds the
+// domain's custom error and landing pages. It is never served as a category.
+const PagesDir = ".pages"
+
+// OpenPage opens one of a domain's custom pages. It returns a nil file and a
+// nil error when the page does not exist.
+func (s *Storage) OpenPage(domainFolder, name string) (File, ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !validPathElement(domainFolder) || !validPathElement(name) {
+		return nil, ObjectInfo{}, nil
+	}
+
+	f, info, err := s.backend.Open(objectKey(domainFolder, PagesDir, name))
+	if err != nil {
+		if errors.Is(err, ErrFileNotFound) {
+			return nil, ObjectInfo{}, nil
+		}
+		return nil, ObjectInfo{}, fmt.Errorf("failed to open page: %w", err)
+	}
+	return f, info, nil
+}
+
+// StorePage writes one of a domain's custom pages, replacing any previous
+// version. Requests never see a partially written page.
+func (s *Storage) StorePage(domainFolder, name string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !validPathElement(domainFolder) || !validPathElement(name) {
+		return fmt.Errorf("invalid page path")
+	}
+
+	if err := s.backend.Put(objectKey(domainFolder, PagesDir, name), bytes.NewReader(data), int64(len(data)), "text/html; charset=utf-8"); err != nil {
+		return fmt.Errorf("failed to store page: %w", err)
+	}
+	return nil
+}
+
+// DeletePage removes one of a domain's custom pages.
+func (s *Storage) DeletePage(domainFolder, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !validPathElement(domainFolder) || !validPathElement(name) {
+		return ErrFileNotFound
+	}
+
+	return s.backend.Delete(objectKey(domainFolder, PagesDir, name))
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ErrFolderExists is returned when renaming a folder to a name that already
+// holds files.
+var ErrFolderExists = errors.New("folder already exists")
+
+// prefixRenamer is implemented by backends that can move every object under
+// a prefix at once, such as by renaming a directory.
+type prefixRenamer interface {
+	RenamePrefix(from, to string) error
+}
+
+// folderMove is one prefix a folder rename moves. Markers are the empty
+// objects under .refs and .trash that keep a blob referenced.
+type folderMove struct {
+	from, to string
+	markers  bool
+}
+
+// RenameFolder renames a domain folder or, when category is set, a category
+// folder of a domain. Its files, references, trashed files and quarantined
+// files move with it; cached variants are dropped and made again on demand.
+//
+// commit is called before the storage lock is released, so it can switch
+// the config over to the new name before any upload or request sees the new
+// layout. If commit fails, the folder is moved back.
+func (s *Storage) RenameFolder(domainFolder, category, newName string, commit func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !validPathElement(domainFolder) || (category != "" && !validPathElement(category)) {
+		return ErrFileNotFound
+	}
+	if !validPathElement(newName) || strings.HasPrefix(newName, ".") {
+		return fmt.Errorf("invalid folder name '%s'", newName)
+	}
+
+	from, to := []string{domainFolder}, []string{newName}
+	if category != "" {
+		from, to = []string{domainFolder, category}, []string{domainFolder, newName}
+	}
+	under := func(dir string, elems []string) string {
+		return objectKey(append([]string{dir}, elems...)...)
+	}
+	moves := []folderMove{
+		{from: objectKey(from...), to: objectKey(to...)},
+		{from: under(refsDir, from), to: under(refsDir, to), markers: true},
+		{from: under(trashDir, from), to: under(trashDir, to), markers: true},
+		{from: under(quarantineDir, from), to: under(quarantineDir, to)},
+	}
+
+	for _, m := range moves {
+		objects, err := s.backend.List(m.to + "/")
+		if err != nil {
+			return err
+		}
+		if len(objects) > 0 {
+			return ErrFolderExists
+		}
+	}
+
+	// Whether the moves stick or are undone, what was cached may be stale
+	defer s.forgetRefs()
+
+	var done []folderMove
+	for _, m := range moves {
+		if err := s.movePrefix(m.from, m.to, m.markers); err != nil {
+			s.undoMoves(done)
+			return fmt.Errorf("failed to move %s: %w", m.from, err)
+		}
+		done = append(done, m)
+	}
+	if err := commit(); err != nil {
+		s.undoMoves(done)
+		return err
+	}
+
+	if err := s.deletePrefix(under(cacheDir, from) + "/"); err != nil {
+		fmt.Printf("[Storage] Failed to remove cached variants of %s: %v\n", objectKey(from...), err)
+	}
+	s.etagMu.Lock()
+	s.etags = make(map[string]etagEntry)
+	s.etagMu.Unlock()
+	s.usageMu.Lock()
+	s.usage = nil
+	s.usageMu.Unlock()
+
+	fmt.Printf("[Storage] Renamed %s to %s\n", objectKey(from...), objectKey(to...))
+	return nil
+}
+
+// undoMoves moves renamed prefixes back, latest first. The caller must hold
+// s.mu for writing.
+func (s *Storage) undoMoves(done []folderMove) {
+	for i := len(done) - 1; i >= 0; i-- {
+		m := done[i]
+		if err := s.movePrefix(m.to, m.from, m.markers); err != nil {
+			fmt.Printf("[Storage] Failed to move %s back to %s: %v\n", m.to, m.from, err)
+		}
+	}
+}
+
+// movePrefix moves every object under from/ to the same key under to/.
+// Backends without a rename copy each object; markers are moved with
+// moveMarker so their blob stays counted if the move is interrupted. The
+// caller must hold s.mu for writing.
+func (s *Storage) movePrefix(from, to string, markers bool) error {
+	if r, ok := s.backend.(prefixRenamer); ok {
+		return r.RenamePrefix(from, to)
+	}
+
+	objects, err := s.backend.List(from + "/")
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		target := to + strings.TrimPrefix(obj.Key, from)
+		if hash := path.Base(obj.Key); markers && len(hash) == 64 {
+			uploadedAt, err := s.markerUploadTime(obj)
+			if err != nil {
+				return err
+			}
+			if err := s.moveMarker(obj.Key, target, hash, uploadedAt); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := s.copyObject(obj.Key, target); err != nil {
+			return err
+		}
+		if err := s.backend.Delete(obj.Key); err != nil && !errors.Is(err, ErrFileNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Storage) copyObject(from, to string) error {
+	f, info, err := s.backend.Open(from)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.backend.Put(to, f, info.Size, info.ContentType)
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// renameBackends runs a test against a backend that renames directories and
+// one that copies each object.
+func renameBackends(t *testing.T, test func(t *testing.T, s *Storage)) {
+	t.Run("fs", func(t *testing.T) {
+		test(t, newTestStorage(t))
+	})
+	t.Run("s3", func(t *testing.T) {
+		_, srv := newFakeS3(t)
+		test(t, NewStorageWithBackend(newTestS3Backend(t, srv.URL, testSecretKey, "")))
+	})
+}
+
+func TestRenameFolderMovesEverything(t *testing.T) {
+	renameBackends(t, func(t *testing.T, s *Storage) {
+		storeString(t, s, "main", "images", "a.txt", "shared")
+		storeString(t, s, "main", "docs", "b.txt", "shared")
+		storeString(t, s, "main", "images", "trashed.txt", "trashed")
+		if err := s.TrashFile("main", "images", "trashed.txt"); err != nil {
+			t.Fatal(err)
+		}
+		// A file stored before references existed
+		if err := s.backend.Put(objectKey("main", "images", "plain.txt"), strings.NewReader("plain"), 5, "text/plain"); err != nil {
+			t.Fatal(err)
+		}
+
+		committed := false
+		if err := s.RenameFolder("main", "images", "pictures", func() error {
+			committed = true
+			return nil
+		}); err != nil {
+			t.Fatalf("RenameFolder: %v", err)
+		}
+		if !committed {
+			t.Error("commit not called")
+		}
+
+		for name, want := range map[string]string{"a.txt": "shared", "plain.txt": "plain"} {
+			if got, ok := readString(t, s, "main", "pictures", name); !ok || got != want {
+				t.Errorf("pictures/%s = %q, %v", name, got, ok)
+			}
+			if _, ok := readString(t, s, "main", "images", name); ok {
+				t.Errorf("images/%s still served", name)
+			}
+		}
+		files, err := s.ListFiles("main", "pictures")
+		if err != nil || !slices.Equal(files, []string{"a.txt", "plain.txt"}) {
+			t.Errorf("ListFiles = %v, %v", files, err)
+		}
+
+		// Other categories and blob counts are untouched
+		if got, ok := readString(t, s, "main", "docs", "b.txt"); !ok || got != "shared" {
+			t.Errorf("docs/b.txt = %q, %v", got, ok)
+		}
+		assertRefCount(t, s, hashOf("shared"), 2)
+		assertRefCount(t, s, hashOf("trashed"), 1)
+
+		if err := s.RestoreFile("main", "pictures", "trashed.txt"); err != nil {
+			t.Errorf("RestoreFile under the new name: %v", err)
+		}
+	})
+}
+
+func TestRenameDomainFolder(t *testing.T) {
+	renameBackends(t, func(t *testing.T, s *Storage) {
+		storeString(t, s, "main", "images", "a.txt", "a")
+		storeString(t, s, "other", "images", "b.txt", "b")
+
+		if err := s.RenameFolder("main", "", "cdn", func() error { return nil }); err != nil {
+			t.Fatalf("RenameFolder: %v", err)
+		}
+		if got, ok := readString(t, s, "cdn", "images", "a.txt"); !ok || got != "a" {
+			t.Errorf("cdn/images/a.txt = %q, %v", got, ok)
+		}
+		if _, ok := readString(t, s, "main", "images", "a.txt"); ok {
+			t.Error("old domain folder still served")
+		}
+		if got, ok := readString(t, s, "other", "images", "b.txt"); !ok || got != "b" {
+			t.Errorf("other domain changed: %q, %v", got, ok)
+		}
+	})
+}
+
+func TestRenameFolderRollsBack(t *testing.T) {
+	renameBackends(t, func(t *testing.T, s *Storage) {
+		storeString(t, s, "main", "images", "a.txt", "a")
+		storeString(t, s, "main", "images", "gone.txt", "gone")
+		if err := s.TrashFile("main", "images", "gone.txt"); err != nil {
+			t.Fatal(err)
+		}
+
+		failed := errors.New("config not saved")
+		if err := s.RenameFolder("main", "images", "pictures", func() error { return failed }); !errors.Is(err, failed) {
+			t.Fatalf("RenameFolder = %v, want the commit error", err)
+		}
+		if got, ok := readString(t, s, "main", "images", "a.txt"); !ok || got != "a" {
+			t.Errorf("images/a.txt after rollback = %q, %v", got, ok)
+		}
+		if _, ok := readString(t, s, "main", "pictures", "a.txt"); ok {
+			t.Error("file left under the new name")
+		}
+		assertRefCount(t, s, hashOf("a"), 1)
+		if err := s.RestoreFile("main", "images", "gone.txt"); err != nil {
+			t.Errorf("trash not moved back: %v", err)
+		}
+	})
+}
+
+func TestRenameFolderRefusesTakenName(t *testing.T) {
+	s := newTestStorage(t)
+	storeString(t, s, "main", "images", "a.txt", "a")
+	storeString(t, s, "main", "pictures", "b.txt", "b")
+
+	err := s.RenameFolder("main", "images", "pictures", func() error {
+		t.Error("commit called for a taken name")
+		return nil
+	})
+	if !errors.Is(err, ErrFolderExists) {
+		t.Fatalf("RenameFolder = %v, want ErrFolderExists", err)
+	}
+	for _, f := range []struct{ category, name, want string }{{"images", "a.txt", "a"}, {"pictures", "b.txt", "b"}} {
+		if got, ok := readString(t, s, "main", f.category, f.name); !ok || got != f.want {
+			t.Errorf("%s/%s = %q, %v", f.category, f.name, got, ok)
+		}
+	}
+
+	if err := s.RenameFolder("main", "images", ".hidden", func() error { return nil }); err == nil {
+		t.Error("renamed to a reserved name")
+	}
+}
+
+func TestRenameFolderDropsCachedVariants(t *testing.T) {
+	s := newTestStorage(t)
+	storeString(t, s, "main", "images", "a.png", "image")
+	if err := s.StoreVariant("main", "images", "a.png", "w320", []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RenameFolder("main", "images", "pictures", func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := s.backend.List(cacheDir + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 0 {
+		t.Errorf("cached variants left after a rename: %v", objects)
+	}
+}
@@ -0,0 +1,525 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config configures an S3-compatible backend (AWS S3, MinIO, Ceph RGW,
+// Cloudflare R2, ...).
+type S3Config struct {
+	// Endpoint is the service URL, e.g. https://s3.us-east-1.amazonaws.com
+	// or http://minio:9000.
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// Prefix is prepended to every key, to share a bucket with other data.
+	Prefix string
+	// PathStyle addresses the bucket as endpoint/bucket instead of
+	// bucket.endpoint. Most self-hosted services need it.
+	PathStyle bool
+	Timeout   time.Duration
+}
+
+// S3Backend stores objects in a bucket of an S3-compatible service. Requests
+// are signed with AWS Signature Version 4.
+type S3Backend struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+func NewS3Backend(cfg S3Config) (*S3Backend, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("S3 bucket is required")
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, fmt.Errorf("S3 access key and secret key are required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.Region)
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Minute
+	}
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+	if cfg.Prefix != "" {
+		cfg.Prefix += "/"
+	}
+
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint '%s'", cfg.Endpoint)
+	}
+
+	return &S3Backend{
+		cfg:      cfg,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: cfg.Timeout},
+	}, nil
+}
+
+// objectURL returns the URL of an object, or of the bucket when key is empty.
+func (b *S3Backend) objectURL(key string, query url.Values) *url.URL {
+	u := *b.endpoint
+	path := "/" + key
+	if b.cfg.PathStyle {
+		path = "/" + b.cfg.Bucket + path
+	} else {
+		u.Host = b.cfg.Bucket + "." + u.Host
+	}
+
+	// SigV4 signs the path with every segment percent-encoded per RFC 3986,
+	// which is stricter than Go's default escaping
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		segments[i] = uriEncode(seg)
+	}
+	u.Path = b.endpoint.Path + path
+	u.RawPath = b.endpoint.EscapedPath() + strings.Join(segments, "/")
+	u.RawQuery = encodeQuery(query)
+	return &u
+}
+
+func (b *S3Backend) newRequest(method, key string, query url.Values, body io.Reader) (*http.Request, error) {
+	if key != "" {
+		key = b.cfg.Prefix + key
+	}
+	req, err := http.NewRequest(method, b.objectURL(key, query).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func (b *S3Backend) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	b.sign(req, payloadHash, time.Now().UTC())
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 request failed: %w", err)
+	}
+	return resp, nil
+}
+
+// Put uploads the object in a single request. Readers of unknown length are
+// spooled to a temporary file first since S3 needs the length up front.
+func (b *S3Backend) Put(key string, r io.Reader, size int64, contentType string) error {
+	body, ok := r.(io.ReadSeeker)
+	if !ok || size < 0 {
+		tmp, err := os.CreateTemp("", "vixa-s3-*")
+		if err != nil {
+			return fmt.Errorf("failed to buffer upload: %w", err)
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		if size, err = io.Copy(tmp, r); err != nil {
+			return fmt.Errorf("failed to buffer upload: %w", err)
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to buffer upload: %w", err)
+		}
+		body = tmp
+	}
+
+	// Hash the payload so the request is fully signed, then rewind for the upload
+	start, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to read upload: %w", err)
+	}
+	hash := sha256.New()
+	if _, err := io.CopyN(hash, body, size); err != nil {
+		return fmt.Errorf("failed to read upload: %w", err)
+	}
+	if _, err := body.Seek(start, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read upload: %w", err)
+	}
+
+	// A zero ContentLength with a non-nil body would be sent chunked, which
+	// S3 rejects
+	var reqBody io.Reader = http.NoBody
+	if size > 0 {
+		reqBody = io.NopCloser(io.LimitReader(body, size))
+	}
+
+	req, err := b.newRequest(http.MethodPut, key, nil, reqBody)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := b.do(req, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func (b *S3Backend) Open(key string) (File, ObjectInfo, error) {
+	info, err := b.Stat(key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	return &s3File{backend: b, key: key, size: info.Size}, info, nil
+}
+
+func (b *S3Backend) Stat(key string) (ObjectInfo, error) {
+	req, err := b.newRequest(http.MethodHead, key, nil, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	resp, err := b.do(req, emptyPayloadHash)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return ObjectInfo{}, ErrFileNotFound
+	default:
+		return ObjectInfo{}, s3Error(resp)
+	}
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return ObjectInfo{
+		Key:         key,
+		Size:        resp.ContentLength,
+		ModTime:     modTime,
+		ContentType: resp.Header.Get("Content-Type"),
+	}, nil
+}
+
+// Delete checks that the object exists first, since S3 reports success for
+// missing objects.
+func (b *S3Backend) Delete(key string) error {
+	if _, err := b.Stat(key); err != nil {
+		return err
+	}
+
+	req, err := b.newRequest(http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := b.do(req, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (b *S3Backend) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	token := ""
+
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"prefix":    {b.cfg.Prefix + prefix},
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		req, err := b.newRequest(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := b.do(req, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			err := s3Error(resp)
+			resp.Body.Close()
+			return nil, err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse S3 listing: %w", err)
+		}
+
+		for _, c := range result.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:     strings.TrimPrefix(c.Key, b.cfg.Prefix),
+				Size:    c.Size,
+				ModTime: c.LastModified,
+			})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Check verifies that the bucket exists and the credentials are accepted.
+func (b *S3Backend) Check() error {
+	req, err := b.newRequest(http.MethodHead, "", nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.do(req, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("S3 bucket '%s' is not accessible: status %d", b.cfg.Bucket, resp.StatusCode)
+	}
+	return nil
+}
+
+func s3Error(resp *http.Response) error {
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(data, &body) == nil && body.Code != "" {
+		return fmt.Errorf("S3 error %s (status %d): %s", body.Code, resp.StatusCode, body.Message)
+	}
+	return fmt.Errorf("S3 request failed with status: %d", resp.StatusCode)
+}
+
+// s3File reads an object with ranged GET requests. The request is only made
+// on the first Read and again after a Seek moves away from where the open
+// response body is positioned.
+type s3File struct {
+	backend *S3Backend
+	key     string
+	size    int64
+	offset  int64
+
+	body       io.ReadCloser
+	bodyOffset int64
+}
+
+func (f *s3File) Read(p []byte) (int, error) {
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+
+	if f.body != nil && f.bodyOffset != f.offset {
+		f.body.Close()
+		f.body = nil
+	}
+	if f.body == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	f.bodyOffset = f.offset
+	if errors.Is(err, io.EOF) && f.offset < f.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (f *s3File) open() error {
+	req, err := f.backend.newRequest(http.MethodGet, f.key, nil, nil)
+	if err != nil {
+		return err
+	}
+	if f.offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(f.offset, 10)+"-")
+	}
+
+	resp, err := f.backend.do(req, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return ErrFileNotFound
+		}
+		return s3Error(resp)
+	}
+	if f.offset > 0 && resp.StatusCode == http.StatusOK {
+		// The service ignored the Range header; skip to the offset
+		if _, err := io.CopyN(io.Discard, resp.Body, f.offset); err != nil {
+			resp.Body.Close()
+			return fmt.Errorf("failed to read object: %w", err)
+		}
+	}
+
+	f.body = resp.Body
+	f.bodyOffset = f.offset
+	return nil
+}
+
+func (f *s3File) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = f.offset + offset
+	case io.SeekEnd:
+		abs = f.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if abs < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+	f.offset = abs
+	return abs, nil
+}
+
+func (f *s3File) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	err := f.body.Close()
+	f.body = nil
+	return err
+}
+
+// SHA-256 of an empty payload, used for requests without a body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign adds AWS Signature Version 4 headers to req.
+func (b *S3Backend) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" || lower == "range" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + b.cfg.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+b.cfg.SecretAccessKey), date)
+	key = hmacSHA256(key, b.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.cfg.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// encodeQuery encodes query parameters the way SigV4 expects in the
+// canonical request: sorted by name and percent-encoded per RFC 3986.
+func encodeQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(name)+"="+uriEncode(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func uriEncode(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
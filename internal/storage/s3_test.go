@@ -0,0 +1,391 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAccessKey = "test-access-key"
+	testSecretKey = "test-secret-key"
+	testRegion    = "us-east-1"
+	testBucket    = "vixa"
+)
+
+// fakeS3 is a path-style S3 stand-in that checks request signatures, pages
+// listings pageSize keys at a time and honours open-ended Range headers.
+type fakeS3 struct {
+	t        *testing.T
+	pageSize int
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	ranges  []string
+	lists   int
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{t: t, pageSize: 2, objects: make(map[string]fakeObject)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func newTestS3Backend(t *testing.T, endpoint, secret, prefix string) *S3Backend {
+	t.Helper()
+	b, err := NewS3Backend(S3Config{
+		Endpoint:        endpoint,
+		Region:          testRegion,
+		Bucket:          testBucket,
+		AccessKeyID:     testAccessKey,
+		SecretAccessKey: secret,
+		Prefix:          prefix,
+		PathStyle:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if err := f.verify(r, body); err != nil {
+		f.t.Logf("rejected %s %s: %v", r.Method, r.URL, err)
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "<Error><Code>SignatureDoesNotMatch</Code><Message>%s</Message></Error>", err)
+		return
+	}
+
+	bucketPath := "/" + testBucket
+	if r.URL.Path == bucketPath || r.URL.Path == bucketPath+"/" {
+		switch r.Method {
+		case http.MethodHead:
+		case http.MethodGet:
+			f.list(w, r)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, bucketPath+"/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	obj, exists := f.objects[key]
+	switch r.Method {
+	case http.MethodPut:
+		f.objects[key] = fakeObject{data: body, contentType: r.Header.Get("Content-Type"), modTime: time.Now()}
+	case http.MethodDelete:
+		// Like S3, deleting a missing object succeeds
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodHead, http.MethodGet:
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("Last-Modified", obj.modTime.UTC().Format(http.TimeFormat))
+		data, status := obj.data, http.StatusOK
+		if rng := r.Header.Get("Range"); rng != "" {
+			f.ranges = append(f.ranges, rng)
+			start, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+			if err != nil || start >= len(data) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(data)-1, len(data)))
+			data, status = data[start:], http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("list-type") != "2" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	prefix := query.Get("prefix")
+	after := query.Get("continuation-token")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lists++
+
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	type content struct {
+		Key          string
+		LastModified time.Time
+		Size         int
+	}
+	var result struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Contents              []content
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+	}
+	if len(keys) > f.pageSize {
+		keys = keys[:f.pageSize]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		obj := f.objects[key]
+		result.Contents = append(result.Contents, content{Key: key, LastModified: obj.modTime.UTC(), Size: len(obj.data)})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// verify recomputes the SigV4 signature of r the way S3 does.
+func (f *fakeS3) verify(r *http.Request, body []byte) error {
+	auth := r.Header.Get("Authorization")
+	fields := make(map[string]string)
+	for _, part := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ", ") {
+		name, value, _ := strings.Cut(part, "=")
+		fields[name] = value
+	}
+	credential := strings.SplitN(fields["Credential"], "/", 2)
+	if len(credential) != 2 || credential[0] != testAccessKey {
+		return fmt.Errorf("unknown credential %q", fields["Credential"])
+	}
+	scope := credential[1]
+	date, _, _ := strings.Cut(scope, "/")
+
+	payloadHash := sha256.Sum256(body)
+	if got := r.Header.Get("X-Amz-Content-Sha256"); got != hex.EncodeToString(payloadHash[:]) {
+		return fmt.Errorf("payload hash %s does not match the body", got)
+	}
+
+	var query []string
+	if r.URL.RawQuery != "" {
+		query = strings.Split(r.URL.RawQuery, "&")
+		sort.Strings(query)
+	}
+	var headers strings.Builder
+	for _, name := range strings.Split(fields["SignedHeaders"], ";") {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	canonical := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		strings.Join(query, "&"),
+		headers.String(),
+		fields["SignedHeaders"],
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + r.Header.Get("X-Amz-Date") + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	key := mac([]byte("AWS4"+testSecretKey), date)
+	key = mac(key, testRegion)
+	key = mac(key, "s3")
+	key = mac(key, "aws4_request")
+	if want := hex.EncodeToString(mac(key, stringToSign)); fields["Signature"] != want {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+func TestS3PutAndOpenWithRange(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	b := newTestS3Backend(t, srv.URL, testSecretKey, "cdn")
+
+	// Characters Go and SigV4 escape differently must still sign correctly
+	key := "main/images/hello world+(1)~.txt"
+	content := "hello, ranged world"
+	if err := b.Put(key, strings.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, ok := fake.objects["cdn/"+key]; !ok {
+		t.Fatalf("object not stored under the prefix, have %v", fake.objects)
+	}
+
+	f, info, err := b.Open(key)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	if info.Size != int64(len(content)) || info.ContentType != "text/plain" {
+		t.Errorf("info = %+v", info)
+	}
+
+	if _, err := f.Seek(7, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("reading from offset: %v", err)
+	}
+	if string(rest) != content[7:] {
+		t.Errorf("read %q from offset 7, want %q", rest, content[7:])
+	}
+	if len(fake.ranges) != 1 || fake.ranges[0] != "bytes=7-" {
+		t.Errorf("Range headers = %v, want [bytes=7-]", fake.ranges)
+	}
+
+	// Seeking back reopens from the start without a Range header
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	all, err := io.ReadAll(f)
+	if err != nil || string(all) != content {
+		t.Errorf("read %q, %v after seeking back", all, err)
+	}
+	if len(fake.ranges) != 1 {
+		t.Errorf("unexpected Range headers %v", fake.ranges)
+	}
+}
+
+func TestS3PutUnknownSize(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	b := newTestS3Backend(t, srv.URL, testSecretKey, "")
+
+	// A plain reader is buffered so the payload can be hashed and sized
+	r := io.MultiReader(strings.NewReader("abc"), strings.NewReader("def"))
+	if err := b.Put("a/b/c", r, -1, ""); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if got := string(fake.objects["a/b/c"].data); got != "abcdef" {
+		t.Errorf("stored %q", got)
+	}
+	if err := b.Put("a/b/empty", bytes.NewReader(nil), 0, ""); err != nil {
+		t.Fatalf("Put empty: %v", err)
+	}
+}
+
+func TestS3ListPaginates(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	b := newTestS3Backend(t, srv.URL, testSecretKey, "cdn")
+
+	var want []string
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf(".refs/main/images/file%d", i)
+		want = append(want, key)
+		if err := b.Put(key, bytes.NewReader(nil), 0, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Put(".refs/main/other/file", bytes.NewReader(nil), 0, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := b.List(".refs/main/images/")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var got []string
+	for _, o := range objects {
+		got = append(got, o.Key)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("List = %v, want %v", got, want)
+	}
+	if fake.lists != 3 {
+		t.Errorf("made %d list requests, want 3 pages of 2", fake.lists)
+	}
+}
+
+func TestS3Delete(t *testing.T) {
+	_, srv := newFakeS3(t)
+	b := newTestS3Backend(t, srv.URL, testSecretKey, "")
+
+	if err := b.Put("a/b/c", strings.NewReader("x"), 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete("a/b/c"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := b.Stat("a/b/c"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Stat after Delete = %v, want ErrFileNotFound", err)
+	}
+	if err := b.Delete("a/b/c"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Delete of a missing object = %v, want ErrFileNotFound", err)
+	}
+	if _, _, err := b.Open("a/b/c"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Open of a missing object = %v, want ErrFileNotFound", err)
+	}
+}
+
+func TestS3RejectedSignature(t *testing.T) {
+	_, srv := newFakeS3(t)
+	b := newTestS3Backend(t, srv.URL, "wrong-secret", "")
+
+	err := b.Put("a/b/c", strings.NewReader("x"), 1, "")
+	if err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("Put with a wrong secret = %v, want SignatureDoesNotMatch", err)
+	}
+	if err := b.Check(); err == nil {
+		t.Error("Check with a wrong secret succeeded")
+	}
+}
+
+func TestS3Storage(t *testing.T) {
+	_, srv := newFakeS3(t)
+	s := NewStorageWithBackend(newTestS3Backend(t, srv.URL, testSecretKey, ""))
+
+	upload := spoolString(t, s, "stored on s3")
+	name, err := s.StoreFile("main", "images", upload, NameRequest{Name: "note.txt"})
+	if err != nil {
+		t.Fatalf("StoreFile: %v", err)
+	}
+	data, _, err := s.GetFile("main", "images", name)
+	if err != nil || string(data) != "stored on s3" {
+		t.Fatalf("GetFile = %q, %v", data, err)
+	}
+	if err := s.DeleteFile("main", "images", name); err != nil {
+		t.Fatalf("DeleteFile: %v", err)
+	}
+	if f, _, _, _ := s.OpenFile("main", "images", name); f != nil {
+		f.Close()
+		t.Error("file still served after DeleteFile")
+	}
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// quarantineDir holds files taken out of service by a scrub, under
+// .quarantine/<domain>/<category>/<filename>. It is never served.
+const quarantineDir = ".quarantine"
+
+// VerifyFile reads a stored file in full and returns its size and the
+// SHA-256 of its content. expected is the hash the content is stored under,
+// or "" for files stored before references existed. It returns
+// ErrFileNotFound only when the file itself does not exist; a reference to a
+// missing blob is reported as a different error.
+func (s *Storage) VerifyFile(domainFolder, category, filename string) (expected, actual string, size int64, err error) {
+	if !validPathElement(domainFolder) || !validPathElement(category) || !validPathElement(filename) {
+		return "", "", 0, ErrFileNotFound
+	}
+
+	// Only hold the lock while opening; a file opened before it is replaced
+	// or deleted can still be read to the end
+	s.mu.RLock()
+	hash, _, ok, err := s.lookupRef(domainFolder, category, filename)
+	var f File
+	if err == nil {
+		if ok {
+			f, _, err = s.backend.Open(blobKey(hash))
+			if errors.Is(err, ErrFileNotFound) {
+				err = fmt.Errorf("blob %s is missing", hash)
+			}
+		} else {
+			f, _, err = s.backend.Open(objectKey(domainFolder, category, filename))
+		}
+	}
+	s.mu.RUnlock()
+	if err != nil {
+		return hash, "", 0, err
+	}
+	defer f.Close()
+
+	sum := sha256.New()
+	size, err = io.Copy(sum, f)
+	if err != nil {
+		return hash, "", size, fmt.Errorf("failed to read file: %w", err)
+	}
+	return hash, hex.EncodeToString(sum.Sum(nil)), size, nil
+}
+
+// Folders returns the category folders that hold files in each domain
+// folder, whether plain files or references. Reserved folders are left out.
+func (s *Storage) Folders() (map[string][]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	objects, err := s.backend.List("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list storage: %w", err)
+	}
+
+	seen := make(map[string]map[string]bool)
+	for _, obj := range objects {
+		parts := strings.Split(obj.Key, "/")
+		if len(parts) == 5 && parts[0] == refsDir {
+			// .refs/<domain>/<category>/<filename>/<hash>
+			parts = parts[1:4]
+		}
+		if len(parts) != 3 || hasDotElement(parts) {
+			continue
+		}
+		if seen[parts[0]] == nil {
+			seen[parts[0]] = make(map[string]bool)
+		}
+		seen[parts[0]][parts[1]] = true
+	}
+
+	folders := make(map[string][]string, len(seen))
+	for domainFolder, categories := range seen {
+		for category := range categories {
+			folders[domainFolder] = append(folders[domainFolder], category)
+		}
+		sort.Strings(folders[domainFolder])
+	}
+	return folders, nil
+}
+
+// QuarantineFile takes a damaged file out of service: whatever of its
+// content can be read is moved to the quarantine folder and its URL stops
+// working. References to a missing blob are simply removed.
+func (s *Storage) QuarantineFile(domainFolder, category, filename string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !validPathElement(domainFolder) || !validPathElement(category) || !validPathElement(filename) {
+		return ErrFileNotFound
+	}
+
+	f, info, err := s.openObject(domainFolder, category, filename)
+	if err != nil {
+		_, _, isRef, lerr := s.lookupRef(domainFolder, category, filename)
+		if lerr != nil || !isRef || !errors.Is(err, ErrFileNotFound) {
+			return err
+		}
+		// The blob is gone, so there is nothing to keep
+		return s.deleteFile(domainFolder, category, filename)
+	}
+
+	err = s.backend.Put(objectKey(quarantineDir, domainFolder, category, filename), f, info.Size, info.ContentType)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to copy file to quarantine: %w", err)
+	}
+	return s.deleteFile(domainFolder, category, filename)
+}
@@ -1,116 +1,374 @@
 package storage
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/google/uuid"
+	"github.com/vixa/cdn/internal/metrics"
 )
 
+var ErrFileNotFound = errors.New("file not found")
+
+// cacheDir holds derived variants of stored files (resized images,
+// compressed copies). It lives next to the domain folders but is never
+// served directly.
+const cacheDir = ".cache"
+
 type Storage struct {
-	basePath string
-	mu       sync.RWMutex
+	backend Backend
+	mu      sync.RWMutex
+
+	etagMu sync.Mutex
+	etags  map[string]etagEntry
+
+	refMu sync.Mutex
+	refs  map[string]refEntry
+
+	usageMu      sync.Mutex
+	usage        map[string]Usage
+	usageUpdated time.Time
 }
 
+// Usage is the space used by one domain folder.
+type Usage struct {
+	Bytes int64
+	Files int64
+}
+
+// usageCacheTTL bounds how often Usage lists the whole store.
+const usageCacheTTL = 30 * time.Second
+
+// etagEntry caches the content hash of a file so it is only computed
+// again when the stored object changes.
+type etagEntry struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+// NewStorage returns a Storage keeping files on the local filesystem under
+// basePath.
 func NewStorage(basePath string) (*Storage, error) {
-	if err := os.MkdirAll(basePath, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	backend, err := NewFSBackend(basePath)
+	if err != nil {
+		return nil, err
 	}
+	return NewStorageWithBackend(backend), nil
+}
 
+func NewStorageWithBackend(backend Backend) *Storage {
 	return &Storage{
-		basePath: basePath,
-	}, nil
+		backend: backend,
+		etags:   make(map[string]etagEntry),
+		refs:    make(map[string]refEntry),
+	}
 }
 
-func (s *Storage) StoreFile(domainFolder, category string, data []byte, contentType, ext string) (filename string, size int, err error) {
+// objectKey joins path elements into a backend key.
+func objectKey(elems ...string) string {
+	return strings.Join(elems, "/")
+}
+
+// StoreFile stores a spooled upload under a name chosen as req asks and
+// returns the name. The name is picked while holding the storage lock, so
+// it cannot be taken by a concurrent upload. A user-provided name that is
+// taken fails with ErrFileExists, one that cannot be used in a URL with
+// ErrInvalidName.
+func (s *Storage) StoreFile(domainFolder, category string, upload *Upload, req NameRequest) (filename string, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Generate unique filename
-	id := uuid.New().String()
-	filename = id + ext
-
-	// Create directory structure: /basePath/domainFolder/category/
-	dirPath := filepath.Join(s.basePath, domainFolder, category)
-	if err := os.MkdirAll(dirPath, 0755); err != nil {
-		return "", 0, fmt.Errorf("failed to create directory: %w", err)
+	if !validPathElement(domainFolder) || !validPathElement(category) {
+		return "", fmt.Errorf("invalid folder %s/%s", domainFolder, category)
+	}
+	filename, err = s.newFilename(domainFolder, category, upload.SHA256, req)
+	if err != nil {
+		return "", err
 	}
 
-	// Write file
-	filePath := filepath.Join(dirPath, filename)
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
-		return "", 0, fmt.Errorf("failed to write file: %w", err)
+	if _, err := upload.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to read upload: %w", err)
+	}
+	if err := s.addRef(domainFolder, category, filename, upload.SHA256, upload, upload.Size, upload.ContentType); err != nil {
+		return "", err
 	}
 
-	return filename, len(data), nil
+	return filename, nil
 }
 
 func (s *Storage) GetFile(domainFolder, category, filename string) ([]byte, string, error) {
+	f, _, contentType, err := s.OpenFile(domainFolder, category, filename)
+	if err != nil || f == nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read file: %w", err)
+	}
+	return data, contentType, nil
+}
+
+// OpenFile opens a stored file for streaming. It returns a nil file and a nil
+// error when the file does not exist. The caller must close the file.
+func (s *Storage) OpenFile(domainFolder, category, filename string) (File, ObjectInfo, string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	filePath := filepath.Join(s.basePath, domainFolder, category, filename)
+	if !validPathElement(domainFolder) || !validPathElement(category) || !validPathElement(filename) {
+		return nil, ObjectInfo{}, "", nil
+	}
 
-	data, err := os.ReadFile(filePath)
+	f, info, err := s.openObject(domainFolder, category, filename)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, "", nil
+		if errors.Is(err, ErrFileNotFound) {
+			return nil, ObjectInfo{}, "", nil
 		}
-		return nil, "", fmt.Errorf("failed to read file: %w", err)
+		return nil, ObjectInfo{}, "", err
 	}
 
-	// Detect content type
-	contentType := mime.TypeByExtension(filepath.Ext(filename))
+	// Detect content type, sniffing the first bytes only if neither the
+	// extension nor the backend knows it
+	contentType := mime.TypeByExtension(path.Ext(filename))
+	if contentType == "" {
+		contentType = info.ContentType
+	}
 	if contentType == "" {
-		contentType = http.DetectContentType(data)
+		buf := make([]byte, 512)
+		n, _ := io.ReadFull(f, buf)
+		contentType = http.DetectContentType(buf[:n])
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			return nil, ObjectInfo{}, "", fmt.Errorf("failed to rewind file: %w", err)
+		}
 	}
 
-	return data, contentType, nil
+	return f, info, contentType, nil
+}
+
+// openObject opens the blob a public file refers to, falling back to a plain
+// object for files stored before references existed. The returned info has
+// the blob's key and size but the reference's modification time, which is
+// when this name was uploaded.
+func (s *Storage) openObject(domainFolder, category, filename string) (File, ObjectInfo, error) {
+	hash, ref, ok, err := s.lookupRef(domainFolder, category, filename)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	if !ok {
+		return s.backend.Open(objectKey(domainFolder, category, filename))
+	}
+
+	f, info, err := s.backend.Open(blobKey(hash))
+	if err != nil {
+		if errors.Is(err, ErrFileNotFound) {
+			fmt.Printf("[Storage] Blob %s referenced by %s/%s/%s is missing\n", hash, domainFolder, category, filename)
+		}
+		return nil, ObjectInfo{}, err
+	}
+	info.ModTime = ref.ModTime
+	return f, info, nil
+}
+
+// OpenSidecar opens a precompressed copy stored next to a file, such as
+// app.js.br for app.js. It returns a nil file and a nil error when there is
+// no such copy.
+func (s *Storage) OpenSidecar(domainFolder, category, filename, suffix string) (File, ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !validPathElement(domainFolder) || !validPathElement(category) || !validPathElement(filename+suffix) {
+		return nil, ObjectInfo{}, nil
+	}
+
+	f, info, err := s.openObject(domainFolder, category, filename+suffix)
+	if err != nil {
+		if errors.Is(err, ErrFileNotFound) {
+			return nil, ObjectInfo{}, nil
+		}
+		return nil, ObjectInfo{}, fmt.Errorf("failed to open sidecar: %w", err)
+	}
+	return f, info, nil
+}
+
+// FileETag returns the ETag of an open file. Blobs are named after their
+// hash; for other objects the hash is computed by streaming the file once and
+// cached until the file's size or modification time changes. The file is left
+// positioned at its start.
+func (s *Storage) FileETag(f File, info ObjectInfo) (string, error) {
+	if hash, ok := blobHash(info.Key); ok {
+		return fmt.Sprintf(`"%s"`, hash[:16]), nil
+	}
+
+	s.etagMu.Lock()
+	entry, ok := s.etags[info.Key]
+	s.etagMu.Unlock()
+	if ok && entry.size == info.Size && entry.modTime.Equal(info.ModTime) {
+		return entry.etag, nil
+	}
+
+	hash := sha256.New()
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to hash file: %w", err)
+	}
+	if _, err := io.CopyN(hash, f, info.Size); err != nil {
+		return "", fmt.Errorf("failed to hash file: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to rewind file: %w", err)
+	}
+	etag := fmt.Sprintf(`"%s"`, hex.EncodeToString(hash.Sum(nil)[:8]))
+
+	s.etagMu.Lock()
+	s.etags[info.Key] = etagEntry{size: info.Size, modTime: info.ModTime, etag: etag}
+	s.etagMu.Unlock()
+
+	return etag, nil
+}
+
+// FileHash returns the SHA-256 and object info of a stored file. Files kept
+// as references know their hash already; older plain files are read once.
+func (s *Storage) FileHash(domainFolder, category, filename string) (string, ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !validPathElement(domainFolder) || !validPathElement(category) || !validPathElement(filename) {
+		return "", ObjectInfo{}, ErrFileNotFound
+	}
+
+	hash, ref, ok, err := s.lookupRef(domainFolder, category, filename)
+	if err != nil {
+		return "", ObjectInfo{}, err
+	}
+	if ok {
+		info, err := s.backend.Stat(blobKey(hash))
+		if err != nil {
+			return "", ObjectInfo{}, err
+		}
+		info.ModTime = ref.ModTime
+		return hash, info, nil
+	}
+
+	f, info, err := s.backend.Open(objectKey(domainFolder, category, filename))
+	if err != nil {
+		return "", ObjectInfo{}, err
+	}
+	defer f.Close()
+
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return "", ObjectInfo{}, fmt.Errorf("failed to hash file: %w", err)
+	}
+	return hex.EncodeToString(sum.Sum(nil)), info, nil
 }
 
 func (s *Storage) DeleteFile(domainFolder, category, filename string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	filePath := filepath.Join(s.basePath, domainFolder, category, filename)
+	if !validPathElement(domainFolder) || !validPathElement(category) || !validPathElement(filename) {
+		return ErrFileNotFound
+	}
+
+	return s.deleteFile(domainFolder, category, filename)
+}
 
-	if err := os.Remove(filePath); err != nil {
-		if os.IsNotExist(err) {
-			return fmt.Errorf("file not found")
+// deleteFile removes a file and its cached variants. The caller must hold
+// s.mu for writing.
+func (s *Storage) deleteFile(domainFolder, category, filename string) error {
+	hash, _, ok, err := s.lookupRef(domainFolder, category, filename)
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		s.forgetMarker(refPrefix(domainFolder, category, filename) + hash)
+		if err := s.backend.Delete(refPrefix(domainFolder, category, filename) + hash); err != nil {
+			return err
 		}
-		return fmt.Errorf("failed to delete file: %w", err)
+		if err := s.releaseBlob(hash); err != nil {
+			fmt.Printf("[Storage] Failed to release blob %s: %v\n", hash, err)
+		}
+	} else {
+		key := objectKey(domainFolder, category, filename)
+		if err := s.backend.Delete(key); err != nil {
+			return err
+		}
+
+		s.etagMu.Lock()
+		delete(s.etags, key)
+		s.etagMu.Unlock()
+	}
+
+	if err := s.deletePrefix(s.variantPrefix(domainFolder, category, filename)); err != nil {
+		fmt.Printf("[Storage] Failed to remove cached variants of %s/%s/%s: %v\n", domainFolder, category, filename, err)
 	}
 
 	return nil
 }
 
+// deletePrefix removes every object whose key starts with prefix.
+func (s *Storage) deletePrefix(prefix string) error {
+	objects, err := s.backend.List(prefix)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if err := s.backend.Delete(obj.Key); err != nil && !errors.Is(err, ErrFileNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *Storage) ListFiles(domainFolder, category string) ([]string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	dirPath := filepath.Join(s.basePath, domainFolder, category)
+	if !validPathElement(domainFolder) || !validPathElement(category) {
+		return []string{}, nil
+	}
+
+	seen := make(map[string]bool)
+	files := []string{}
 
-	entries, err := os.ReadDir(dirPath)
+	// Plain files stored before references existed
+	prefix := objectKey(domainFolder, category) + "/"
+	objects, err := s.backend.List(prefix)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return []string{}, nil
+		return nil, err
+	}
+	for _, obj := range objects {
+		name := strings.TrimPrefix(obj.Key, prefix)
+		// Skip nested objects and temporary files
+		if strings.Contains(name, "/") || strings.HasPrefix(name, ".") {
+			continue
 		}
-		return nil, fmt.Errorf("failed to read directory: %w", err)
+		seen[name] = true
+		files = append(files, name)
 	}
 
-	var files []string
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			files = append(files, entry.Name())
+	refs, err := s.backend.List(objectKey(refsDir, domainFolder, category) + "/")
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range refs {
+		name, _, _ := strings.Cut(strings.TrimPrefix(ref.Key, objectKey(refsDir, domainFolder, category)+"/"), "/")
+		if !seen[name] {
+			seen[name] = true
+			files = append(files, name)
 		}
 	}
 
@@ -120,33 +378,198 @@ func (s *Storage) ListFiles(domainFolder, category string) ([]string, error) {
 	return files, nil
 }
 
-func DownloadFile(url string) ([]byte, string, error) {
-	resp, err := http.Get(url)
+// variantPrefix is the key prefix of the cached variants of a stored file.
+func (s *Storage) variantPrefix(domainFolder, category, filename string) string {
+	return objectKey(cacheDir, domainFolder, category, filename) + "/"
+}
+
+// OpenVariant opens a cached variant of a stored file. It returns a nil file
+// and a nil error when the variant has not been generated yet.
+func (s *Storage) OpenVariant(domainFolder, category, filename, key string) (File, ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !validPathElement(domainFolder) || !validPathElement(category) || !validPathElement(filename) || !validPathElement(key) {
+		return nil, ObjectInfo{}, nil
+	}
+
+	f, info, err := s.backend.Open(s.variantPrefix(domainFolder, category, filename) + key)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to download file: %w", err)
+		if errors.Is(err, ErrFileNotFound) {
+			return nil, ObjectInfo{}, nil
+		}
+		return nil, ObjectInfo{}, fmt.Errorf("failed to open variant: %w", err)
+	}
+
+	return f, info, nil
+}
+
+// StoreVariant caches a variant of a stored file. Backends never expose a
+// partially written variant to readers. A variant of a file deleted or
+// renamed while it was being made is dropped, so it cannot outlive the file.
+func (s *Storage) StoreVariant(domainFolder, category, filename, key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !validPathElement(domainFolder) || !validPathElement(category) || !validPathElement(filename) || !validPathElement(key) {
+		return fmt.Errorf("invalid variant path")
+	}
+
+	if _, _, ok, err := s.lookupRef(domainFolder, category, filename); err != nil {
+		return err
+	} else if !ok {
+		if _, err := s.backend.Stat(objectKey(domainFolder, category, filename)); err != nil {
+			return err
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("download failed with status: %d", resp.StatusCode)
+	if err := s.backend.Put(s.variantPrefix(domainFolder, category, filename)+key, bytes.NewReader(data), int64(len(data)), ""); err != nil {
+		return fmt.Errorf("failed to store variant: %w", err)
 	}
+	return nil
+}
 
-	data, err := io.ReadAll(resp.Body)
+// CountVariants returns how many variants are cached for a stored file.
+func (s *Storage) CountVariants(domainFolder, category, filename string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prefix := s.variantPrefix(domainFolder, category, filename)
+	objects, err := s.backend.List(prefix)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to read response body: %w", err)
+		return 0
 	}
 
-	contentType := resp.Header.Get("Content-Type")
-	if contentType == "" {
-		contentType = http.DetectContentType(data)
+	count := 0
+	for _, obj := range objects {
+		name := strings.TrimPrefix(obj.Key, prefix)
+		if !strings.Contains(name, "/") && !strings.HasPrefix(name, ".") {
+			count++
+		}
 	}
+	return count
+}
 
-	// Clean up content type (remove charset, etc.)
-	if idx := strings.Index(contentType, ";"); idx != -1 {
-		contentType = strings.TrimSpace(contentType[:idx])
+// tempFileRemover is implemented by backends whose writes can leave
+// temporary files behind when interrupted.
+type tempFileRemover interface {
+	RemoveTempFiles() ([]string, error)
+}
+
+// RemoveTempFiles deletes the temporary files interrupted writes left in the
+// backend and returns their keys. It must run before anything writes to the
+// storage, since in-flight writes use temporary files too.
+func (s *Storage) RemoveTempFiles() ([]string, error) {
+	remover, ok := s.backend.(tempFileRemover)
+	if !ok {
+		return nil, nil
 	}
 
-	return data, contentType, nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return remover.RemoveTempFiles()
+}
+
+// CheckWritable verifies that the storage backend accepts writes.
+func (s *Storage) CheckWritable() error {
+	return s.backend.Check()
+}
+
+// Usage returns the bytes and file counts stored under each domain folder.
+// Results are cached for a short while since computing them lists every
+// stored object.
+func (s *Storage) Usage() (map[string]Usage, error) {
+	s.usageMu.Lock()
+	defer s.usageMu.Unlock()
+
+	if s.usage != nil && time.Since(s.usageUpdated) < usageCacheTTL {
+		return s.usage, nil
+	}
+
+	objects, err := s.backend.List("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute storage usage: %w", err)
+	}
+
+	// References count the full size of their blob towards their domain,
+	// so usage reflects what each domain stores regardless of sharing
+	blobSizes := make(map[string]int64)
+	for _, obj := range objects {
+		if hash, ok := blobHash(obj.Key); ok {
+			blobSizes[hash] = obj.Size
+		}
+	}
+
+	usage := make(map[string]Usage)
+	for _, obj := range objects {
+		dirs := strings.Split(obj.Key, "/")
+		name := dirs[len(dirs)-1]
+		dirs = dirs[:len(dirs)-1]
+
+		size := obj.Size
+		if len(dirs) == 4 && dirs[0] == refsDir {
+			// .refs/<domain>/<category>/<filename>/<hash>
+			size = blobSizes[name]
+			dirs = dirs[1:]
+		}
+		if len(dirs) == 0 || hasDotElement(dirs) {
+			// Top-level files, caches and reserved folders are not domain content
+			continue
+		}
+
+		u := usage[dirs[0]]
+		u.Bytes += size
+		u.Files++
+		usage[dirs[0]] = u
+	}
+
+	s.usage = usage
+	s.usageUpdated = time.Now()
+	return usage, nil
+}
+
+func hasDotElement(elems []string) bool {
+	for _, e := range elems {
+		if strings.HasPrefix(e, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterMetrics exposes per-domain storage usage on the default metrics
+// registry.
+func (s *Storage) RegisterMetrics() {
+	collect := func(value func(Usage) int64) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			usage, err := s.Usage()
+			if err != nil {
+				return nil
+			}
+			samples := make([]metrics.Sample, 0, len(usage))
+			for domainFolder, u := range usage {
+				samples = append(samples, metrics.Sample{
+					LabelValues: []string{domainFolder},
+					Value:       float64(value(u)),
+				})
+			}
+			return samples
+		}
+	}
+
+	metrics.NewGaugeFunc("vixa_storage_bytes", "Bytes stored per domain folder.", []string{"domain"},
+		collect(func(u Usage) int64 { return u.Bytes }))
+	metrics.NewGaugeFunc("vixa_storage_files", "Files stored per domain folder.", []string{"domain"},
+		collect(func(u Usage) int64 { return u.Files }))
+}
+
+// validPathElement reports whether name is safe to use as a single path
+// element under the storage root.
+func validPathElement(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	return !strings.ContainsAny(name, `/\`)
 }
 
 func GenerateETag(data []byte) string {
@@ -0,0 +1,25 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	s, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func spoolString(t *testing.T, s *Storage, content string) *Upload {
+	t.Helper()
+	upload, err := s.Spool(strings.NewReader(content), 0, "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { upload.Close() })
+	return upload
+}
@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Deleted files are moved to a trash area so they can be restored until the
+// trash is purged. A trashed file is a marker object
+// .trash/<domain>/<category>/<filename>/<sha256> that keeps its blob
+// referenced, like the markers under .refs; its modification time is when
+// the file was trashed, and it holds the upload time, which the file gets
+// back when it is restored. Files stored before references existed are moved
+// into a blob when they are trashed.
+const trashDir = ".trash"
+
+// ErrFileExists is returned when restoring a file whose name is taken.
+var ErrFileExists = errors.New("file already exists")
+
+// TrashedFile describes a file in the trash.
+type TrashedFile struct {
+	Domain    string
+	Category  string
+	Filename  string
+	Size      int64
+	TrashedAt time.Time
+}
+
+// trashPrefix is the key prefix of the trash marker of a file.
+func trashPrefix(domainFolder, category, filename string) string {
+	return objectKey(trashDir, domainFolder, category, filename) + "/"
+}
+
+// lookupTrash returns the blob hash of a trashed file and its marker's info.
+func (s *Storage) lookupTrash(domainFolder, category, filename string) (hash string, marker ObjectInfo, ok bool, err error) {
+	prefix := trashPrefix(domainFolder, category, filename)
+	markers, err := s.backend.List(prefix)
+	if err != nil {
+		return "", ObjectInfo{}, false, err
+	}
+	for _, m := range markers {
+		hash := strings.TrimPrefix(m.Key, prefix)
+		if len(hash) == 64 && !strings.Contains(hash, "/") {
+			return hash, m, true, nil
+		}
+	}
+	return "", ObjectInfo{}, false, nil
+}
+
+// TrashFile moves a file to the trash. It stops being served at once but
+// can be restored with RestoreFile until the trash is purged.
+func (s *Storage) TrashFile(domainFolder, category, filename string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !validPathElement(domainFolder) || !validPathElement(category) || !validPathElement(filename) {
+		return ErrFileNotFound
+	}
+
+	hash, ref, ok, err := s.lookupRef(domainFolder, category, filename)
+	if err != nil {
+		return err
+	}
+
+	// An older trashed file of the same name is replaced
+	if old, marker, found, err := s.lookupTrash(domainFolder, category, filename); err != nil {
+		return err
+	} else if found {
+		if err := s.purgeMarker(marker.Key, old); err != nil {
+			return err
+		}
+	}
+
+	if ok {
+		err = s.moveMarker(refPrefix(domainFolder, category, filename)+hash, trashPrefix(domainFolder, category, filename)+hash, hash, ref.ModTime)
+	} else {
+		err = s.trashPlainFile(domainFolder, category, filename)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.deletePrefix(s.variantPrefix(domainFolder, category, filename)); err != nil {
+		fmt.Printf("[Storage] Failed to remove cached variants of %s/%s/%s: %v\n", domainFolder, category, filename, err)
+	}
+	return nil
+}
+
+// trashPlainFile moves a file stored before references existed into a blob
+// referenced from the trash. The caller must hold s.mu for writing.
+func (s *Storage) trashPlainFile(domainFolder, category, filename string) error {
+	key := objectKey(domainFolder, category, filename)
+	f, info, err := s.backend.Open(key)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return fmt.Errorf("failed to hash file: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind file: %w", err)
+	}
+	hash := hex.EncodeToString(sum.Sum(nil))
+
+	if err := s.addMarker(trashPrefix(domainFolder, category, filename)+hash, hash, f, info.Size, info.ContentType, info.ModTime); err != nil {
+		return err
+	}
+	if err := s.backend.Delete(key); err != nil {
+		return err
+	}
+
+	s.etagMu.Lock()
+	delete(s.etags, key)
+	s.etagMu.Unlock()
+	return nil
+}
+
+// RestoreFile moves a file out of the trash back to its URL. It returns
+// ErrFileNotFound when the file is not in the trash and ErrFileExists when
+// another file has taken its name.
+func (s *Storage) RestoreFile(domainFolder, category, filename string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !validPathElement(domainFolder) || !validPathElement(category) || !validPathElement(filename) {
+		return ErrFileNotFound
+	}
+
+	hash, marker, ok, err := s.lookupTrash(domainFolder, category, filename)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrFileNotFound
+	}
+
+	if _, _, live, err := s.lookupRef(domainFolder, category, filename); err != nil {
+		return err
+	} else if live {
+		return ErrFileExists
+	}
+	if _, err := s.backend.Stat(objectKey(domainFolder, category, filename)); err == nil {
+		return ErrFileExists
+	} else if !errors.Is(err, ErrFileNotFound) {
+		return err
+	}
+
+	// Files trashed before markers held their upload time count as
+	// uploaded now
+	uploadedAt, err := s.markerUploadTime(marker)
+	if err != nil {
+		return err
+	}
+	return s.moveMarker(marker.Key, refPrefix(domainFolder, category, filename)+hash, hash, uploadedAt)
+}
+
+// ListTrash returns the files in a domain's trash, most recently trashed
+// first.
+func (s *Storage) ListTrash(domainFolder string) ([]TrashedFile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !validPathElement(domainFolder) {
+		return []TrashedFile{}, nil
+	}
+
+	files, err := s.listTrash(objectKey(trashDir, domainFolder) + "/")
+	if err != nil {
+		return nil, err
+	}
+	for i, f := range files {
+		if info, err := s.backend.Stat(blobKey(f.hash)); err == nil {
+			files[i].Size = info.Size
+		}
+	}
+
+	result := make([]TrashedFile, len(files))
+	for i, f := range files {
+		result[i] = f.TrashedFile
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].TrashedAt.After(result[j].TrashedAt)
+	})
+	return result, nil
+}
+
+// PurgeTrash permanently deletes the files trashed before cutoff, in every
+// domain, and returns them.
+func (s *Storage) PurgeTrash(cutoff time.Time) ([]TrashedFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := s.listTrash(trashDir + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	purged := []TrashedFile{}
+	var errs []error
+	for _, f := range files {
+		if !f.TrashedAt.Before(cutoff) {
+			continue
+		}
+		if err := s.purgeMarker(f.key, f.hash); err != nil {
+			errs = append(errs, fmt.Errorf("%s/%s/%s: %w", f.Domain, f.Category, f.Filename, err))
+			continue
+		}
+		purged = append(purged, f.TrashedFile)
+	}
+	return purged, errors.Join(errs...)
+}
+
+// purgeMarker deletes a trash marker and releases its blob. The caller must
+// hold s.mu for writing.
+func (s *Storage) purgeMarker(key, hash string) error {
+	if err := s.backend.Delete(key); err != nil && !errors.Is(err, ErrFileNotFound) {
+		return err
+	}
+	return s.releaseBlob(hash)
+}
+
+type trashMarker struct {
+	TrashedFile
+	key  string
+	hash string
+}
+
+// listTrash parses the trash markers under prefix.
+func (s *Storage) listTrash(prefix string) ([]trashMarker, error) {
+	objects, err := s.backend.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	markers := []trashMarker{}
+	for _, obj := range objects {
+		// .trash/<domain>/<category>/<filename>/<hash>
+		parts := strings.Split(obj.Key, "/")
+		if len(parts) != 5 || len(parts[4]) != 64 {
+			continue
+		}
+		markers = append(markers, trashMarker{
+			TrashedFile: TrashedFile{
+				Domain:    parts[1],
+				Category:  parts[2],
+				Filename:  parts[3],
+				TrashedAt: obj.ModTime,
+			},
+			key:  obj.Key,
+			hash: parts[4],
+		})
+	}
+	return markers, nil
+}